@@ -25,6 +25,7 @@ import (
 	flag "github.com/spf13/pflag"
 
 	"github.com/ffromani/numalign/pkg/cpusetinfo"
+	"github.com/ffromani/numalign/pkg/snapshot"
 )
 
 type result struct {
@@ -35,12 +36,26 @@ type result struct {
 }
 
 func main() {
+	var snapshotPath = flag.String("snapshot", "", "run against this snapshot (see lsnt snapshot) instead of the live system.")
 	flag.Parse()
 	pids := flag.Args()
 
+	env, err := snapshot.OpenEnv(*snapshotPath, snapshot.Env{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error opening the snapshot %q: %v\n", *snapshotPath, err)
+		os.Exit(1)
+	}
+	defer env.Cleanup()
+	exit := env.Exit
+	fsh := cpusetinfo.FSHandle{
+		CGroupsMountPoint: env.CGroupsRoot,
+		ProcMountPoint:    env.ProcFSRoot,
+		SysMountPoint:     env.SysFSRoot,
+	}
+
 	if len(pids) != 0 && len(pids) != 1 {
 		flag.Usage()
-		os.Exit(1)
+		exit(1)
 	}
 
 	pid := 0
@@ -48,17 +63,15 @@ func main() {
 		v, err := strconv.Atoi(pids[0])
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "bad argument %q: %v\n", pids[0], err)
-			os.Exit(2)
+			exit(2)
 		}
 		pid = v
 	}
 
-	fsh := cpusetinfo.FSHandle{}
-
 	cpus, err := cpusetinfo.GetCPUSetForPID(fsh, pid)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "cannot fetch the cpuset for pid %d: %v\n", pid, err)
-		os.Exit(2)
+		exit(2)
 	}
 
 	tsm := cpusetinfo.NewThreadSiblingMap(fsh)
@@ -66,7 +79,7 @@ func main() {
 	misaligned, err := tsm.CheckCPUSetAligned(cpus)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "cannot check the cpuset for pid %d: %v\n", pid, err)
-		os.Exit(4)
+		exit(4)
 	}
 
 	err = json.NewEncoder(os.Stdout).Encode(result{
@@ -77,6 +90,6 @@ func main() {
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "cannot encode the result: %v\n", err)
-		os.Exit(8)
+		exit(8)
 	}
 }
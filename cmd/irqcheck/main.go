@@ -18,18 +18,16 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
-	"sort"
-	"strconv"
-	"strings"
 
 	flag "github.com/spf13/pflag"
 
 	"github.com/ffromani/cpuset"
 	k8scpuset "k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
 
+	"github.com/ffromani/numalign/pkg/irqs"
+	"github.com/ffromani/numalign/pkg/snapshot"
 	"github.com/ffromani/numalign/pkg/softirqs"
 )
 
@@ -39,10 +37,20 @@ func main() {
 		flag.PrintDefaults()
 	}
 	var procfsRoot = flag.StringP("procfs", "P", "/proc", "procfs mount point to use.")
+	var snapshotPath = flag.String("snapshot", "", "run against this snapshot (see lsnt snapshot) instead of the live system.")
 	var checkEffective = flag.BoolP("effective-affinity", "E", false, "check effective affinity.")
 	var checkSoftirqs = flag.BoolP("softirqs", "S", false, "check softirqs counters.")
 	flag.Parse()
 
+	env, err := snapshot.OpenEnv(*snapshotPath, snapshot.Env{ProcFSRoot: *procfsRoot})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error opening the snapshot %q: %v\n", *snapshotPath, err)
+		os.Exit(1)
+	}
+	defer env.Cleanup()
+	exit := env.Exit
+	*procfsRoot = env.ProcFSRoot
+
 	isolCpuList := "0-65535" // "everything"
 	args := flag.Args()
 	if len(args) == 1 {
@@ -52,85 +60,51 @@ func main() {
 	isolCpus, err := k8scpuset.Parse(isolCpuList)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error parsing %q: %v", isolCpuList, err)
-		os.Exit(1)
+		exit(1)
 	}
 
 	if *checkSoftirqs {
 		info, err := readSoftirqInfo(*procfsRoot)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error parsing softirqs from %q: %v", *procfsRoot, err)
-			os.Exit(1)
+			exit(1)
 		}
 
 		dumpSoftirqInfo(info, isolCpus)
-		os.Exit(0)
+		exit(0)
 	}
 
-	irqRoot := filepath.Join(*procfsRoot, "irq")
 	var irqViolations []int
 
-	files, err := ioutil.ReadDir(irqRoot)
+	irqList, err := irqs.List(*procfsRoot)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error reading %q: %v", irqRoot, err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "error reading IRQs from %q: %v", *procfsRoot, err)
+		exit(1)
 	}
 
-	var irqs []int
-	for _, file := range files {
-		irq, err := strconv.Atoi(file.Name())
-		if err != nil {
-			continue // just skip not-irq-looking dirs
-		}
-		irqs = append(irqs, irq)
-	}
-
-	sort.Ints(irqs)
-
-	affinityListFile := "smp_affinity_list"
+	affinityListFile := irqs.SMPAffinityList
 	if *checkEffective {
-		affinityListFile = "effective_affinity_list"
+		affinityListFile = irqs.EffectiveAffinityList
 	}
 
-	for _, irq := range irqs {
-		irqDir := filepath.Join(irqRoot, fmt.Sprintf("%d", irq))
-
-		irqCpuList, err := ioutil.ReadFile(filepath.Join(irqDir, affinityListFile))
+	for _, irq := range irqList {
+		irqCpuList, err := irqs.ReadAffinityList(*procfsRoot, irq, affinityListFile)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error reading %q for IRQ %d: %v\n", affinityListFile, irq, err)
 			continue // keep running
 		}
 
-		irqCpus, err := k8scpuset.Parse(strings.TrimSpace(string(irqCpuList)))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error parsing %q for IRQ %d: %v\n", affinityListFile, irq, err)
-			continue // keep running
-		}
-
-		cpus := irqCpus.Intersection(isolCpus)
+		cpus := k8scpuset.NewCPUSet(irqCpuList...).Intersection(isolCpus)
 		if cpus.Size() != 0 {
-			source := findSourceForIRQ(*procfsRoot, irq)
+			source := irqs.FindSource(*procfsRoot, irq)
 			fmt.Printf("IRQ %3d [%24s]: can run on %v\n", irq, source, cpus.ToSlice())
 			irqViolations = append(irqViolations, irq)
 		}
 	}
 
 	if len(irqViolations) > 0 {
-		os.Exit(1)
-	}
-}
-
-func findSourceForIRQ(procfs string, irq int) string {
-	irqDir := filepath.Join(procfs, "irq", fmt.Sprintf("%d", irq))
-	files, err := ioutil.ReadDir(irqDir)
-	if err != nil {
-		return "MISSING"
-	}
-	for _, file := range files {
-		if file.IsDir() {
-			return file.Name()
-		}
+		exit(1)
 	}
-	return ""
 }
 
 func readSoftirqInfo(procfs string) (*softirqs.Info, error) {
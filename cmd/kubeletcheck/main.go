@@ -0,0 +1,99 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/ffromani/numalign/internal/pkg/kubeletcheck"
+	"github.com/ffromani/numalign/pkg/cpusetinfo"
+	"github.com/ffromani/numalign/pkg/kubeletstate"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	var kubeletDir = flag.StringP("kubelet-dir", "K", kubeletstate.DefaultKubeletDir, "kubelet state directory to use.")
+	var procfsRoot = flag.StringP("procfs", "P", cpusetinfo.DefaultProcMountPoint, "procfs mount point to use.")
+	var sysfsRoot = flag.StringP("sysfs", "S", cpusetinfo.DefaultSysMountPoint, "sysfs mount point to use.")
+	var cgroupfsRoot = flag.StringP("cgroupfs", "C", cpusetinfo.DefaultCGroupsMountPoint, "cgroupfs mount point to use.")
+	var jsonOutput = flag.BoolP("json", "J", false, "output in JSON")
+	flag.Parse()
+
+	if _, ok := os.LookupEnv("NUMALIGN_DEBUG"); !ok {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	st, err := kubeletstate.FromDir(*kubeletDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading the kubelet state from %q: %v\n", *kubeletDir, err)
+		os.Exit(1)
+	}
+	if st.CPUManager == nil && st.MemoryManager == nil && st.Devices == nil {
+		fmt.Fprintf(os.Stderr, "no kubelet state found in %q\n", *kubeletDir)
+		os.Exit(1)
+	}
+
+	fsh := cpusetinfo.FSHandle{
+		CGroupsMountPoint: *cgroupfsRoot,
+		ProcMountPoint:    *procfsRoot,
+		SysMountPoint:     *sysfsRoot,
+	}
+	cnts, err := kubeletcheck.FindContainers(fsh)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error finding the containers: %v\n", err)
+		os.Exit(1)
+	}
+
+	drifts, err := kubeletcheck.Check(st, cnts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error checking the kubelet state: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *jsonOutput {
+		if drifts == nil {
+			drifts = []kubeletcheck.Drift{}
+		}
+		err = json.NewEncoder(os.Stdout).Encode(drifts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot encode the result: %v\n", err)
+			os.Exit(1)
+		}
+	} else if len(drifts) > 0 {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "POD UID\tCONTAINER\tKIND\tEXPECTED\tACTUAL\n")
+		for _, drift := range drifts {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", drift.PodUID, drift.Container, drift.Kind, drift.Expected, drift.Actual)
+		}
+		tw.Flush()
+	}
+
+	if len(drifts) > 0 {
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/disiqueira/gotree"
+	"github.com/spf13/cobra"
+
+	"github.com/ffromani/numalign/pkg/topologyinfo/block"
+	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
+)
+
+func showBlockDevs(cmd *cobra.Command, args []string) error {
+	blockDevs, err := block.NewBlockDevices(opts.sysFSRoot)
+	if err != nil {
+		return err
+	}
+
+	perNUMA := blockDevs.PerNUMA()
+	var nodeIDs []int
+	for nodeID := range perNUMA {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Ints(nodeIDs)
+
+	sys := gotree.New(".")
+	for _, nodeID := range nodeIDs {
+		var numaNode gotree.Tree
+		if nodeID == pcidev.NUMANodeUnknown {
+			numaNode = sys.Add("UNKNOWN")
+		} else {
+			numaNode = sys.Add(fmt.Sprintf("numa%02d", nodeID))
+		}
+		for _, bdi := range perNUMA[nodeID] {
+			numaNode.Add(fmt.Sprintf("%s %s pci@%s %s", bdi.Name, bdi.Kind, bdi.Address, humanSize(bdi.SizeBytes)))
+		}
+	}
+	fmt.Println(sys.Print())
+	return nil
+}
+
+func humanSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%dB", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+func newBlockDevsCommand() *cobra.Command {
+	show := &cobra.Command{
+		Use:   "blockdevs",
+		Short: "show per-NUMA block devices backed by PCI devices",
+		RunE:  showBlockDevs,
+		Args:  cobra.NoArgs,
+	}
+	return show
+}
@@ -35,7 +35,7 @@ func waitForever(dwOpts *daemonwaitOpts) error {
 		ioutil.WriteFile(dwOpts.healthFile, message, 0644) // intentionally ignore error
 	}
 
-	exitSignal := make(chan os.Signal)
+	exitSignal := make(chan os.Signal, 1)
 	signal.Notify(exitSignal, syscall.SIGINT, syscall.SIGTERM)
 	<-exitSignal
 	return nil
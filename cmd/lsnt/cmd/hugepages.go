@@ -0,0 +1,61 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ffromani/numalign/pkg/topologyinfo/numa"
+)
+
+func showHugePages(cmd *cobra.Command, args []string) error {
+	hpInfos, err := numa.NewHugePagesFromSysFS(opts.sysFSRoot)
+	if err != nil {
+		return err
+	}
+
+	var nodeIDs []int
+	for nodeID := range hpInfos {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Ints(nodeIDs)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "NODE\tSIZE\tTOTAL\tFREE\tSURPLUS\t\n")
+	for _, nodeID := range nodeIDs {
+		for _, hp := range hpInfos[nodeID] {
+			fmt.Fprintf(w, "%d\t%dkB\t%d\t%d\t%d\t\n", nodeID, hp.SizeKB, hp.Total, hp.Free, hp.Surplus)
+		}
+	}
+	w.Flush()
+	return nil
+}
+
+func newHugePagesCommand() *cobra.Command {
+	show := &cobra.Command{
+		Use:   "hugepages",
+		Short: "show per-NUMA hugepages",
+		RunE:  showHugePages,
+		Args:  cobra.NoArgs,
+	}
+	return show
+}
@@ -17,30 +17,143 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/disiqueira/gotree"
+	"github.com/ffromani/cpuset"
 	"github.com/spf13/cobra"
 
+	"github.com/ffromani/numalign/pkg/pciids"
 	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
 )
 
 type pcidevOpts struct {
-	showTree     bool
-	networkOnly  bool
-	showVFParent bool
-	sysfsRoot    string
+	showTree      bool
+	networkOnly   bool
+	showVFParent  bool
+	showWide      bool
+	showJSON      bool
+	showHierarchy bool
+	filterExpr    string
+	filter        pcidev.Filter
 }
 
-func (pd pcidevOpts) IsInterestingDevice(dc int64) bool {
-	if !pd.networkOnly {
+// pciDevJSON is the JSON representation of a PCI device
+type pciDevJSON struct {
+	Address         string `json:"address"`
+	Class           string `json:"class"`
+	Vendor          string `json:"vendor"`
+	Device          string `json:"device"`
+	SubsystemVendor string `json:"subsystemvendor,omitempty"`
+	SubsystemDevice string `json:"subsystemdevice,omitempty"`
+	ClassName       string `json:"classname,omitempty"`
+	VendorName      string `json:"vendorname,omitempty"`
+	DeviceName      string `json:"devicename,omitempty"`
+	SubsystemName   string `json:"subsystemname,omitempty"`
+	NUMANode        int    `json:"numanode"`
+	Driver          string `json:"driver,omitempty"`
+	IOMMUGroup      int    `json:"iommugroup"`
+	LocalCPUs       string `json:"localcpus,omitempty"`
+	LinkSpeed       string `json:"linkspeed,omitempty"`
+	LinkWidth       int    `json:"linkwidth,omitempty"`
+	MaxLinkSpeed    string `json:"maxlinkspeed,omitempty"`
+	MaxLinkWidth    int    `json:"maxlinkwidth,omitempty"`
+	LinkDegraded    bool   `json:"linkdegraded"`
+	PhysFn          bool   `json:"physfn"`
+	NumVFs          int    `json:"numvfs,omitempty"`
+	TotalVFs        int    `json:"totalvfs,omitempty"`
+	VFn             bool   `json:"vfn"`
+	ParentFn        string `json:"parentfn,omitempty"`
+	RDMA            string `json:"rdma,omitempty"`
+}
+
+func (pd pcidevOpts) IsInterestingDevice(devInfo pcidev.PCIDeviceInfo) bool {
+	if pd.networkOnly && devInfo.DevClass() != pcidev.DevClassNetwork {
+		return false
+	}
+	return pd.filter.Match(devInfo)
+}
+
+// isInterestingBranch returns true if a device, or any device behind it, is interesting
+func (pd pcidevOpts) isInterestingBranch(pciDevs *pcidev.PCIDevices, devInfo pcidev.PCIDeviceInfo) bool {
+	if pd.IsInterestingDevice(devInfo) {
 		return true
 	}
-	return dc == pcidev.DevClassNetwork
+	for _, child := range pciDevs.Children(devInfo.Address()) {
+		if pd.isInterestingBranch(pciDevs, child) {
+			return true
+		}
+	}
+	return false
 }
 
-func showPCIDevsTree(pdOpts *pcidevOpts, pciDevs *pcidev.PCIDevices) {
-	physFns := make(map[string]gotree.Tree)
+// newPCIDevices discovers the PCI devices and, unless we are asked to be numeric, sets their names
+func newPCIDevices() (*pcidev.PCIDevices, error) {
+	pciDevs, err := pcidev.NewPCIDevices(opts.sysFSRoot)
+	if err != nil {
+		return nil, err
+	}
+	if opts.numeric {
+		return pciDevs, nil
+	}
+	var db *pciids.DB
+	if opts.pciIDsPath != "" {
+		db, err = pciids.Load(opts.pciIDsPath)
+	} else {
+		db, err = pciids.LoadDefault()
+	}
+	if err != nil {
+		return nil, err
+	}
+	pciDevs.SetNames(db)
+	return pciDevs, nil
+}
+
+// nameDesc returns the lspci-like description of a device, ready to be appended to a line
+func nameDesc(devInfo pcidev.PCIDeviceInfo) string {
+	desc := pcidev.Describe(devInfo)
+	if desc == "" {
+		return ""
+	}
+	return " " + desc
+}
+
+// rdmaDescs describes the RDMA devices, by PCI address
+func rdmaDescs(pciDevs *pcidev.PCIDevices) (map[string]string, error) {
+	rdmaDevs, err := pciDevs.RDMADevices(opts.sysFSRoot)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]string)
+	for _, rdi := range rdmaDevs {
+		desc := fmt.Sprintf(" rdma=%s", rdi.Name)
+		if len(rdi.Netdevs) > 0 {
+			desc += fmt.Sprintf(" netdev=%s", strings.Join(rdi.Netdevs, ","))
+		}
+		ret[rdi.Address] += desc
+	}
+	return ret, nil
+}
+
+func showPCIDevsTree(pdOpts *pcidevOpts, pciDevs *pcidev.PCIDevices, rdmas map[string]string) error {
+	var sv *pcidev.SRIOVView
+	if pdOpts.showVFParent {
+		var err error
+		sv, err = pciDevs.SRIOV()
+		if err != nil {
+			return err
+		}
+	}
+	describe := func(devInfo pcidev.PCIDeviceInfo, extra string) string {
+		addr := devInfo.Address()
+		return fmt.Sprintf("%s %04x:%04x%s%s%s", addr, devInfo.Vendor(), devInfo.Device(), extra, rdmas[addr], nameDesc(devInfo))
+	}
+
 	sys := gotree.New(".")
 	for nodeID, devInfos := range pciDevs.PerNUMA() {
 		var numaNode gotree.Tree
@@ -52,53 +165,236 @@ func showPCIDevsTree(pdOpts *pcidevOpts, pciDevs *pcidev.PCIDevices) {
 
 		for _, devInfo := range devInfos {
 			dc := devInfo.DevClass()
-			parent := numaNode
-			addParent := false
+			if !pdOpts.IsInterestingDevice(devInfo) {
+				continue
+			}
 
 			extra := fmt.Sprintf(" (%04x)", dc)
 			if sriovInfo, ok := devInfo.(pcidev.SRIOVDeviceInfo); ok && (sriovInfo.IsPhysFn || sriovInfo.IsVFn) {
 				if sriovInfo.IsPhysFn {
 					extra = fmt.Sprintf(" physfn numvfs=%v", sriovInfo.NumVFS)
-					addParent = pdOpts.showVFParent
 				} else if sriovInfo.IsVFn {
-					if pDev, ok := physFns[sriovInfo.ParentFn]; ok {
-						parent = pDev
-						extra = fmt.Sprintf(" vfn")
-					} else {
-						extra = fmt.Sprintf(" vfn parent=%s", sriovInfo.ParentFn)
+					if sv != nil {
+						if pf, ok := sv.PFOf(devInfo.Address()); ok && pdOpts.IsInterestingDevice(pf.Device) {
+							// already reported under its physfn
+							continue
+						}
 					}
+					extra = fmt.Sprintf(" vfn parent=%s", sriovInfo.ParentFn)
 				} else {
 					extra = " ???"
 				}
 			}
-			if pdOpts.IsInterestingDevice(dc) {
-				addr := devInfo.Address()
-				phDev := parent.Add(fmt.Sprintf("%s %04x:%04x%s", addr, devInfo.Vendor(), devInfo.Device(), extra))
-				if addParent {
-					physFns[addr] = phDev
+			phDev := numaNode.Add(describe(devInfo, extra))
+			if sv == nil {
+				continue
+			}
+			for _, vf := range sv.VFsOf(devInfo.Address()) {
+				if pdOpts.IsInterestingDevice(vf.Device) {
+					phDev.Add(describe(vf.Device, " vfn"))
 				}
 			}
 		}
 	}
 	fmt.Println(sys.Print())
+	return nil
+}
+
+func makePCIDevJSON(devInfo pcidev.PCIDeviceInfo, rdma string) pciDevJSON {
+	link := devInfo.Link()
+	pdj := pciDevJSON{
+		Address:       devInfo.Address(),
+		Class:         fmt.Sprintf("%04x", devInfo.DevClass()),
+		Vendor:        fmt.Sprintf("%04x", devInfo.Vendor()),
+		Device:        fmt.Sprintf("%04x", devInfo.Device()),
+		ClassName:     devInfo.ClassName(),
+		VendorName:    devInfo.VendorName(),
+		DeviceName:    devInfo.DeviceName(),
+		SubsystemName: devInfo.SubsystemName(),
+		NUMANode:      devInfo.NUMANode(),
+		Driver:        devInfo.Driver(),
+		IOMMUGroup:    devInfo.IOMMUGroup(),
+		LocalCPUs:     cpuset.Unparse(devInfo.LocalCPUs()),
+		LinkSpeed:     link.CurrentSpeed,
+		LinkWidth:     link.CurrentWidth,
+		MaxLinkSpeed:  link.MaxSpeed,
+		MaxLinkWidth:  link.MaxWidth,
+		LinkDegraded:  link.Degraded(),
+		RDMA:          strings.TrimSpace(rdma),
+	}
+	if devInfo.SubsystemVendor() != 0 || devInfo.SubsystemDevice() != 0 {
+		pdj.SubsystemVendor = fmt.Sprintf("%04x", devInfo.SubsystemVendor())
+		pdj.SubsystemDevice = fmt.Sprintf("%04x", devInfo.SubsystemDevice())
+	}
+	if sriovInfo, ok := devInfo.(pcidev.SRIOVDeviceInfo); ok {
+		pdj.PhysFn = sriovInfo.IsPhysFn
+		pdj.NumVFs = sriovInfo.NumVFS
+		pdj.TotalVFs = sriovInfo.TotalVFS
+		pdj.VFn = sriovInfo.IsVFn
+		pdj.ParentFn = sriovInfo.ParentFn
+	}
+	return pdj
+}
+
+func showPCIDevsJSON(pdOpts *pcidevOpts, pciDevs *pcidev.PCIDevices, rdmas map[string]string) error {
+	pdjs := []pciDevJSON{}
+	for _, devInfo := range pciDevs.Items {
+		if pdOpts.IsInterestingDevice(devInfo) {
+			pdjs = append(pdjs, makePCIDevJSON(devInfo, rdmas[devInfo.Address()]))
+		}
+	}
+	data, err := json.Marshal(pdjs)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", data)
+	return nil
+}
+
+func showPCIDevsWide(pdOpts *pcidevOpts, pciDevs *pcidev.PCIDevices, rdmas map[string]string) {
+	orNone := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "ADDRESS\tCLASS\tID\tSUBSYSTEM\tNUMA\tDRIVER\tIOMMU\tLOCALCPUS\tLINK\tSRIOV\tDESCRIPTION\n")
+	for _, devInfo := range pciDevs.Items {
+		if !pdOpts.IsInterestingDevice(devInfo) {
+			continue
+		}
+		pdj := makePCIDevJSON(devInfo, rdmas[devInfo.Address()])
+
+		subsys := "-"
+		if pdj.SubsystemVendor != "" {
+			subsys = pdj.SubsystemVendor + ":" + pdj.SubsystemDevice
+		}
+		iommu := "-"
+		if pdj.IOMMUGroup != pcidev.IOMMUGroupNone {
+			iommu = fmt.Sprintf("%d", pdj.IOMMUGroup)
+		}
+		link := "-"
+		if pdj.MaxLinkSpeed != "" || pdj.MaxLinkWidth != 0 {
+			link = fmt.Sprintf("%s/x%d", pdj.LinkSpeed, pdj.LinkWidth)
+			if pdj.LinkDegraded {
+				link += fmt.Sprintf(" DEGRADED(max %s/x%d)", pdj.MaxLinkSpeed, pdj.MaxLinkWidth)
+			}
+		}
+		sriov := "-"
+		if pdj.PhysFn {
+			sriov = fmt.Sprintf("physfn %d/%d", pdj.NumVFs, pdj.TotalVFs)
+		} else if pdj.VFn {
+			sriov = fmt.Sprintf("vfn parent=%s", pdj.ParentFn)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s:%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s%s\n",
+			pdj.Address, pdj.Class, pdj.Vendor, pdj.Device, subsys, pdj.NUMANode,
+			orNone(pdj.Driver), iommu, orNone(pdj.LocalCPUs), link, sriov, orNone(pcidev.Describe(devInfo)), rdmas[pdj.Address])
+	}
+	tw.Flush()
+}
+
+func showPCIDevsHierarchy(pdOpts *pcidevOpts, pciDevs *pcidev.PCIDevices, rdmas map[string]string) {
+	var addBranch func(parent gotree.Tree, devInfo pcidev.PCIDeviceInfo)
+	addBranch = func(parent gotree.Tree, devInfo pcidev.PCIDeviceInfo) {
+		addr := devInfo.Address()
+		extra := fmt.Sprintf(" (%04x)", devInfo.DevClass())
+		if portType := devInfo.PortType(); portType.IsBridge() {
+			extra = " " + portType.String()
+		}
+		if link := devInfo.Link(); link.MaxWidth > 0 {
+			extra += fmt.Sprintf(" link=%s/x%d", link.CurrentSpeed, link.CurrentWidth)
+		}
+		node := parent.Add(fmt.Sprintf("%s %04x:%04x%s%s%s", addr, devInfo.Vendor(), devInfo.Device(), extra, rdmas[addr], nameDesc(devInfo)))
+		for _, child := range pciDevs.Children(addr) {
+			if pdOpts.isInterestingBranch(pciDevs, child) {
+				addBranch(node, child)
+			}
+		}
+	}
+
+	// the roots are root ports and integrated endpoints, each root complex belongs to one NUMA node
+	perNUMA := make(map[int]map[string]pcidev.PCIDeviceInfoList)
+	for _, devInfo := range pciDevs.Roots() {
+		if !pdOpts.isInterestingBranch(pciDevs, devInfo) {
+			continue
+		}
+		rootComplexes, ok := perNUMA[devInfo.NUMANode()]
+		if !ok {
+			rootComplexes = make(map[string]pcidev.PCIDeviceInfoList)
+			perNUMA[devInfo.NUMANode()] = rootComplexes
+		}
+		rootComplexes[devInfo.RootComplex()] = append(rootComplexes[devInfo.RootComplex()], devInfo)
+	}
+
+	var nodeIDs []int
+	for nodeID := range perNUMA {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Ints(nodeIDs)
+
+	sys := gotree.New(".")
+	for _, nodeID := range nodeIDs {
+		var numaNode gotree.Tree
+		if nodeID == pcidev.NUMANodeUnknown {
+			numaNode = sys.Add("UNKNOWN")
+		} else {
+			numaNode = sys.Add(fmt.Sprintf("numa%02d", nodeID))
+		}
+
+		var names []string
+		for name := range perNUMA[nodeID] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			rootComplex := numaNode.Add(name)
+			for _, devInfo := range perNUMA[nodeID][name] {
+				addBranch(rootComplex, devInfo)
+			}
+		}
+	}
+	fmt.Println(sys.Print())
 }
 
 func showPCIDevs(pdOpts *pcidevOpts) error {
-	pciDevs, err := pcidev.NewPCIDevices(pdOpts.sysfsRoot)
+	var err error
+	pdOpts.filter, err = pcidev.ParseFilter(pdOpts.filterExpr)
 	if err != nil {
 		return err
 	}
 
-	if pdOpts.showTree {
-		showPCIDevsTree(pdOpts, pciDevs)
+	pciDevs, err := newPCIDevices()
+	if err != nil {
+		return err
+	}
+
+	rdmas, err := rdmaDescs(pciDevs)
+	if err != nil {
+		return err
+	}
+
+	if pdOpts.showJSON {
+		return showPCIDevsJSON(pdOpts, pciDevs, rdmas)
+	}
+	if pdOpts.showHierarchy {
+		showPCIDevsHierarchy(pdOpts, pciDevs, rdmas)
+		return nil
+	}
+	if pdOpts.showWide {
+		showPCIDevsWide(pdOpts, pciDevs, rdmas)
 		return nil
 	}
+	if pdOpts.showTree {
+		return showPCIDevsTree(pdOpts, pciDevs, rdmas)
+	}
 
 	for nodeID, devInfos := range pciDevs.PerNUMA() {
 		for _, devInfo := range devInfos {
 			dc := devInfo.DevClass()
-			if pdOpts.IsInterestingDevice(dc) {
-				fmt.Printf("%s %04x: %04x:%04x (NUMA node %d)\n", devInfo.DevAddress(), dc, devInfo.Vendor(), devInfo.Device(), nodeID)
+			if pdOpts.IsInterestingDevice(devInfo) {
+				fmt.Printf("%s %04x: %04x:%04x (NUMA node %d)%s%s\n", devInfo.DevAddress(), dc, devInfo.Vendor(), devInfo.Device(), nodeID, rdmas[devInfo.Address()], nameDesc(devInfo))
 			}
 		}
 	}
@@ -115,9 +411,12 @@ func newPCIDevsCommand() *cobra.Command {
 		},
 		Args: cobra.NoArgs,
 	}
-	show.Flags().StringVarP(&flags.sysfsRoot, "sysfs", "S", "/sys", "sysfs mount point to use.")
 	show.Flags().BoolVarP(&flags.showTree, "show-tree", "T", false, "print per-NUMA device tree.")
 	show.Flags().BoolVarP(&flags.networkOnly, "network-only", "N", false, "print only network devices.")
 	show.Flags().BoolVarP(&flags.showVFParent, "show-vf-parent", "P", false, "move VFs under their parent PFs.")
+	show.Flags().BoolVarP(&flags.showWide, "wide", "W", false, "print one device per line with driver, IOMMU group, local CPUs, link and SRIOV details.")
+	show.Flags().BoolVarP(&flags.showHierarchy, "hierarchy", "H", false, "print per-NUMA tree of root complexes, root ports, switches and devices behind them.")
+	show.Flags().BoolVarP(&flags.showJSON, "json", "J", false, "print all the device details as JSON.")
+	show.Flags().StringVarP(&flags.filterExpr, "filter", "F", "", "print only the devices matching this expression, like 'class=0200 && numa=1 && driver=vfio-pci && vf'.")
 	return show
 }
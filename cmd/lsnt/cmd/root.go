@@ -20,15 +20,23 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
+
+	"github.com/ffromani/numalign/pkg/snapshot"
 )
 
 type cmdOpts struct {
-	sysFSRoot string
-	verbose   int
+	sysFSRoot    string
+	snapshotPath string
+	pciIDsPath   string
+	numeric      bool
+	verbose      int
 }
 
 var opts cmdOpts
 
+// snap is the snapshot being replayed, if any
+var snap *snapshot.Snapshot
+
 // NewRootCommand returns entrypoint command to interact with all other commands
 func NewRootCommand() *cobra.Command {
 
@@ -38,11 +46,32 @@ func NewRootCommand() *cobra.Command {
 		Run: func(cmd *cobra.Command, args []string) {
 			fmt.Fprint(cmd.OutOrStderr(), cmd.UsageString())
 		},
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if opts.snapshotPath == "" {
+				return nil
+			}
+			var err error
+			snap, err = snapshot.Open(opts.snapshotPath)
+			if err != nil {
+				return err
+			}
+			opts.sysFSRoot = snap.SysFSRoot()
+			return nil
+		},
+		PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+			if snap == nil {
+				return nil
+			}
+			return snap.Cleanup()
+		},
 		SilenceUsage:  true,
 		SilenceErrors: true,
 	}
 
-	root.Flags().StringVarP(&opts.sysFSRoot, "sysfs", "S", "/sys", "sysfs root")
+	root.PersistentFlags().StringVarP(&opts.sysFSRoot, "sysfs", "S", "/sys", "sysfs root")
+	root.PersistentFlags().StringVar(&opts.snapshotPath, "snapshot", "", "run against this snapshot (see the snapshot command) instead of the live system")
+	root.PersistentFlags().StringVar(&opts.pciIDsPath, "pci-ids", "", "read the PCI vendor, device and class names from this pci.ids file (default: the system database, or the builtin one)")
+	root.PersistentFlags().BoolVarP(&opts.numeric, "numeric", "n", false, "show PCI vendor, device and class IDs without names")
 	root.Flags().IntVar(&opts.verbose, "verbose", 1, "verbosiness level")
 
 	root.AddCommand(
@@ -50,7 +79,10 @@ func NewRootCommand() *cobra.Command {
 		newNUMACommand(),
 		newNUMADistCommand(),
 		newPCIDevsCommand(),
+		newBlockDevsCommand(),
+		newHugePagesCommand(),
 		newDaemonWaitCommand(),
+		newSnapshotCommand(),
 	)
 
 	return root
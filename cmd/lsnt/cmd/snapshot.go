@@ -0,0 +1,63 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ffromani/numalign/pkg/snapshot"
+)
+
+type snapshotOpts struct {
+	output     string
+	procFSRoot string
+}
+
+func captureSnapshot(snOpts *snapshotOpts) error {
+	if snOpts.output == "" {
+		return fmt.Errorf("missing output file")
+	}
+	dst, err := os.Create(snOpts.output)
+	if err != nil {
+		return err
+	}
+	err = snapshot.Capture(dst, opts.sysFSRoot, snOpts.procFSRoot)
+	if err != nil {
+		dst.Close()
+		os.Remove(snOpts.output)
+		return err
+	}
+	return dst.Close()
+}
+
+func newSnapshotCommand() *cobra.Command {
+	flags := &snapshotOpts{}
+	show := &cobra.Command{
+		Use:   "snapshot",
+		Short: "capture all the sysfs and procfs files the tools read into one archive",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return captureSnapshot(flags)
+		},
+		Args: cobra.NoArgs,
+	}
+	show.Flags().StringVarP(&flags.output, "output", "o", "", "archive (.tar.gz) file path.")
+	show.Flags().StringVarP(&flags.procFSRoot, "procfs", "P", "/proc", "procfs root")
+	return show
+}
@@ -0,0 +1,97 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ffromani/numalign/internal/pkg/numalign"
+)
+
+// checkConfig describes a complete check, which can run once or periodically
+type checkConfig struct {
+	opts   numalign.Options
+	policy numalign.Policy
+	// unknownNUMA tells how devices with unknown NUMA node affect the result
+	unknownNUMA numalign.UnknownNUMAMode
+	// maxRemoteMemory is the max share (0..1) of resident memory allowed on other NUMA nodes. Negative to skip the check.
+	maxRemoteMemory float64
+	checkHugePages  bool
+	checkIRQs       bool
+}
+
+func runCheck(conf checkConfig) (*numalign.Resources, numalign.Result, error) {
+	R, err := numalign.NewResources(conf.opts)
+	if err != nil {
+		return nil, numalign.Result{}, err
+	}
+
+	res := numalign.CheckUnknownNUMANodes(R.CheckAlignmentWithPolicy(conf.policy), conf.unknownNUMA)
+	if conf.maxRemoteMemory >= 0 {
+		res, err = R.CheckMemoryResidency(res, conf.maxRemoteMemory)
+		if err != nil {
+			return R, res, err
+		}
+	}
+	if conf.checkHugePages {
+		res, err = R.CheckHugePagesResidency(res)
+		if err != nil {
+			return R, res, err
+		}
+	}
+	if conf.checkIRQs {
+		res, err = R.CheckIRQAffinity(res)
+		if err != nil {
+			return R, res, err
+		}
+	}
+	return R, res, nil
+}
+
+// parseCheckInterval returns the interval between the checks of serve and watch mode: the flag value if
+// the flag was given, envValue if not empty, the flag default otherwise. time.NewTicker panics unless positive.
+func parseCheckInterval(flagValue time.Duration, flagChanged bool, envValue string) (time.Duration, error) {
+	interval := flagValue
+	if envValue != "" && !flagChanged {
+		var err error
+		interval, err = time.ParseDuration(envValue)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("invalid check interval %v: must be positive", interval)
+	}
+	return interval, nil
+}
+
+// parseToggle returns the value of a boolean option: the flag value if the flag was given, the value of the
+// envName variable if not empty, like "true" or "0", the flag default otherwise.
+func parseToggle(flagValue bool, flagChanged bool, envName string) (bool, error) {
+	envValue := os.Getenv(envName)
+	if envValue == "" || flagChanged {
+		return flagValue, nil
+	}
+	val, err := strconv.ParseBool(envValue)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value %q: must be a boolean", envName, envValue)
+	}
+	return val, nil
+}
@@ -22,20 +22,49 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	flag "github.com/spf13/pflag"
 
 	"github.com/ffromani/numalign/internal/pkg/numalign"
+	"github.com/ffromani/numalign/internal/pkg/podresources"
+	"github.com/ffromani/numalign/pkg/snapshot"
+	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
 )
 
 func main() {
 	var sleepTime time.Duration
 
-	var sleepHoursParam = flag.StringP("sleep-hours", "S", "", "sleep hours once done.")
+	var sleepHoursParam = flag.StringP("sleep-hours", "S", "", "sleep hours once done (deprecated: use --serve).")
 	var scriptPathParam = flag.StringP("script-path", "P", "", "save test script to this path.")
 	var jsonOutput = flag.BoolP("json", "J", false, "output in JSON")
+	var explainOutput = flag.BoolP("explain", "X", false, "explain the result in human-friendly text")
 	var sleepOnError = flag.BoolP("sleep-on-error", "E", false, "still sleep if failed before to exit")
+	var maxRemoteMemoryParam = flag.StringP("max-remote-memory", "M", "", "fail if more than this percentage of resident memory is on other NUMA nodes.")
+	var checkHugePages = flag.BoolP("check-hugepages", "H", false, "fail if hugepages in use are on other NUMA nodes.")
+	var checkIRQs = flag.Bool("check-irqs", false, "fail if the device interrupts run on CPUs of other NUMA nodes, or on the exclusive or isolated container CPUs.")
+	var exclusiveCPUsParam = flag.Bool("exclusive-cpus", false, "the container CPUs are exclusive, like the ones of Guaranteed pods with integer CPU requests.")
+	var annotationsFileParam = flag.StringP("annotations-file", "A", "", "read devices from the Multus network-status annotation in this downward API file.")
+	var netdevsParam = flag.StringSliceP("netdev", "N", nil, "also check the PCI devices backing these network interfaces.")
+	var rdmaDevsParam = flag.StringSliceP("rdma-device", "I", nil, "also check the PCI devices backing these RDMA devices, given by name (mlx5_0) or verbs device (/dev/infiniband/uverbs3).")
+	var blockDevsParam = flag.StringSliceP("block-device", "B", nil, "also check the PCI devices backing these block devices, given by name (nvme0n1) or path (/dev/nvme0n1, /data).")
+	var deviceMappingParam = flag.StringP("device-mapping", "D", "", "read how to find the devices of other resources (GPUs, FPGAs...) from this YAML file.")
+	var deviceFilterParam = flag.StringP("device-filter", "F", "", "check only the devices matching this expression, like 'class=0200 && driver=vfio-pci' (see lsnt pcidevs --filter).")
+	var policyParam = flag.StringP("policy", "p", "", "alignment policy: single-numa-node (default), restricted, best-effort.")
+	var unknownNUMAParam = flag.String("unknown-numa", "", "how devices with unknown NUMA node, even after inference, affect the result: pass, warn (default), fail.")
+	var nodeAudit = flag.BoolP("node-audit", "n", false, "check all the containers on the node using the kubelet PodResources API.")
+	var sysfsRoot = flag.String("sysfs", numalign.DefaultSysFSRoot, "sysfs mount point to use.")
+	var procfsRoot = flag.String("procfs", numalign.DefaultProcFSRoot, "procfs mount point to use.")
+	var devRoot = flag.String("devfs", numalign.DefaultDevRoot, "root of the --block-device paths, and of the device nodes and CDI spec dirs listed in the --device-mapping file.")
+	var snapshotPath = flag.String("snapshot", "", "run against this snapshot (see lsnt snapshot) instead of the live system.")
+	var serveAddrParam = flag.String("serve", "", "run the check periodically, serving prometheus metrics on this address (e.g. :9100).")
+	var checkIntervalParam = flag.Duration("check-interval", time.Minute, "interval between checks in --serve and watch mode.")
+	var podResourcesSocketParam = flag.StringP("podresources-socket", "R", "", fmt.Sprintf("kubelet PodResources API socket (default %q).", podresources.DefaultSocketPath))
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] [watch] [pid...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	if _, ok := os.LookupEnv("NUMALIGN_DEBUG"); !ok {
@@ -44,7 +73,7 @@ func main() {
 
 	sleepHours := *sleepHoursParam
 	if sleepHours == "" {
-		os.Getenv("NUMALIGN_SLEEP_HOURS")
+		sleepHours = os.Getenv("NUMALIGN_SLEEP_HOURS")
 	}
 	if sleepHours != "" {
 		hours, err := strconv.Atoi(sleepHours)
@@ -58,18 +87,206 @@ func main() {
 
 	log.Printf("SYS: sleep for %v after the check", sleepTime)
 
-	R, err := numalign.NewResources(flag.Args())
+	env, err := snapshot.OpenEnv(*snapshotPath, snapshot.Env{SysFSRoot: *sysfsRoot, ProcFSRoot: *procfsRoot})
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
+	// from now on exit only with env.Exit, which removes the extracted snapshot
+	*sysfsRoot = env.SysFSRoot
+	*procfsRoot = env.ProcFSRoot
+
+	policyName := *policyParam
+	if policyName == "" {
+		policyName = os.Getenv("NUMALIGN_POLICY")
+	}
+	policy := numalign.PolicySingleNUMANode
+	if policyName != "" {
+		var err error
+		policy, err = numalign.ParsePolicy(policyName)
+		if err != nil {
+			log.Printf("%v", err)
+			env.Exit(1)
+		}
+	}
+
+	unknownNUMAName := *unknownNUMAParam
+	if unknownNUMAName == "" {
+		unknownNUMAName = os.Getenv("NUMALIGN_UNKNOWN_NUMA")
+	}
+	unknownNUMA := numalign.UnknownNUMAWarn
+	if unknownNUMAName != "" {
+		var err error
+		unknownNUMA, err = numalign.ParseUnknownNUMAMode(unknownNUMAName)
+		if err != nil {
+			log.Printf("%v", err)
+			env.Exit(1)
+		}
+	}
+
+	nodeAuditMode, err := parseToggle(*nodeAudit, flag.CommandLine.Changed("node-audit"), "NUMALIGN_NODE_AUDIT")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		env.Exit(1)
+	}
+	if nodeAuditMode {
+		socketPath := *podResourcesSocketParam
+		if socketPath == "" {
+			socketPath = os.Getenv("NUMALIGN_PODRESOURCES_SOCKET")
+		}
+		if socketPath == "" {
+			socketPath = podresources.DefaultSocketPath
+		}
+		env.Exit(runNodeAudit(*sysfsRoot, socketPath, policy, unknownNUMA, *jsonOutput, *explainOutput))
+	}
+
+	annotationsFile := *annotationsFileParam
+	if annotationsFile == "" {
+		annotationsFile = os.Getenv("NUMALIGN_ANNOTATIONS_FILE")
+	}
+
+	netdevs := *netdevsParam
+	if len(netdevs) == 0 {
+		if val := os.Getenv("NUMALIGN_NETDEVS"); val != "" {
+			netdevs = strings.Split(val, ",")
+		}
+	}
+
+	rdmaDevs := *rdmaDevsParam
+	if len(rdmaDevs) == 0 {
+		if val := os.Getenv("NUMALIGN_RDMA_DEVICES"); val != "" {
+			rdmaDevs = strings.Split(val, ",")
+		}
+	}
+
+	blockDevs := *blockDevsParam
+	if len(blockDevs) == 0 {
+		if val := os.Getenv("NUMALIGN_BLOCK_DEVICES"); val != "" {
+			blockDevs = strings.Split(val, ",")
+		}
+	}
+
+	deviceMapping := *deviceMappingParam
+	if deviceMapping == "" {
+		deviceMapping = os.Getenv("NUMALIGN_DEVICE_MAPPING")
+	}
+
+	deviceFilterExpr := *deviceFilterParam
+	if deviceFilterExpr == "" {
+		deviceFilterExpr = os.Getenv("NUMALIGN_DEVICE_FILTER")
+	}
+	deviceFilter, err := pcidev.ParseFilter(deviceFilterExpr)
+	if err != nil {
+		log.Printf("%v", err)
+		env.Exit(1)
+	}
+
+	maxRemoteMemory := *maxRemoteMemoryParam
+	if maxRemoteMemory == "" {
+		maxRemoteMemory = os.Getenv("NUMALIGN_MAX_REMOTE_MEMORY")
+	}
+	maxRemoteRatio := -1.0
+	if maxRemoteMemory != "" {
+		percent, err := strconv.ParseFloat(maxRemoteMemory, 64)
+		if err != nil {
+			log.Printf("%v", err)
+			env.Exit(1)
+		}
+		maxRemoteRatio = percent / 100.0
+	}
+
+	hugePages, err := parseToggle(*checkHugePages, flag.CommandLine.Changed("check-hugepages"), "NUMALIGN_CHECK_HUGEPAGES")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		env.Exit(1)
+	}
+	irqs, err := parseToggle(*checkIRQs, flag.CommandLine.Changed("check-irqs"), "NUMALIGN_CHECK_IRQS")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		env.Exit(1)
+	}
+	exclusiveCPUs, err := parseToggle(*exclusiveCPUsParam, flag.CommandLine.Changed("exclusive-cpus"), "NUMALIGN_EXCLUSIVE_CPUS")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		env.Exit(1)
+	}
+
+	pids := flag.Args()
+	watchMode, err := parseToggle(false, false, "NUMALIGN_WATCH")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		env.Exit(1)
+	}
+	if len(pids) > 0 && pids[0] == "watch" {
+		watchMode = true
+		pids = pids[1:]
+	}
+
+	conf := checkConfig{
+		opts: numalign.Options{
+			SysFSRoot:       *sysfsRoot,
+			ProcFSRoot:      *procfsRoot,
+			Environ:         os.Environ(),
+			Pids:            pids,
+			AnnotationsFile: annotationsFile,
+			Netdevs:         netdevs,
+			RDMADevs:        rdmaDevs,
+			BlockDevs:       blockDevs,
+			MappingFile:     deviceMapping,
+			DevRoot:         *devRoot,
+			DeviceFilter:    deviceFilter,
+			ExclusiveCPUs:   exclusiveCPUs,
+		},
+		policy:          policy,
+		unknownNUMA:     unknownNUMA,
+		maxRemoteMemory: maxRemoteRatio,
+		checkHugePages:  hugePages,
+		checkIRQs:       irqs,
+	}
+
+	serveAddr := *serveAddrParam
+	if serveAddr == "" {
+		serveAddr = os.Getenv("NUMALIGN_SERVE")
+	}
+	var checkInterval time.Duration
+	if serveAddr != "" || watchMode {
+		checkInterval, err = parseCheckInterval(*checkIntervalParam, flag.CommandLine.Changed("check-interval"), os.Getenv("NUMALIGN_CHECK_INTERVAL"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			env.Exit(1)
+		}
+	}
+	if serveAddr != "" {
+		err := serveMetrics(serveAddr, checkInterval, conf)
+		if err != nil {
+			log.Printf("%v", err)
+			env.Exit(1)
+		}
+		env.Exit(0)
+	}
+
+	if watchMode {
+		err := watchChanges(os.Stdout, checkInterval, conf)
+		if err != nil {
+			log.Printf("%v", err)
+			env.Exit(1)
+		}
+		env.Exit(0)
+	}
+
+	R, res, err := runCheck(conf)
+	if err != nil {
+		log.Printf("%v", err)
+		env.Exit(1)
+	}
 
 	rc := -1
-	res := R.CheckAlignment()
 	if res.Aligned {
 		rc = 0
 	}
 	if *jsonOutput {
 		fmt.Printf("%s", res.JSON())
+	} else if *explainOutput {
+		fmt.Printf("%s", res.Explain())
 	} else {
 		fmt.Printf("%s", res.Text())
 		if !res.Aligned {
@@ -90,8 +307,10 @@ func main() {
 		}
 	}
 
+	env.Cleanup()
+
 	if rc == 0 || *sleepOnError {
 		time.Sleep(sleepTime)
 	}
-	os.Exit(rc)
+	env.Exit(rc)
 }
@@ -0,0 +1,136 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ffromani/cpuset"
+
+	"github.com/ffromani/numalign/internal/pkg/numalign"
+	"github.com/ffromani/numalign/internal/pkg/podresources"
+	"github.com/ffromani/numalign/pkg/topologyinfo/cpus"
+	"github.com/ffromani/numalign/pkg/topologyinfo/numa/distances"
+	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
+)
+
+// runNodeAudit checks all the containers on the node using the kubelet PodResources API.
+// Returns the process exit code.
+func runNodeAudit(sysfsRoot, socketPath string, policy numalign.Policy, unknownNUMA numalign.UnknownNUMAMode, jsonOutput, explainOutput bool) int {
+	cpuRes, err := cpus.NewCPUs(sysfsRoot)
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+	pciDevs, err := pcidev.NewPCIDevices(sysfsRoot)
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+	dists, err := distances.NewDistancesFromSysfs(sysfsRoot)
+	if err != nil {
+		log.Printf("NUMA: cannot read distances: %v", err)
+		dists = nil
+	}
+
+	cli, conn, err := podresources.Connect(socketPath, podresources.DefaultTimeout)
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), podresources.DefaultTimeout)
+	defer cancel()
+	reports, err := podresources.Audit(ctx, cli, podresources.Topology{
+		CPUs:       cpuRes,
+		PCIDevices: pciDevs,
+		Distances:  dists,
+	}, policy)
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+
+	for idx := range reports {
+		reports[idx].Result = numalign.CheckUnknownNUMANodes(reports[idx].Result, unknownNUMA)
+	}
+
+	rc := 0
+	for _, report := range reports {
+		if !report.Result.Aligned {
+			rc = -1
+		}
+	}
+
+	if jsonOutput {
+		data, err := json.Marshal(reports)
+		if err != nil {
+			log.Printf("%v", err)
+			return 1
+		}
+		fmt.Printf("%s\n", data)
+		return rc
+	}
+
+	if explainOutput {
+		for _, report := range reports {
+			fmt.Printf("%s/%s/%s:\n%s\n", report.Namespace, report.Pod, report.Container, report.Result.Explain())
+		}
+		return rc
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAMESPACE\tPOD\tCONTAINER\tCPUS\tDEVICES\tMEMORY\tNUMA\tALIGNED\n")
+	for _, report := range reports {
+		var cpuIDs []int
+		var devIDs []string
+		var memNodes []int
+		for _, ri := range report.Result.Resources {
+			switch ri.Kind {
+			case numalign.ResourceCPU:
+				if cpuID, err := strconv.Atoi(ri.ID); err == nil {
+					cpuIDs = append(cpuIDs, cpuID)
+				}
+			case numalign.ResourceDevice:
+				devIDs = append(devIDs, ri.ID)
+			case numalign.ResourceMemory:
+				memNodes = append(memNodes, ri.NUMACellID)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%v\n",
+			report.Namespace, report.Pod, report.Container,
+			orDash(cpuset.Unparse(cpuIDs)), orDash(strings.Join(devIDs, ",")), orDash(cpuset.Unparse(memNodes)),
+			orDash(cpuset.Unparse(report.Result.NUMANodes)), report.Result.Aligned)
+	}
+	tw.Flush()
+	return rc
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,70 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ffromani/numalign/internal/pkg/exporter"
+)
+
+// serveMetrics runs the check every interval, exposing the outcome as prometheus metrics on addr.
+// Returns once a UNIX signal (SIGINT, SIGTERM) arrives, or if the server fails.
+func serveMetrics(addr string, interval time.Duration, conf checkConfig) error {
+	ex := exporter.New()
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: ex.Handler(),
+	}
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- srv.ListenAndServe()
+	}()
+	log.Printf("SYS: serving metrics on %q, checking every %v", addr, interval)
+
+	exitSignal := make(chan os.Signal, 1)
+	signal.Notify(exitSignal, syscall.SIGINT, syscall.SIGTERM)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		_, res, err := runCheck(conf)
+		ex.Update(res, time.Since(start), err)
+		if err != nil {
+			log.Printf("SYS: check failed: %v", err)
+		} else {
+			log.Printf("SYS: check done: aligned=%v", res.Aligned)
+		}
+
+		select {
+		case <-ticker.C:
+		case err := <-srvErr:
+			return err
+		case <-exitSignal:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			return srv.Shutdown(ctx)
+		}
+	}
+}
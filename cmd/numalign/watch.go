@@ -0,0 +1,80 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ffromani/numalign/internal/pkg/numalign"
+)
+
+// watchEvent is emitted, one per line, when the outcome of the check changes
+type watchEvent struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Result    numalign.Result `json:"result"`
+	// Diff is against the previous event. Missing in the first event, which reports the initial state.
+	Diff *numalign.Diff `json:"diff,omitempty"`
+}
+
+// watchChanges runs the check every interval, writing an event on w each time the outcome changes.
+// Checks which fail to run are logged and skipped. Returns once a UNIX signal (SIGINT, SIGTERM) arrives.
+func watchChanges(w io.Writer, interval time.Duration, conf checkConfig) error {
+	enc := json.NewEncoder(w)
+	var prev *numalign.Result
+
+	exitSignal := make(chan os.Signal, 1)
+	signal.Notify(exitSignal, syscall.SIGINT, syscall.SIGTERM)
+
+	log.Printf("SYS: watching for changes every %v", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, res, err := runCheck(conf)
+		if err != nil {
+			log.Printf("SYS: check failed: %v", err)
+		} else {
+			ev := watchEvent{
+				Timestamp: time.Now(),
+				Result:    res,
+			}
+			emit := true
+			if prev != nil {
+				diff := numalign.DiffResults(*prev, res)
+				ev.Diff = &diff
+				emit = !diff.Empty()
+			}
+			if emit {
+				if err := enc.Encode(ev); err != nil {
+					return err
+				}
+				prev = &res
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-exitSignal:
+			return nil
+		}
+	}
+}
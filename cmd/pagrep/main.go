@@ -24,6 +24,7 @@ import (
 	flag "github.com/spf13/pflag"
 
 	"github.com/ffromani/numalign/pkg/procs"
+	"github.com/ffromani/numalign/pkg/snapshot"
 	k8scpuset "k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
 )
 
@@ -33,8 +34,18 @@ func main() {
 		flag.PrintDefaults()
 	}
 	var procfsRoot = flag.StringP("procfs", "P", "/proc", "procfs mount point to use.")
+	var snapshotPath = flag.String("snapshot", "", "run against this snapshot (see lsnt snapshot) instead of the live system.")
 	flag.Parse()
 
+	env, err := snapshot.OpenEnv(*snapshotPath, snapshot.Env{ProcFSRoot: *procfsRoot})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error opening the snapshot %q: %v\n", *snapshotPath, err)
+		os.Exit(1)
+	}
+	defer env.Cleanup()
+	exit := env.Exit
+	*procfsRoot = env.ProcFSRoot
+
 	isolCpuList := "0-65535" // "everything"
 	args := flag.Args()
 	if len(args) == 1 {
@@ -44,13 +55,13 @@ func main() {
 	isolCpus, err := k8scpuset.Parse(isolCpuList)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error parsing %q: %v", isolCpuList, err)
-		os.Exit(1)
+		exit(1)
 	}
 
 	procInfos, err := procs.All(*procfsRoot)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error getting process infos from %q: %v", *procfsRoot, err)
-		os.Exit(1)
+		exit(1)
 	}
 
 	for _, procInfo := range procInfos {
@@ -26,11 +26,6 @@ import (
 	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
 )
 
-type sriovInfo struct {
-	PFDev  pcidev.SRIOVDeviceInfo
-	VFDevs []pcidev.SRIOVDeviceInfo
-}
-
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [options] physfn_pci_addr\n", filepath.Base(os.Args[0]))
@@ -53,20 +48,21 @@ func main() {
 		os.Exit(1)
 	}
 
-	var info sriovInfo
+	sv, err := devInfos.SRIOV()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "SRIOV device listing failed: %v", err)
+		os.Exit(1)
+	}
+
+	var info pcidev.PFInfo
 	havePF := false
-	for _, devInfo := range devInfos.Items {
-		if devInfo.DevClass() != pcidev.DevClassNetwork {
-			continue
-		}
-		sriovDev, ok := devInfo.(pcidev.SRIOVDeviceInfo)
-		if !ok {
+	for _, pf := range sv.PFs {
+		if pf.Device.DevClass() != pcidev.DevClassNetwork {
 			continue
 		}
-
-		if sriovDev.IsPhysFn && (sriovDev.Address() == pfPCIAddr || sriovDev.DevAddress() == pfPCIAddr) {
+		if pf.Device.Address() == pfPCIAddr || pf.Device.DevAddress() == pfPCIAddr {
 			havePF = true
-			info.PFDev = sriovDev
+			info = pf
 		}
 	}
 
@@ -75,26 +71,12 @@ func main() {
 		os.Exit(0)
 	}
 
-	for _, devInfo := range devInfos.Items {
-		if devInfo.DevClass() != pcidev.DevClassNetwork {
-			continue
-		}
-		sriovDev, ok := devInfo.(pcidev.SRIOVDeviceInfo)
-		if !ok {
-			continue
-		}
-
-		if sriovDev.IsVFn && (sriovDev.ParentFn == info.PFDev.Address()) {
-			info.VFDevs = append(info.VFDevs, sriovDev)
-		}
-	}
-
-	if info.PFDev.NUMANode() != *numaNode {
-		fmt.Printf("echo %d > %s\n", *numaNode, filepath.Join(info.PFDev.SysfsPath(), "numa_node"))
+	if info.Device.NUMANode() != *numaNode {
+		fmt.Printf("echo %d > %s\n", *numaNode, filepath.Join(info.Device.SysfsPath(), "numa_node"))
 	}
-	for _, vfDev := range info.VFDevs {
-		if vfDev.NUMANode() != *numaNode {
-			fmt.Printf("echo %d > %s\n", *numaNode, filepath.Join(vfDev.SysfsPath(), "numa_node"))
+	for _, vf := range info.VFs {
+		if vf.Device.NUMANode() != *numaNode {
+			fmt.Printf("echo %d > %s\n", *numaNode, filepath.Join(vf.Device.SysfsPath(), "numa_node"))
 		}
 	}
 }
@@ -19,32 +19,50 @@ package main
 import (
 	"fmt"
 	"log"
-	"os"
+	"path/filepath"
+
+	flag "github.com/spf13/pflag"
 
 	"github.com/ffromani/numalign/internal/pkg/numalign"
 	"github.com/ffromani/numalign/internal/pkg/sriovscan"
+	"github.com/ffromani/numalign/pkg/snapshot"
+	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
 )
 
 func main() {
-	cpusPerNuma, err := numalign.GetCPUsPerNUMANode(numalign.SysDevicesSystemNodeDir)
+	var sysfsRoot = flag.StringP("sysfs", "S", "/sys", "sysfs mount point to use.")
+	var snapshotPath = flag.String("snapshot", "", "run against this snapshot (see lsnt snapshot) instead of the live system.")
+	var filterExpr = flag.StringP("filter", "F", "vf", "count the devices matching this expression, like 'vf && driver=vfio-pci' (see lsnt pcidevs --filter).")
+	flag.Parse()
+
+	filter, err := pcidev.ParseFilter(*filterExpr)
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
 
-	pciDevs, err := sriovscan.GetPCIDeviceInfo(sriovscan.SysBusPCIDevicesDir)
+	env, err := snapshot.OpenEnv(*snapshotPath, snapshot.Env{SysFSRoot: *sysfsRoot})
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
+	defer env.Cleanup()
+	*sysfsRoot = env.SysFSRoot
 
-	var sriovDevs []sriovscan.PCIDeviceInfo
-	for _, pciDev := range pciDevs {
-		if pciDev.IsVFn {
-			sriovDevs = append(sriovDevs, pciDev)
-		}
+	cpusPerNuma, err := numalign.GetCPUsPerNUMANode(filepath.Join(*sysfsRoot, "devices", "system", "node"))
+	if err != nil {
+		log.Printf("%v", err)
+		env.Exit(1)
 	}
 
+	pciDevs, err := pcidev.NewPCIDevices(*sysfsRoot)
+	if err != nil {
+		log.Printf("%v", err)
+		env.Exit(1)
+	}
+
+	sriovDevs := sriovscan.FromPCIDevices(pciDevs.Select(filter))
+
 	if len(sriovDevs) == 0 {
-		os.Exit(0)
+		return
 	}
 
 	for _, sriovDev := range sriovDevs {
@@ -8,7 +8,11 @@ require (
 	github.com/google/go-cmp v0.5.5
 	github.com/spf13/cobra v1.1.3
 	github.com/spf13/pflag v1.0.5
+	golang.org/x/sys v0.0.0-20210616094352-59db8d763f22
+	google.golang.org/grpc v1.38.0
+	k8s.io/kubelet v0.22.3
 	k8s.io/kubernetes v0.0.0-00010101000000-000000000000
+	sigs.k8s.io/yaml v1.2.0
 )
 
 // Pinned to kubernetes-1.22.3
@@ -0,0 +1,193 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+// Package exporter exposes the results of the numalign checks as prometheus metrics.
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ffromani/numalign/internal/pkg/numalign"
+)
+
+const (
+	MetricsPath = "/metrics"
+	HealthzPath = "/healthz"
+)
+
+// Exporter holds the outcome of the last check. It is safe for concurrent use.
+type Exporter struct {
+	mu        sync.Mutex
+	result    *numalign.Result
+	lastErr   error
+	duration  time.Duration
+	timestamp time.Time
+	checks    uint64
+	errors    uint64
+}
+
+func New() *Exporter {
+	return &Exporter{}
+}
+
+// Update records the outcome of a check. On error, the result of the previous successful check is kept.
+func (ex *Exporter) Update(res numalign.Result, duration time.Duration, err error) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	ex.checks++
+	ex.lastErr = err
+	ex.duration = duration
+	ex.timestamp = time.Now()
+	if err != nil {
+		ex.errors++
+		return
+	}
+	ex.result = &res
+}
+
+// Healthy tells if a check completed and the last one did not fail
+func (ex *Exporter) Healthy() error {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	if ex.checks == 0 {
+		return fmt.Errorf("no check completed yet")
+	}
+	return ex.lastErr
+}
+
+// WriteMetrics writes all the metrics in the prometheus text exposition format
+func (ex *Exporter) WriteMetrics(w io.Writer) error {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+
+	mw := metricsWriter{w: w}
+	mw.header("numalign_checks_total", "counter", "Number of checks run.")
+	mw.sample("numalign_checks_total", nil, float64(ex.checks))
+	mw.header("numalign_check_errors_total", "counter", "Number of checks which failed to run.")
+	mw.sample("numalign_check_errors_total", nil, float64(ex.errors))
+	if ex.checks > 0 {
+		mw.header("numalign_check_duration_seconds", "gauge", "Duration of the last check.")
+		mw.sample("numalign_check_duration_seconds", nil, ex.duration.Seconds())
+		mw.header("numalign_last_check_timestamp_seconds", "gauge", "Time of the last check, in seconds since the epoch.")
+		mw.sample("numalign_last_check_timestamp_seconds", nil, float64(ex.timestamp.UnixNano())/1e9)
+	}
+
+	res := ex.result
+	if res == nil {
+		return mw.err
+	}
+
+	mw.header("numalign_aligned", "gauge", "1 if the resources are aligned according to the policy, 0 otherwise.")
+	mw.sample("numalign_aligned", []string{"policy", res.Policy}, boolToFloat(res.Aligned))
+	mw.header("numalign_numa_node", "gauge", "NUMA node all the resources are on, -1 if they span more nodes.")
+	mw.sample("numalign_numa_node", nil, float64(res.NUMACellID))
+	mw.header("numalign_numa_nodes", "gauge", "Number of NUMA nodes the resources are on.")
+	mw.sample("numalign_numa_nodes", nil, float64(len(res.NUMANodes)))
+	mw.header("numalign_narrowest", "gauge", "1 if the resources are on the smallest, and closest, set of NUMA nodes possible.")
+	mw.sample("numalign_narrowest", nil, boolToFloat(res.Narrowest))
+
+	mw.header("numalign_resource_numa_node", "gauge", "NUMA node of each resource checked.")
+	for _, ri := range res.Resources {
+		mw.sample("numalign_resource_numa_node", []string{"kind", string(ri.Kind), "id", ri.ID, "resource", ri.Resource}, float64(ri.NUMACellID))
+	}
+
+	mw.header("numalign_reason", "gauge", "Findings of the last check, by reason code.")
+	counts := make(map[numalign.ReasonCode]int)
+	var codes []numalign.ReasonCode
+	for _, reason := range res.Reasons {
+		if counts[reason.Code] == 0 {
+			codes = append(codes, reason.Code)
+		}
+		counts[reason.Code]++
+	}
+	for _, code := range codes {
+		mw.sample("numalign_reason", []string{"code", string(code)}, float64(counts[code]))
+	}
+
+	if res.RemoteMemory != nil {
+		mw.header("numalign_remote_memory_ratio", "gauge", "Share (0..1) of resident memory outside the NUMA nodes in use.")
+		mw.sample("numalign_remote_memory_ratio", nil, *res.RemoteMemory)
+	}
+	return mw.err
+}
+
+// Handler serves the metrics and the health status
+func (ex *Exporter) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(MetricsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		ex.WriteMetrics(w)
+	})
+	mux.HandleFunc(HealthzPath, func(w http.ResponseWriter, r *http.Request) {
+		if err := ex.Healthy(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "ok\n")
+	})
+	return mux
+}
+
+type metricsWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (mw *metricsWriter) header(name, kind, help string) {
+	mw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a sample. labels are name, value pairs.
+func (mw *metricsWriter) sample(name string, labels []string, value float64) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteString("{")
+		for idx := 0; idx+1 < len(labels); idx += 2 {
+			if idx > 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(&b, "%s=\"%s\"", labels[idx], escapeLabelValue(labels[idx+1]))
+		}
+		b.WriteString("}")
+	}
+	mw.printf("%s %v\n", b.String(), value)
+}
+
+func (mw *metricsWriter) printf(format string, args ...interface{}) {
+	if mw.err != nil {
+		return
+	}
+	_, mw.err = fmt.Fprintf(mw.w, format, args...)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ffromani/numalign/internal/pkg/numalign"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	ex := New()
+	ex.Update(numalign.Result{
+		Version:    numalign.ResultVersion,
+		Aligned:    false,
+		NUMACellID: -1,
+		NUMANodes:  []int{0, 1},
+		Policy:     "single-numa-node",
+		Resources: []numalign.ResourceInfo{
+			{Kind: numalign.ResourceCPU, ID: "2", NUMACellID: 0},
+			{Kind: numalign.ResourceDevice, ID: "0000:3b:02.1", NUMACellID: 1, Resource: "openshift.io/intelnics"},
+		},
+		Reasons: []numalign.Reason{
+			{Code: numalign.ReasonDeviceRemote, Message: "device is remote"},
+		},
+	}, 1500*time.Millisecond, nil)
+
+	var b strings.Builder
+	if err := ex.WriteMetrics(&b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := b.String()
+
+	expected := []string{
+		"numalign_checks_total 1\n",
+		"numalign_check_errors_total 0\n",
+		"numalign_check_duration_seconds 1.5\n",
+		`numalign_aligned{policy="single-numa-node"} 0` + "\n",
+		"numalign_numa_node -1\n",
+		"numalign_numa_nodes 2\n",
+		`numalign_resource_numa_node{kind="cpu",id="2",resource=""} 0` + "\n",
+		`numalign_resource_numa_node{kind="device",id="0000:3b:02.1",resource="openshift.io/intelnics"} 1` + "\n",
+		`numalign_reason{code="DEVICE_REMOTE"} 1` + "\n",
+		"# TYPE numalign_aligned gauge\n",
+	}
+	for _, exp := range expected {
+		if !strings.Contains(out, exp) {
+			t.Errorf("missing %q in:\n%s", exp, out)
+		}
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	got := escapeLabelValue("a\"b\\c\nd")
+	if got != `a\"b\\c\nd` {
+		t.Errorf("unexpected escaping: %q", got)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	ex := New()
+	srv := httptest.NewServer(ex.Handler())
+	defer srv.Close()
+
+	checkStatus := func(expected int) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + HealthzPath)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Errorf("unexpected status %d expected %d", resp.StatusCode, expected)
+		}
+	}
+
+	checkStatus(http.StatusServiceUnavailable)
+	ex.Update(numalign.Result{Aligned: true}, time.Second, nil)
+	checkStatus(http.StatusOK)
+	ex.Update(numalign.Result{}, time.Second, fmt.Errorf("cannot read sysfs"))
+	checkStatus(http.StatusServiceUnavailable)
+}
@@ -0,0 +1,449 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package kubeletcheck
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+
+	"github.com/ffromani/numalign/pkg/cpusetinfo"
+	"github.com/ffromani/numalign/pkg/kubeletstate"
+	"github.com/ffromani/numalign/pkg/procs"
+)
+
+var podUIDRe = regexp.MustCompile(`pod([0-9a-fA-F]{8}[-_][0-9a-fA-F]{4}[-_][0-9a-fA-F]{4}[-_][0-9a-fA-F]{4}[-_][0-9a-fA-F]{12})`)
+
+// Container is what the system reports about a running container
+type Container struct {
+	PodUID      string
+	ContainerID string
+	// Name is the container name. Only known if the container can be matched with the CPU manager state.
+	Name string
+	Pid  int
+	CPUs cpuset.CPUSet
+	Mems cpuset.CPUSet
+	// Devices maps the PCIDEVICE_* variable name -> PCI addresses
+	Devices map[string][]string
+}
+
+// DisplayName returns the container name if known, the runtime ID otherwise
+func (cnt Container) DisplayName() string {
+	if cnt.Name != "" {
+		return cnt.Name
+	}
+	return cnt.ContainerID
+}
+
+// PodContainerFromCGroupPath extracts the pod UID and the container runtime ID from a cgroup path.
+// Both are empty if the path does not belong to a container.
+func PodContainerFromCGroupPath(path string) (string, string) {
+	items := strings.Split(strings.Trim(path, "/"), "/")
+	for idx, item := range items {
+		match := podUIDRe.FindStringSubmatch(item)
+		if match == nil {
+			continue
+		}
+		if idx == len(items)-1 {
+			// pod-level cgroup
+			return "", ""
+		}
+		cntID := strings.TrimSuffix(items[len(items)-1], ".scope")
+		if pos := strings.LastIndex(cntID, "-"); pos != -1 {
+			// systemd cgroup driver, like crio-<ID>.scope or cri-containerd-<ID>.scope
+			cntID = cntID[pos+1:]
+		}
+		return strings.ReplaceAll(match[1], "_", "-"), cntID
+	}
+	return "", ""
+}
+
+// FindContainers finds all the running containers and their resources
+func FindContainers(fsh cpusetinfo.FSHandle) ([]Container, error) {
+	procInfos, err := procs.All(fsh.GetProcMountPoint())
+	if err != nil {
+		return nil, err
+	}
+
+	var cnts []Container
+	cntIdx := make(map[string]int)
+	for _, procInfo := range procInfos {
+		pid := int(procInfo.Pid)
+		cgPath, _, err := cpusetinfo.GetCPUSetCGroupPathForPID(fsh, pid)
+		if err != nil {
+			// process exited meanwhile
+			continue
+		}
+		podUID, cntID := PodContainerFromCGroupPath(cgPath)
+		if podUID == "" {
+			continue
+		}
+
+		devs := getDevicesForPID(fsh, pid)
+
+		key := podUID + "/" + cntID
+		if idx, ok := cntIdx[key]; ok {
+			cnts[idx].Devices = mergeDevices(cnts[idx].Devices, devs)
+			continue
+		}
+
+		cpus, err := cpusetinfo.GetCPUSetForPID(fsh, pid)
+		if err != nil {
+			log.Printf("KUBELET: cannot get the cpuset for pid %d: %v", pid, err)
+			continue
+		}
+		mems, err := cpusetinfo.GetMemSetForPID(fsh, pid)
+		if err != nil {
+			log.Printf("KUBELET: cannot get the memset for pid %d: %v", pid, err)
+			continue
+		}
+		cntIdx[key] = len(cnts)
+		cnts = append(cnts, Container{
+			PodUID:      podUID,
+			ContainerID: cntID,
+			Pid:         pid,
+			CPUs:        cpus,
+			Mems:        mems,
+			Devices:     devs,
+		})
+	}
+
+	sort.Slice(cnts, func(i, j int) bool {
+		if cnts[i].PodUID != cnts[j].PodUID {
+			return cnts[i].PodUID < cnts[j].PodUID
+		}
+		return cnts[i].ContainerID < cnts[j].ContainerID
+	})
+	return cnts, nil
+}
+
+// DeviceEnvVar returns the name of the variable the SR-IOV device plugin sets for a resource
+func DeviceEnvVar(resourceName string) string {
+	return "PCIDEVICE_" + strings.ToUpper(strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, resourceName))
+}
+
+func getDevicesForPID(fsh cpusetinfo.FSHandle, pid int) map[string][]string {
+	data, err := ioutil.ReadFile(filepath.Join(fsh.GetProcMountPoint(), strconv.Itoa(pid), "environ"))
+	if err != nil {
+		// kernel threads and processes we can't access
+		return nil
+	}
+	devs := make(map[string][]string)
+	for _, entry := range bytes.Split(data, []byte{0}) {
+		items := strings.SplitN(string(entry), "=", 2)
+		if len(items) != 2 || !strings.HasPrefix(items[0], "PCIDEVICE_") || strings.HasSuffix(items[0], "_INFO") {
+			continue
+		}
+		for _, addr := range strings.Split(items[1], ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				devs[items[0]] = append(devs[items[0]], addr)
+			}
+		}
+	}
+	return devs
+}
+
+func mergeDevices(devs, others map[string][]string) map[string][]string {
+	if devs == nil {
+		devs = make(map[string][]string)
+	}
+	for name, addrs := range others {
+		devs[name] = append(devs[name], addrs...)
+	}
+	return devs
+}
+
+type DriftKind string
+
+const (
+	// DriftCPUs means a container runs on CPUs the CPU manager did not assign to it
+	DriftCPUs DriftKind = "cpus"
+	// DriftMemory means a container can use memory on NUMA nodes the memory manager did not assign to it
+	DriftMemory DriftKind = "memory"
+	// DriftDevices means the devices of a pod are not the ones the device manager assigned to it
+	DriftDevices DriftKind = "devices"
+	// DriftStale means kubelet holds resources for a container which is not running
+	DriftStale DriftKind = "stale"
+)
+
+// Drift is a disagreement between the kubelet state and the system
+type Drift struct {
+	Kind      DriftKind `json:"kind"`
+	PodUID    string    `json:"poduid"`
+	Container string    `json:"container"`
+	Expected  string    `json:"expected"`
+	Actual    string    `json:"actual"`
+}
+
+// Check compares the kubelet state with the running containers.
+// The containers whose assignment is found in the CPU manager state get their Name set.
+func Check(st kubeletstate.State, cnts []Container) ([]Drift, error) {
+	var drifts []Drift
+
+	if st.CPUManager != nil && st.CPUManager.PolicyName == "static" {
+		cpuDrifts, err := checkCPUs(*st.CPUManager, cnts)
+		if err != nil {
+			return drifts, err
+		}
+		drifts = append(drifts, cpuDrifts...)
+	}
+	if st.MemoryManager != nil && strings.EqualFold(st.MemoryManager.PolicyName, "static") {
+		drifts = append(drifts, checkMemory(*st.MemoryManager, cnts)...)
+	}
+	if st.Devices != nil {
+		drifts = append(drifts, checkDevices(*st.Devices, cnts)...)
+	}
+
+	sort.SliceStable(drifts, func(i, j int) bool {
+		if drifts[i].PodUID != drifts[j].PodUID {
+			return drifts[i].PodUID < drifts[j].PodUID
+		}
+		return drifts[i].Container < drifts[j].Container
+	})
+	return drifts, nil
+}
+
+func checkCPUs(cms kubeletstate.CPUManagerState, cnts []Container) ([]Drift, error) {
+	var drifts []Drift
+	defCPUs, err := cms.DefaultCPUs()
+	if err != nil {
+		return drifts, err
+	}
+
+	running := make(map[string]bool)
+	matched := make(map[string]bool)
+	for idx := range cnts {
+		cnt := &cnts[idx]
+		running[cnt.PodUID] = true
+
+		for _, name := range sortedKeys(cms.Entries[cnt.PodUID]) {
+			cpus, _, err := cms.ContainerCPUs(cnt.PodUID, name)
+			if err != nil {
+				return drifts, err
+			}
+			key := cnt.PodUID + "/" + name
+			if cnt.Name == "" && !matched[key] && cpus.Equals(cnt.CPUs) {
+				cnt.Name = name
+				matched[key] = true
+			}
+		}
+	}
+
+	// the drifted containers account for the entries of their pod left unmatched, so these are not reported again
+	for idx := range cnts {
+		cnt := &cnts[idx]
+		if cnt.Name != "" || cnt.CPUs.Equals(defCPUs) {
+			continue
+		}
+
+		var candidates []string
+		for _, name := range sortedKeys(cms.Entries[cnt.PodUID]) {
+			if !matched[cnt.PodUID+"/"+name] {
+				candidates = append(candidates, name)
+			}
+		}
+		expected := defCPUs.String()
+		switch len(candidates) {
+		case 0:
+		case 1:
+			// the only entry the container can belong to
+			cnt.Name = candidates[0]
+			expected = cms.Entries[cnt.PodUID][cnt.Name]
+		default:
+			var exclusive []string
+			for _, name := range candidates {
+				exclusive = append(exclusive, cms.Entries[cnt.PodUID][name])
+			}
+			expected += " or one of " + strings.Join(exclusive, " ")
+		}
+		if len(candidates) > 0 {
+			matched[cnt.PodUID+"/"+candidates[0]] = true
+		}
+
+		drifts = append(drifts, Drift{
+			Kind:      DriftCPUs,
+			PodUID:    cnt.PodUID,
+			Container: cnt.DisplayName(),
+			Expected:  expected,
+			Actual:    cnt.CPUs.String(),
+		})
+	}
+
+	for _, podUID := range sortedPodKeys(cms.Entries) {
+		for _, name := range sortedKeys(cms.Entries[podUID]) {
+			if matched[podUID+"/"+name] {
+				continue
+			}
+			kind := DriftCPUs
+			if !running[podUID] {
+				kind = DriftStale
+			}
+			drifts = append(drifts, Drift{
+				Kind:      kind,
+				PodUID:    podUID,
+				Container: name,
+				Expected:  cms.Entries[podUID][name],
+				Actual:    "-",
+			})
+		}
+	}
+	return drifts, nil
+}
+
+func checkMemory(mms kubeletstate.MemoryManagerState, cnts []Container) []Drift {
+	var drifts []Drift
+	for _, cnt := range cnts {
+		if cnt.Name == "" {
+			// can't tell which entry belongs to this container
+			continue
+		}
+		nodes, found := mms.ContainerNUMANodes(cnt.PodUID, cnt.Name)
+		if !found || nodes.Equals(cnt.Mems) {
+			continue
+		}
+		drifts = append(drifts, Drift{
+			Kind:      DriftMemory,
+			PodUID:    cnt.PodUID,
+			Container: cnt.Name,
+			Expected:  nodes.String(),
+			Actual:    cnt.Mems.String(),
+		})
+	}
+	return drifts
+}
+
+// checkDevices works at pod level: only the containers matched with the CPU manager state have a name.
+// Only the resources exposed using PCIDEVICE_* variables can be checked.
+func checkDevices(dc kubeletstate.DeviceCheckpoint, cnts []Container) []Drift {
+	var drifts []Drift
+
+	actual := make(map[string]map[string][]string)
+	for _, cnt := range cnts {
+		actual[cnt.PodUID] = mergeDevices(actual[cnt.PodUID], cnt.Devices)
+	}
+	expected := make(map[string]map[string][]string)
+	for _, entry := range dc.Data.PodDeviceEntries {
+		expected[entry.PodUID] = mergeDevices(expected[entry.PodUID], map[string][]string{
+			DeviceEnvVar(entry.ResourceName): entry.AllDeviceIDs(),
+		})
+	}
+
+	for _, podUID := range sortedPodDeviceKeys(actual, expected) {
+		_, running := actual[podUID]
+		for _, envVar := range sortedDeviceKeys(actual[podUID], expected[podUID]) {
+			exp := normalizeIDs(expected[podUID][envVar])
+			act := normalizeIDs(actual[podUID][envVar])
+			if exp == act {
+				continue
+			}
+			if exp == "" {
+				exp = "-"
+			}
+			if act == "" {
+				act = "-"
+			}
+			kind := DriftDevices
+			if !running {
+				kind = DriftStale
+			}
+			drifts = append(drifts, Drift{
+				Kind:      kind,
+				PodUID:    podUID,
+				Container: "-",
+				Expected:  envVar + "=" + exp,
+				Actual:    envVar + "=" + act,
+			})
+		}
+	}
+	return drifts
+}
+
+func normalizeIDs(ids []string) string {
+	seen := make(map[string]bool)
+	var ret []string
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ret = append(ret, id)
+	}
+	sort.Strings(ret)
+	return strings.Join(ret, ",")
+}
+
+func sortedKeys(data map[string]string) []string {
+	var keys []string
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedPodKeys(data map[string]map[string]string) []string {
+	var keys []string
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedPodDeviceKeys returns the pod UIDs found in any of the given maps
+func sortedPodDeviceKeys(datas ...map[string]map[string][]string) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, data := range datas {
+		for key := range data {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedDeviceKeys returns the environment variables found in any of the given maps
+func sortedDeviceKeys(datas ...map[string][]string) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, data := range datas {
+		for key := range data {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,240 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package kubeletcheck
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+
+	"github.com/ffromani/numalign/pkg/cpusetinfo"
+	"github.com/ffromani/numalign/pkg/kubeletstate"
+)
+
+const (
+	podUIDA string = "b1c81bdc-1bc5-4d39-a173-b74598538a91"
+	podUIDB string = "0c0e5e0a-5d70-4b1e-9a4c-6e4e7b0c5a12"
+	podUIDC string = "9a2b8c1d-3e4f-4a5b-8c6d-7e8f9a0b1c2d"
+)
+
+func TestPodContainerFromCGroupPath(t *testing.T) {
+	testCases := []struct {
+		path        string
+		expectedPod string
+		expectedCnt string
+	}{
+		{
+			path:        "/kubepods/podb1c81bdc-1bc5-4d39-a173-b74598538a91/741e4d6c8494",
+			expectedPod: podUIDA,
+			expectedCnt: "741e4d6c8494",
+		},
+		{
+			path:        "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-podb1c81bdc_1bc5_4d39_a173_b74598538a91.slice/crio-741e4d6c8494.scope",
+			expectedPod: podUIDA,
+			expectedCnt: "741e4d6c8494",
+		},
+		{
+			path: "/kubepods/podb1c81bdc-1bc5-4d39-a173-b74598538a91",
+		},
+		{
+			path: "/system.slice/kubelet.service",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.path, func(t *testing.T) {
+			pod, cnt := PodContainerFromCGroupPath(testCase.path)
+			if pod != testCase.expectedPod || cnt != testCase.expectedCnt {
+				t.Errorf("got pod=%q cnt=%q expected pod=%q cnt=%q", pod, cnt, testCase.expectedPod, testCase.expectedCnt)
+			}
+		})
+	}
+}
+
+type fakeProc struct {
+	pid    int
+	cgroup string
+	cpus   string
+	mems   string
+	env    string
+}
+
+func makeFakeFS(t *testing.T, base string, fakeProcs []fakeProc) cpusetinfo.FSHandle {
+	fsh := cpusetinfo.FSHandle{
+		CGroupsMountPoint: filepath.Join(base, "cgroup"),
+		ProcMountPoint:    filepath.Join(base, "proc"),
+		SysMountPoint:     filepath.Join(base, "sys"),
+	}
+	for _, fp := range fakeProcs {
+		procDir := filepath.Join(fsh.ProcMountPoint, fmt.Sprintf("%d", fp.pid))
+		cgDir := filepath.Join(fsh.CGroupsMountPoint, "cpuset", fp.cgroup)
+		files := map[string]string{
+			filepath.Join(procDir, "status"):    fmt.Sprintf("Name:\tcnt\nTgid:\t%d\nPid:\t%d\nCpus_allowed_list:\t%s\n", fp.pid, fp.pid, fp.cpus),
+			filepath.Join(procDir, "cgroup"):    fmt.Sprintf("12:cpuset:%s\n", fp.cgroup),
+			filepath.Join(procDir, "environ"):   fp.env,
+			filepath.Join(cgDir, "cpuset.cpus"): fp.cpus,
+			filepath.Join(cgDir, "cpuset.mems"): fp.mems,
+		}
+		for path, content := range files {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("error creating %q: %v", path, err)
+			}
+			if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatalf("error writing %q: %v", path, err)
+			}
+		}
+	}
+	return fsh
+}
+
+func TestCheck(t *testing.T) {
+	base, err := ioutil.TempDir("", "kubeletcheck")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	fsh := makeFakeFS(t, base, []fakeProc{
+		{
+			// exclusive CPUs, as expected
+			pid:    100,
+			cgroup: "/kubepods/pod" + podUIDA + "/aaaa",
+			cpus:   "2-3",
+			mems:   "1",
+			env:    "PATH=/bin\x00PCIDEVICE_OPENSHIFT_IO_INTELNICS=0000:3b:02.1\x00",
+		},
+		{
+			// shared pool, as expected
+			pid:    101,
+			cgroup: "/kubepods/pod" + podUIDA + "/bbbb",
+			cpus:   "0-1,4-7",
+			mems:   "0-1",
+		},
+		{
+			// drifted after a restart
+			pid:    200,
+			cgroup: "/kubepods/pod" + podUIDB + "/cccc",
+			cpus:   "4-5",
+			mems:   "0",
+			env:    "PCIDEVICE_OPENSHIFT_IO_INTELNICS=0000:3b:02.2\x00",
+		},
+	})
+
+	cnts, err := FindContainers(fsh)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cnts) != 3 {
+		t.Fatalf("unexpected containers: %#v", cnts)
+	}
+
+	st := kubeletstate.State{
+		CPUManager: &kubeletstate.CPUManagerState{
+			PolicyName:    "static",
+			DefaultCPUSet: "0-1,4-7",
+			Entries: map[string]map[string]string{
+				podUIDA: {"app": "2-3"},
+				podUIDB: {"app": "6-7"},
+				podUIDC: {"app": "8-9"},
+			},
+		},
+		MemoryManager: &kubeletstate.MemoryManagerState{
+			PolicyName: "Static",
+			Entries: map[string]map[string][]kubeletstate.MemoryBlock{
+				podUIDA: {"app": {{NUMAAffinity: []int{0}, Type: "memory", Size: 1 << 30}}},
+			},
+		},
+		Devices: &kubeletstate.DeviceCheckpoint{},
+	}
+	st.Devices.Data.PodDeviceEntries = []kubeletstate.PodDevicesEntry{
+		{
+			PodUID:        podUIDA,
+			ContainerName: "app",
+			ResourceName:  "openshift.io/intelnics",
+			DeviceIDs:     map[int64][]string{0: {"0000:3b:02.1"}},
+		},
+		{
+			// assigned, but not exposed to the container
+			PodUID:        podUIDB,
+			ContainerName: "app",
+			ResourceName:  "openshift.io/mlxnics",
+			DeviceIDs:     map[int64][]string{1: {"0000:d8:00.2"}},
+		},
+		{
+			// held for a pod which is not running
+			PodUID:        podUIDC,
+			ContainerName: "app",
+			ResourceName:  "openshift.io/intelnics",
+			DeviceIDs:     map[int64][]string{0: {"0000:3b:02.3"}},
+		},
+	}
+
+	drifts, err := Check(st, cnts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// sorted by pod UID, then by container
+	expected := []Drift{
+		{Kind: DriftDevices, PodUID: podUIDB, Container: "-", Expected: "PCIDEVICE_OPENSHIFT_IO_INTELNICS=-", Actual: "PCIDEVICE_OPENSHIFT_IO_INTELNICS=0000:3b:02.2"},
+		{Kind: DriftDevices, PodUID: podUIDB, Container: "-", Expected: "PCIDEVICE_OPENSHIFT_IO_MLXNICS=0000:d8:00.2", Actual: "PCIDEVICE_OPENSHIFT_IO_MLXNICS=-"},
+		{Kind: DriftCPUs, PodUID: podUIDB, Container: "app", Expected: "6-7", Actual: "4-5"},
+		{Kind: DriftStale, PodUID: podUIDC, Container: "-", Expected: "PCIDEVICE_OPENSHIFT_IO_INTELNICS=0000:3b:02.3", Actual: "PCIDEVICE_OPENSHIFT_IO_INTELNICS=-"},
+		{Kind: DriftStale, PodUID: podUIDC, Container: "app", Expected: "8-9", Actual: "-"},
+		{Kind: DriftMemory, PodUID: podUIDA, Container: "app", Expected: "0", Actual: "1"},
+	}
+	if !cmp.Equal(drifts, expected) {
+		t.Errorf("unexpected drifts: %s", cmp.Diff(expected, drifts))
+	}
+	for _, cnt := range cnts {
+		if cnt.ContainerID == "aaaa" && cnt.Name != "app" {
+			t.Errorf("container not matched: %#v", cnt)
+		}
+	}
+}
+
+func TestCheckCPUsAmbiguous(t *testing.T) {
+	cms := kubeletstate.CPUManagerState{
+		PolicyName:    "static",
+		DefaultCPUSet: "0-1,8-9",
+		Entries: map[string]map[string]string{
+			podUIDA: {"app": "2-3", "sidecar": "4-5", "proxy": "6-7"},
+		},
+	}
+	cnts := []Container{
+		{PodUID: podUIDA, ContainerID: "aaaa", CPUs: cpuset.MustParse("2-3")},
+		{PodUID: podUIDA, ContainerID: "bbbb", CPUs: cpuset.MustParse("8-9")},
+	}
+
+	drifts, err := checkCPUs(cms, cnts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the drifted container accounts for one of the entries left, only the other is reported
+	expected := []Drift{
+		{Kind: DriftCPUs, PodUID: podUIDA, Container: "bbbb", Expected: "0-1,8-9 or one of 6-7 4-5", Actual: "8-9"},
+		{Kind: DriftCPUs, PodUID: podUIDA, Container: "sidecar", Expected: "4-5", Actual: "-"},
+	}
+	if !cmp.Equal(drifts, expected) {
+		t.Errorf("unexpected drifts: %s", cmp.Diff(expected, drifts))
+	}
+}
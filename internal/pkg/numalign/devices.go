@@ -0,0 +1,431 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numalign
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ffromani/numalign/pkg/topologyinfo/block"
+	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
+)
+
+const (
+	envPCIDevicePrefix = "PCIDEVICE_"
+	envInfoSuffix      = "_INFO"
+)
+
+const (
+	// NetworkStatusAnnotation is set by Multus on the pod and lists all its network interfaces
+	NetworkStatusAnnotation = "k8s.v1.cni.cncf.io/network-status"
+)
+
+// DeviceInfo is a device allocated to the container by a device plugin
+type DeviceInfo struct {
+	// Resource is the name of the extended resource the device was allocated for, like "openshift.io/intelnics"
+	Resource string `json:"resource,omitempty"`
+	// Address is the FULL PCI address of the device
+	Address string `json:"address"`
+	// Specs holds the extra data by kind, like "rdma": {"uverbs": "/dev/infiniband/uverbs3"}
+	Specs map[string]map[string]string `json:"specs,omitempty"`
+	// Network is the name of the network the device is attached to, if known
+	Network string `json:"network,omitempty"`
+	// Interface is the name of the network interface of the device in the container, if known
+	Interface string `json:"interface,omitempty"`
+	// BlockDevice is the name of the block device backed by the device, if known
+	BlockDevice string `json:"blockdevice,omitempty"`
+}
+
+func (di DeviceInfo) String() string {
+	desc := fmt.Sprintf("device %s", di.Address)
+	if di.Resource != "" {
+		desc = fmt.Sprintf("%s %s", di.Resource, desc)
+	} else if di.Network != "" {
+		desc = fmt.Sprintf("network %s %s", di.Network, desc)
+	}
+	if di.Interface != "" {
+		desc = fmt.Sprintf("%s (%s)", desc, di.Interface)
+	} else if di.BlockDevice != "" {
+		desc = fmt.Sprintf("%s (%s)", desc, di.BlockDevice)
+	}
+	return desc
+}
+
+// MergeDevices merges the device infos from many sources, by PCI address. Sorted by address.
+func MergeDevices(devSets ...[]DeviceInfo) []DeviceInfo {
+	devs := make(map[string]DeviceInfo)
+	for _, devSet := range devSets {
+		for _, dev := range devSet {
+			cur, ok := devs[dev.Address]
+			if !ok {
+				devs[dev.Address] = dev
+				continue
+			}
+			if cur.Resource == "" {
+				cur.Resource = dev.Resource
+			}
+			if cur.Network == "" {
+				cur.Network = dev.Network
+			}
+			if cur.Interface == "" {
+				cur.Interface = dev.Interface
+			}
+			if cur.BlockDevice == "" {
+				cur.BlockDevice = dev.BlockDevice
+			}
+			for kind, attrs := range dev.Specs {
+				if cur.Specs == nil {
+					cur.Specs = make(map[string]map[string]string)
+				}
+				if _, ok := cur.Specs[kind]; !ok {
+					cur.Specs[kind] = attrs
+				}
+			}
+			devs[dev.Address] = cur
+		}
+	}
+
+	var ret []DeviceInfo
+	for _, dev := range devs {
+		ret = append(ret, dev)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Address < ret[j].Address })
+	return ret
+}
+
+// deviceInfoSpec is the format of the PCIDEVICE_*_INFO variables set by newer SR-IOV device plugins:
+// {"0000:3b:02.1":{"generic":{"deviceID":"0000:3b:02.1"},"rdma":{"rdma_cm":"/dev/infiniband/rdma_cm","uverbs":"/dev/infiniband/uverbs3"}}}
+type deviceInfoSpec map[string]map[string]map[string]string
+
+// GetDevicesFromEnv returns the devices found in the PCIDEVICE_* environment variables, both in
+// the plain (comma-separated addresses) and in the JSON (_INFO) format, sorted by address.
+func GetDevicesFromEnv(environ []string) []DeviceInfo {
+	devs := make(map[string]DeviceInfo)
+	for _, envVar := range environ {
+		if !strings.HasPrefix(envVar, envPCIDevicePrefix) {
+			continue
+		}
+		pair := strings.SplitN(envVar, "=", 2)
+		if len(pair) != 2 || pair[1] == "" {
+			continue
+		}
+
+		if !strings.HasSuffix(pair[0], envInfoSuffix) {
+			resource := ResourceNameFromEnv(pair[0])
+			for _, pciDev := range strings.Split(pair[1], ",") {
+				if _, ok := devs[pciDev]; ok {
+					continue // prefer the richer _INFO data, if any
+				}
+				devs[pciDev] = DeviceInfo{
+					Resource: resource,
+					Address:  pciDev,
+				}
+			}
+			continue
+		}
+
+		resource := ResourceNameFromEnv(strings.TrimSuffix(pair[0], envInfoSuffix))
+		infos, err := ParseDeviceInfo(resource, pair[1])
+		if err != nil {
+			// not fatal: the plain variable carries the same addresses
+			log.Printf("PCI: cannot parse %q: %v", pair[0], err)
+			continue
+		}
+		for _, info := range infos {
+			devs[info.Address] = info
+		}
+	}
+
+	var ret []DeviceInfo
+	for _, dev := range devs {
+		ret = append(ret, dev)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Address < ret[j].Address })
+	return ret
+}
+
+// ParseDeviceInfo parses the JSON content of a PCIDEVICE_*_INFO variable
+func ParseDeviceInfo(resource, data string) ([]DeviceInfo, error) {
+	var spec deviceInfoSpec
+	if err := json.Unmarshal([]byte(data), &spec); err != nil {
+		return nil, err
+	}
+
+	var ret []DeviceInfo
+	for devID, kinds := range spec {
+		info := DeviceInfo{
+			Resource: resource,
+			Address:  devID,
+			Specs:    make(map[string]map[string]string),
+		}
+		for kind, attrs := range kinds {
+			if kind == "generic" {
+				if addr, ok := attrs["deviceID"]; ok && addr != "" {
+					info.Address = addr
+				}
+				continue
+			}
+			info.Specs[kind] = attrs
+		}
+		ret = append(ret, info)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Address < ret[j].Address })
+	return ret, nil
+}
+
+// ResourceNameFromEnv recovers the resource name from the environment variable name.
+// The device plugins replace all the non-alphanumeric characters with underscores, so this is
+// a best effort: the first token which looks like a top level domain ends the vendor domain.
+// Example: PCIDEVICE_OPENSHIFT_IO_INTELNICS -> openshift.io/intelnics
+func ResourceNameFromEnv(name string) string {
+	tokens := strings.Split(strings.ToLower(strings.TrimPrefix(name, envPCIDevicePrefix)), "_")
+	for idx := 1; idx < len(tokens)-1; idx++ {
+		switch tokens[idx] {
+		case "io", "com", "org", "net", "dev":
+			return strings.Join(tokens[:idx+1], ".") + "/" + strings.Join(tokens[idx+1:], "_")
+		}
+	}
+	return strings.Join(tokens, "_")
+}
+
+// networkStatus is the subset of the Multus network-status we care about, see
+// https://github.com/k8snetworkplumbingwg/device-info-spec
+type networkStatus struct {
+	Name       string `json:"name"`
+	Interface  string `json:"interface"`
+	DeviceInfo *struct {
+		Type string `json:"type"`
+		PCI  *struct {
+			PCIAddress string `json:"pci-address"`
+		} `json:"pci"`
+	} `json:"device-info"`
+}
+
+// GetDevicesFromAnnotationsFile returns the PCI devices listed in the Multus network-status annotation,
+// reading a file in the downward API annotations format.
+func GetDevicesFromAnnotationsFile(path string) ([]DeviceInfo, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	annotations, err := ReadAnnotations(src)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := annotations[NetworkStatusAnnotation]
+	if !ok {
+		log.Printf("PCI: annotation %q not found in %q", NetworkStatusAnnotation, path)
+		return nil, nil
+	}
+	return ParseNetworkStatus(data)
+}
+
+// ParseNetworkStatus extracts the PCI devices from the content of the Multus network-status annotation
+func ParseNetworkStatus(data string) ([]DeviceInfo, error) {
+	var statuses []networkStatus
+	if err := json.Unmarshal([]byte(data), &statuses); err != nil {
+		return nil, err
+	}
+	var ret []DeviceInfo
+	for _, st := range statuses {
+		if st.DeviceInfo == nil || st.DeviceInfo.PCI == nil || st.DeviceInfo.PCI.PCIAddress == "" {
+			continue // not a PCI device, e.g. the cluster default network
+		}
+		ret = append(ret, DeviceInfo{
+			Address:   st.DeviceInfo.PCI.PCIAddress,
+			Network:   st.Name,
+			Interface: st.Interface,
+		})
+	}
+	return ret, nil
+}
+
+// ReadAnnotations parses data in the downward API annotations format: one key="quoted value" per line
+func ReadAnnotations(rd io.Reader) (map[string]string, error) {
+	ret := make(map[string]string)
+	src := bufio.NewScanner(rd)
+	// network-status may be large
+	src.Buffer(make([]byte, 64*1024), 1024*1024)
+	for src.Scan() {
+		line := strings.TrimSpace(src.Text())
+		if line == "" {
+			continue
+		}
+		pair := strings.SplitN(line, "=", 2)
+		if len(pair) != 2 {
+			return ret, fmt.Errorf("malformed annotation: %q", line)
+		}
+		val, err := strconv.Unquote(pair[1])
+		if err != nil {
+			return ret, fmt.Errorf("malformed annotation value for %q: %w", pair[0], err)
+		}
+		ret[pair[0]] = val
+	}
+	return ret, src.Err()
+}
+
+// FilterDevices returns the devices selected by the filter. The devices unknown to sysfs are kept,
+// because the filter cannot tell anything about them.
+func FilterDevices(pciDevs *pcidev.PCIDevices, devs []DeviceInfo, filter pcidev.Filter) []DeviceInfo {
+	if filter.IsEmpty() {
+		return devs
+	}
+	var ret []DeviceInfo
+	for _, dev := range devs {
+		if pciInfo, found := pciDevs.FindByAddress(dev.Address); found && !filter.Match(pciInfo) {
+			log.Printf("PCI: %s: %s: skipped, not matching %q", dev.Resource, dev.Address, filter)
+			continue
+		}
+		ret = append(ret, dev)
+	}
+	return ret
+}
+
+// GetDevicesFromNetdevs resolves the given network interface names to the PCI devices backing them.
+// Returns also the names of the interfaces skipped because purely virtual, like veth or macvlan.
+func GetDevicesFromNetdevs(sysfs string, pciDevs *pcidev.PCIDevices, names []string) ([]DeviceInfo, []string, error) {
+	var devs []DeviceInfo
+	var skipped []string
+	for _, name := range names {
+		devInfo, err := pciDevs.FindByNetdev(sysfs, name)
+		if errors.Is(err, pcidev.ErrNoPCIDevice) {
+			log.Printf("PCI: netdev %q: %v - SKIP", name, err)
+			skipped = append(skipped, name)
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot resolve netdev %q: %w", name, err)
+		}
+		log.Printf("PCI: netdev %q: %v", name, devInfo)
+		devs = append(devs, DeviceInfo{
+			Address:   devInfo.Address(),
+			Interface: name,
+		})
+	}
+	return devs, skipped, nil
+}
+
+// GetDevicesFromBlockDevs resolves the given block devices to the PCI devices backing them.
+// Block devices can be given by kernel name, like "nvme0n1", or by path, relative to devRoot: either
+// the device node, like "/dev/nvme0n1p1", or a file on a filesystem on the device, like the mount point of a volume.
+// Stacked devices, like device-mapper or native multipath NVMe, resolve to all the PCI devices they are built on.
+// Returns also the block devices skipped because not backed by a PCI device, like loop or tmpfs.
+func GetDevicesFromBlockDevs(sysfs, devRoot string, names []string) ([]DeviceInfo, []string, error) {
+	if len(names) == 0 {
+		return nil, nil, nil
+	}
+	blockDevs, err := block.NewBlockDevices(sysfs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var devs []DeviceInfo
+	var skipped []string
+	for _, name := range names {
+		var bdis []block.BlockDeviceInfo
+		if filepath.IsAbs(name) {
+			bdis, err = blockDevs.FindByPath(sysfs, filepath.Join(devRoot, name))
+		} else {
+			bdis, err = blockDevs.FindBacking(sysfs, name)
+		}
+		if errors.Is(err, pcidev.ErrNoPCIDevice) {
+			log.Printf("PCI: block device %q: %v - SKIP", name, err)
+			skipped = append(skipped, name)
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot resolve block device %q: %w", name, err)
+		}
+		for _, bdi := range bdis {
+			log.Printf("PCI: block device %q: %v", name, bdi)
+			devs = append(devs, DeviceInfo{
+				Address:     bdi.Address,
+				BlockDevice: bdi.Name,
+			})
+		}
+	}
+	return devs, skipped, nil
+}
+
+// ResolveRDMADevices replaces the identifiers which are not PCI addresses, like the RDMA device names
+// some device plugins use as keys of the PCIDEVICE_*_INFO data, with the PCI address of the RDMA device:
+// either from the "rdma" "uverbs" spec, or from the RDMA device name. Unknown identifiers are kept as they are.
+func ResolveRDMADevices(sysfs string, pciDevs *pcidev.PCIDevices, devs []DeviceInfo) []DeviceInfo {
+	var ret []DeviceInfo
+	for _, dev := range devs {
+		if _, found := pciDevs.FindByAddress(dev.Address); found {
+			ret = append(ret, dev)
+			continue
+		}
+		var devInfo pcidev.PCIDeviceInfo
+		var err error
+		if uverbs, ok := dev.Specs["rdma"]["uverbs"]; ok {
+			devInfo, err = pciDevs.FindByUverbs(sysfs, uverbs)
+		} else {
+			devInfo, err = pciDevs.FindByRDMADevice(sysfs, dev.Address)
+		}
+		if err != nil {
+			log.Printf("PCI: cannot resolve %q as RDMA device: %v", dev.Address, err)
+			ret = append(ret, dev)
+			continue
+		}
+		log.Printf("PCI: RDMA device %q: %v", dev.Address, devInfo)
+		dev.Address = devInfo.Address()
+		ret = append(ret, dev)
+	}
+	return MergeDevices(ret)
+}
+
+// GetDevicesFromRDMADevs resolves the given RDMA devices to the PCI devices backing them.
+// RDMA devices can be given by name, like "mlx5_0", or by userspace verbs device, like "uverbs3" or
+// "/dev/infiniband/uverbs3", as mounted by the RDMA shared device plugin.
+func GetDevicesFromRDMADevs(sysfs string, pciDevs *pcidev.PCIDevices, names []string) ([]DeviceInfo, error) {
+	var devs []DeviceInfo
+	for _, name := range names {
+		var devInfo pcidev.PCIDeviceInfo
+		var err error
+		spec := make(map[string]string)
+		if strings.HasPrefix(filepath.Base(name), "uverbs") {
+			devInfo, err = pciDevs.FindByUverbs(sysfs, name)
+			spec["uverbs"] = name
+		} else {
+			devInfo, err = pciDevs.FindByRDMADevice(sysfs, name)
+			spec["device"] = name
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve RDMA device %q: %w", name, err)
+		}
+		log.Printf("PCI: RDMA device %q: %v", name, devInfo)
+		devs = append(devs, DeviceInfo{
+			Address: devInfo.Address(),
+			Specs: map[string]map[string]string{
+				"rdma": spec,
+			},
+		})
+	}
+	return devs, nil
+}
@@ -0,0 +1,302 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numalign
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
+	fakesysfs "github.com/ffromani/numalign/pkg/topologyinfo/sysfs/fake"
+)
+
+func TestGetDevicesFromEnv(t *testing.T) {
+	environ := []string{
+		"HOME=/root",
+		"PCIDEVICE_OPENSHIFT_IO_INTELNICS=0000:3b:02.1,0000:3b:02.2",
+		`PCIDEVICE_OPENSHIFT_IO_INTELNICS_INFO={"0000:3b:02.1":{"generic":{"deviceID":"0000:3b:02.1"}},"0000:3b:02.2":{"generic":{"deviceID":"0000:3b:02.2"},"vhost":{"mount":"/dev/vhost-net"}}}`,
+		`PCIDEVICE_NVIDIA_COM_MLX_RDMA_INFO={"0000:d8:00.2":{"generic":{"deviceID":"0000:d8:00.2"},"rdma":{"rdma_cm":"/dev/infiniband/rdma_cm","uverbs":"/dev/infiniband/uverbs3"}}}`,
+		"PCIDEVICE_FOOBAR=0000:00:1f.6",
+		"PCIDEVICE_BROKEN_INFO={not json",
+	}
+
+	expected := []DeviceInfo{
+		{Resource: "foobar", Address: "0000:00:1f.6"},
+		{Resource: "openshift.io/intelnics", Address: "0000:3b:02.1", Specs: map[string]map[string]string{}},
+		{
+			Resource: "openshift.io/intelnics",
+			Address:  "0000:3b:02.2",
+			Specs: map[string]map[string]string{
+				"vhost": {"mount": "/dev/vhost-net"},
+			},
+		},
+		{
+			Resource: "nvidia.com/mlx_rdma",
+			Address:  "0000:d8:00.2",
+			Specs: map[string]map[string]string{
+				"rdma": {"rdma_cm": "/dev/infiniband/rdma_cm", "uverbs": "/dev/infiniband/uverbs3"},
+			},
+		},
+	}
+
+	devs := GetDevicesFromEnv(environ)
+	if !cmp.Equal(devs, expected) {
+		t.Errorf("devices mismatch: %v", cmp.Diff(devs, expected))
+	}
+}
+
+func TestResourceNameFromEnv(t *testing.T) {
+	for name, expected := range map[string]string{
+		"PCIDEVICE_OPENSHIFT_IO_INTELNICS":    "openshift.io/intelnics",
+		"PCIDEVICE_INTEL_COM_SRIOV_NETDEVICE": "intel.com/sriov_netdevice",
+		"PCIDEVICE_FOOBAR":                    "foobar",
+		"PCIDEVICE_COM":                       "com",
+	} {
+		if got := ResourceNameFromEnv(name); got != expected {
+			t.Errorf("resource name mismatch for %q: got %q expected %q", name, got, expected)
+		}
+	}
+}
+
+func TestDeviceReasonMentionsResource(t *testing.T) {
+	base, teardown := setupFakeNode(t, 0)
+	defer teardown()
+
+	R, err := NewResources(Options{
+		SysFSRoot:  filepath.Join(base, "sys"),
+		ProcFSRoot: filepath.Join(base, "proc"),
+		Environ: []string{
+			"PCIDEVICE_OPENSHIFT_IO_INTELNICS=0000:3b:02.1",
+			`PCIDEVICE_OPENSHIFT_IO_INTELNICS_INFO={"0000:3b:02.1":{"generic":{"deviceID":"0000:3b:02.1"}}}`,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	res := R.CheckAlignment()
+	found := false
+	for _, reason := range res.Reasons {
+		if reason.Code == ReasonDeviceRemote {
+			found = true
+			if reason.Message != "openshift.io/intelnics device 0000:3b:02.1 is on NUMA node 0, CPUs are on NUMA nodes 1" {
+				t.Errorf("unexpected message: %q", reason.Message)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("missing %s reason: %v", ReasonDeviceRemote, res.Reasons)
+	}
+}
+
+const annotationsData string = `k8s.v1.cni.cncf.io/network-status="[{\n    \"name\": \"openshift-sdn\",\n    \"interface\": \"eth0\",\n    \"ips\": [\n        \"10.128.2.14\"\n    ],\n    \"default\": true,\n    \"dns\": {}\n},{\n    \"name\": \"default/sriov-intel\",\n    \"interface\": \"net1\",\n    \"mac\": \"52:54:00:aa:bb:cc\",\n    \"dns\": {},\n    \"device-info\": {\n        \"type\": \"pci\",\n        \"version\": \"1.0.0\",\n        \"pci\": {\n            \"pci-address\": \"0000:3b:02.1\"\n        }\n    }\n}]"
+k8s.v1.cni.cncf.io/networks="sriov-intel"
+kubernetes.io/config.seen="2021-11-15T10:23:43.000000000Z"
+`
+
+func TestReadAnnotationsNetworkStatus(t *testing.T) {
+	annotations, err := ReadAnnotations(strings.NewReader(annotationsData))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if annotations["k8s.v1.cni.cncf.io/networks"] != "sriov-intel" {
+		t.Errorf("unexpected annotations: %v", annotations)
+	}
+
+	devs, err := ParseNetworkStatus(annotations[NetworkStatusAnnotation])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []DeviceInfo{
+		{Address: "0000:3b:02.1", Network: "default/sriov-intel", Interface: "net1"},
+	}
+	if !cmp.Equal(devs, expected) {
+		t.Errorf("devices mismatch: %v", cmp.Diff(devs, expected))
+	}
+
+	merged := MergeDevices([]DeviceInfo{{Resource: "openshift.io/intelnics", Address: "0000:3b:02.1"}}, devs)
+	expectedMerged := []DeviceInfo{
+		{Resource: "openshift.io/intelnics", Address: "0000:3b:02.1", Network: "default/sriov-intel", Interface: "net1"},
+	}
+	if !cmp.Equal(merged, expectedMerged) {
+		t.Errorf("merged devices mismatch: %v", cmp.Diff(merged, expectedMerged))
+	}
+}
+
+func TestReadAnnotationsMalformed(t *testing.T) {
+	if _, err := ReadAnnotations(strings.NewReader("foo=\"unterminated\n")); err == nil {
+		t.Errorf("malformed annotations parsed without errors")
+	}
+}
+
+func TestResolveRDMADevices(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+
+	sysDevs := fs.AddTree("sys", "bus", "pci", "devices")
+	for _, idx := range []string{"0", "1"} {
+		addr := "0000:d8:00." + idx
+		mlxDev := sysDevs.Add(addr, fakesysfs.MakeAttrs(map[string]string{
+			"numa_node": "1",
+			"class":     "0x020700",
+			"vendor":    "0x15b3",
+			"device":    "0x1017",
+		}))
+		mlxDev.Add("infiniband", nil).Add("mlx5_"+idx, nil).AddLink("device", "../../../"+addr)
+		mlxDev.Add("infiniband_verbs", nil).Add("uverbs"+idx, fakesysfs.MakeAttrs(map[string]string{
+			"ibdev": "mlx5_" + idx,
+		})).AddLink("device", "../../../"+addr)
+	}
+	fs.AddTree("sys", "class", "infiniband").
+		AddLink("mlx5_0", "../../bus/pci/devices/0000:d8:00.0/infiniband/mlx5_0").
+		AddLink("mlx5_1", "../../bus/pci/devices/0000:d8:00.1/infiniband/mlx5_1")
+	fs.AddTree("sys", "class", "infiniband_verbs").
+		AddLink("uverbs0", "../../bus/pci/devices/0000:d8:00.0/infiniband_verbs/uverbs0").
+		AddLink("uverbs1", "../../bus/pci/devices/0000:d8:00.1/infiniband_verbs/uverbs1")
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	sysfs := filepath.Join(fs.Base(), "sys")
+	pciDevs, err := pcidev.NewPCIDevices(sysfs)
+	if err != nil {
+		t.Fatalf("error in NewPCIDevices: %v", err)
+	}
+
+	environ := []string{
+		`PCIDEVICE_RDMA_SHARED_DEVICE_A_INFO={"mlx5_1":{"rdma":{"uverbs":"/dev/infiniband/uverbs1"}}}`,
+		`PCIDEVICE_RDMA_SHARED_DEVICE_B_INFO={"mlx5_0":{"rdma":{"rdma_cm":"/dev/infiniband/rdma_cm"}}}`,
+	}
+	devs := ResolveRDMADevices(sysfs, pciDevs, GetDevicesFromEnv(environ))
+	expected := []DeviceInfo{
+		{
+			Resource: "rdma_shared_device_b",
+			Address:  "0000:d8:00.0",
+			Specs: map[string]map[string]string{
+				"rdma": {"rdma_cm": "/dev/infiniband/rdma_cm"},
+			},
+		},
+		{
+			Resource: "rdma_shared_device_a",
+			Address:  "0000:d8:00.1",
+			Specs: map[string]map[string]string{
+				"rdma": {"uverbs": "/dev/infiniband/uverbs1"},
+			},
+		},
+	}
+	if !cmp.Equal(devs, expected) {
+		t.Errorf("devices mismatch: %v", cmp.Diff(devs, expected))
+	}
+
+	devs, err = GetDevicesFromRDMADevs(sysfs, pciDevs, []string{"/dev/infiniband/uverbs0", "mlx5_1"})
+	if err != nil {
+		t.Fatalf("error in GetDevicesFromRDMADevs: %v", err)
+	}
+	expected = []DeviceInfo{
+		{Address: "0000:d8:00.0", Specs: map[string]map[string]string{"rdma": {"uverbs": "/dev/infiniband/uverbs0"}}},
+		{Address: "0000:d8:00.1", Specs: map[string]map[string]string{"rdma": {"device": "mlx5_1"}}},
+	}
+	if !cmp.Equal(devs, expected) {
+		t.Errorf("devices mismatch: %v", cmp.Diff(devs, expected))
+	}
+
+	if _, err := GetDevicesFromRDMADevs(sysfs, pciDevs, []string{"mlx5_7"}); err == nil {
+		t.Errorf("missing RDMA device resolved")
+	}
+}
+
+func TestFilterDevices(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+
+	sysDevs := fs.AddTree("sys", "bus", "pci", "devices")
+	for addr, driver := range map[string]string{"0000:05:10.0": "vfio-pci", "0000:05:10.1": "igbvf"} {
+		sysDevs.Add(addr, fakesysfs.MakeAttrs(map[string]string{
+			"numa_node": "0",
+			"class":     "0x020000",
+			"vendor":    "0x8086",
+			"device":    "0x1520",
+		})).AddLink("driver", "../../../bus/pci/drivers/"+driver)
+	}
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	pciDevs, err := pcidev.NewPCIDevices(filepath.Join(fs.Base(), "sys"))
+	if err != nil {
+		t.Fatalf("error in NewPCIDevices: %v", err)
+	}
+
+	devs := []DeviceInfo{
+		{Resource: "intel_vfio", Address: "0000:05:10.0"},
+		{Resource: "intel_netdev", Address: "0000:05:10.1"},
+		{Resource: "missing", Address: "0000:d8:00.0"},
+	}
+	if got := FilterDevices(pciDevs, devs, pcidev.Filter{}); !cmp.Equal(got, devs) {
+		t.Errorf("empty filter changed the devices: %v", cmp.Diff(got, devs))
+	}
+
+	filter, err := pcidev.ParseFilter("driver=vfio-pci")
+	if err != nil {
+		t.Fatalf("error in ParseFilter: %v", err)
+	}
+	expected := []DeviceInfo{devs[0], devs[2]}
+	if got := FilterDevices(pciDevs, devs, filter); !cmp.Equal(got, expected) {
+		t.Errorf("devices mismatch: %v", cmp.Diff(got, expected))
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numalign
+
+import (
+	"sort"
+	"strconv"
+)
+
+// NodeChange reports a resource which moved to another NUMA node
+type NodeChange struct {
+	ID   string `json:"id"`
+	From int    `json:"from"`
+	To   int    `json:"to"`
+}
+
+// Diff reports what changed between two checks
+type Diff struct {
+	CPUsAdded          []int        `json:"cpusadded,omitempty"`
+	CPUsRemoved        []int        `json:"cpusremoved,omitempty"`
+	DevicesAdded       []string     `json:"devicesadded,omitempty"`
+	DevicesRemoved     []string     `json:"devicesremoved,omitempty"`
+	DeviceNodeChanges  []NodeChange `json:"devicenodechanges,omitempty"`
+	MemoryNodesAdded   []int        `json:"memorynodesadded,omitempty"`
+	MemoryNodesRemoved []int        `json:"memorynodesremoved,omitempty"`
+	// AlignedChanged is true if the aligned state flipped. The new state is in the Result.
+	AlignedChanged bool `json:"alignedchanged,omitempty"`
+	// NUMANodesChanged is true if the resources moved to another set of NUMA nodes
+	NUMANodesChanged bool `json:"numanodeschanged,omitempty"`
+}
+
+// Empty tells if nothing changed
+func (d Diff) Empty() bool {
+	return len(d.CPUsAdded) == 0 && len(d.CPUsRemoved) == 0 &&
+		len(d.DevicesAdded) == 0 && len(d.DevicesRemoved) == 0 && len(d.DeviceNodeChanges) == 0 &&
+		len(d.MemoryNodesAdded) == 0 && len(d.MemoryNodesRemoved) == 0 &&
+		!d.AlignedChanged && !d.NUMANodesChanged
+}
+
+// DiffResults compares the outcome of two checks
+func DiffResults(prev, cur Result) Diff {
+	var d Diff
+
+	prevIDs := resourceNodes(prev, ResourceCPU)
+	curIDs := resourceNodes(cur, ResourceCPU)
+	d.CPUsAdded = toInts(missingKeys(curIDs, prevIDs))
+	d.CPUsRemoved = toInts(missingKeys(prevIDs, curIDs))
+
+	prevIDs = resourceNodes(prev, ResourceDevice)
+	curIDs = resourceNodes(cur, ResourceDevice)
+	d.DevicesAdded = missingKeys(curIDs, prevIDs)
+	d.DevicesRemoved = missingKeys(prevIDs, curIDs)
+	for _, id := range sortedKeys(curIDs) {
+		prevNode, ok := prevIDs[id]
+		if ok && prevNode != curIDs[id] {
+			d.DeviceNodeChanges = append(d.DeviceNodeChanges, NodeChange{
+				ID:   id,
+				From: prevNode,
+				To:   curIDs[id],
+			})
+		}
+	}
+
+	prevIDs = resourceNodes(prev, ResourceMemory)
+	curIDs = resourceNodes(cur, ResourceMemory)
+	d.MemoryNodesAdded = toInts(missingKeys(curIDs, prevIDs))
+	d.MemoryNodesRemoved = toInts(missingKeys(prevIDs, curIDs))
+
+	d.AlignedChanged = prev.Aligned != cur.Aligned
+	d.NUMANodesChanged = !equalInts(prev.NUMANodes, cur.NUMANodes)
+	return d
+}
+
+// resourceNodes maps the IDs of the resources of the given kind to their NUMA node
+func resourceNodes(res Result, kind ResourceKind) map[string]int {
+	ret := make(map[string]int)
+	for _, ri := range res.Resources {
+		if ri.Kind == kind {
+			ret[ri.ID] = ri.NUMACellID
+		}
+	}
+	return ret
+}
+
+// missingKeys returns the sorted keys of a not in b
+func missingKeys(a, b map[string]int) []string {
+	var ret []string
+	for _, key := range sortedKeys(a) {
+		if _, ok := b[key]; !ok {
+			ret = append(ret, key)
+		}
+	}
+	return ret
+}
+
+func sortedKeys(m map[string]int) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// toInts converts the IDs of CPUs and memory nodes, which are always numeric, back to ints
+func toInts(ids []string) []int {
+	var ret []int
+	for _, id := range ids {
+		val, err := strconv.Atoi(id)
+		if err != nil {
+			continue
+		}
+		ret = append(ret, val)
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
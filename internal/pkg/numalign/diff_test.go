@@ -0,0 +1,101 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numalign
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffResults(t *testing.T) {
+	base := Result{
+		Aligned:   true,
+		NUMANodes: []int{1},
+		Resources: []ResourceInfo{
+			{Kind: ResourceCPU, ID: "4", NUMACellID: 1},
+			{Kind: ResourceCPU, ID: "5", NUMACellID: 1},
+			{Kind: ResourceDevice, ID: "0000:3b:02.1", NUMACellID: 1},
+			{Kind: ResourceMemory, ID: "1", NUMACellID: 1},
+		},
+	}
+
+	type tcase struct {
+		name     string
+		cur      Result
+		expected Diff
+	}
+
+	for _, tc := range []tcase{
+		{
+			name:     "unchanged",
+			cur:      base,
+			expected: Diff{},
+		},
+		{
+			name: "CPUs moved across nodes",
+			cur: Result{
+				Aligned:   false,
+				NUMANodes: []int{0, 1},
+				Resources: []ResourceInfo{
+					{Kind: ResourceCPU, ID: "3", NUMACellID: 0},
+					{Kind: ResourceCPU, ID: "4", NUMACellID: 1},
+					{Kind: ResourceDevice, ID: "0000:3b:02.1", NUMACellID: 1},
+					{Kind: ResourceMemory, ID: "0", NUMACellID: 0},
+					{Kind: ResourceMemory, ID: "1", NUMACellID: 1},
+				},
+			},
+			expected: Diff{
+				CPUsAdded:        []int{3},
+				CPUsRemoved:      []int{5},
+				MemoryNodesAdded: []int{0},
+				AlignedChanged:   true,
+				NUMANodesChanged: true,
+			},
+		},
+		{
+			name: "device changes",
+			cur: Result{
+				Aligned:   true,
+				NUMANodes: []int{1},
+				Resources: []ResourceInfo{
+					{Kind: ResourceCPU, ID: "4", NUMACellID: 1},
+					{Kind: ResourceCPU, ID: "5", NUMACellID: 1},
+					{Kind: ResourceDevice, ID: "0000:3b:02.1", NUMACellID: -1},
+					{Kind: ResourceDevice, ID: "0000:3b:02.2", NUMACellID: 1},
+					{Kind: ResourceMemory, ID: "1", NUMACellID: 1},
+				},
+			},
+			expected: Diff{
+				DevicesAdded: []string{"0000:3b:02.2"},
+				DeviceNodeChanges: []NodeChange{
+					{ID: "0000:3b:02.1", From: 1, To: -1},
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := DiffResults(base, tc.cur)
+			if !cmp.Equal(got, tc.expected) {
+				t.Errorf("unexpected diff: %s", cmp.Diff(tc.expected, got))
+			}
+			if got.Empty() != cmp.Equal(tc.expected, Diff{}) {
+				t.Errorf("unexpected Empty()=%v", got.Empty())
+			}
+		})
+	}
+}
@@ -0,0 +1,325 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numalign
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
+)
+
+var (
+	// DefaultCDISpecDirs are the directories the container runtimes read the CDI specs from
+	DefaultCDISpecDirs = []string{"/etc/cdi", "/var/run/cdi"}
+)
+
+var pciAddressRe = regexp.MustCompile(`^[0-9a-fA-F]{4}:[0-9a-fA-F]{2}:[0-9a-fA-F]{2}\.[0-7]$`)
+
+// MappingConfig tells how to find the devices allocated for resources not handled by
+// the SR-IOV device plugin, like GPUs, FPGAs or NVMe drives.
+type MappingConfig struct {
+	Mappings []ResourceMapping `json:"mappings"`
+}
+
+// ResourceMapping tells how to find the devices allocated for a resource. Exactly one source must be set.
+type ResourceMapping struct {
+	// Resource is the extended resource name, like "nvidia.com/gpu"
+	Resource string          `json:"resource"`
+	Env      *EnvSource      `json:"env,omitempty"`
+	DevNodes *DevNodesSource `json:"devNodes,omitempty"`
+	CDI      *CDISource      `json:"cdi,omitempty"`
+}
+
+// EnvSource reads the device identifiers from environment variables.
+// The identifiers can be PCI addresses or device node paths.
+type EnvSource struct {
+	// Pattern matches the variable names, using the path.Match syntax, like "FPGA_DEVICE_*"
+	Pattern string `json:"pattern"`
+	// Separator splits the variable values. Defaults to ","
+	Separator string `json:"separator,omitempty"`
+}
+
+// DevNodesSource resolves the device nodes mounted in the container through sysfs
+type DevNodesSource struct {
+	// Paths are the device nodes, using the filepath.Glob syntax, like "/dev/nvme*n1"
+	Paths []string `json:"paths"`
+}
+
+// CDISource reads the Container Device Interface spec files. A device is allocated to the container
+// if any of its device nodes is present in the container.
+type CDISource struct {
+	// Kind is the CDI kind, like "vendor.com/fpga". All the kinds if empty.
+	Kind string `json:"kind,omitempty"`
+	// SpecDirs are the directories holding the spec files, relative to the device root like the
+	// device nodes: the specs describe the host the container runs on. DefaultCDISpecDirs if empty.
+	SpecDirs []string `json:"specDirs,omitempty"`
+}
+
+// LoadMappingConfig reads a mapping config file, in YAML or JSON
+func LoadMappingConfig(path string) (*MappingConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMappingConfig(data)
+}
+
+// ParseMappingConfig parses and validates a mapping config, in YAML or JSON
+func ParseMappingConfig(data []byte) (*MappingConfig, error) {
+	var conf MappingConfig
+	if err := yaml.UnmarshalStrict(data, &conf); err != nil {
+		return nil, err
+	}
+	for idx, rm := range conf.Mappings {
+		if rm.Resource == "" {
+			return nil, fmt.Errorf("mapping #%d: missing resource name", idx)
+		}
+		sources := 0
+		if rm.Env != nil {
+			if _, err := path.Match(rm.Env.Pattern, ""); err != nil || rm.Env.Pattern == "" {
+				return nil, fmt.Errorf("mapping %q: invalid env pattern %q", rm.Resource, rm.Env.Pattern)
+			}
+			sources++
+		}
+		if rm.DevNodes != nil {
+			for _, pattern := range rm.DevNodes.Paths {
+				if _, err := filepath.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("mapping %q: invalid device node pattern %q", rm.Resource, pattern)
+				}
+			}
+			sources++
+		}
+		if rm.CDI != nil {
+			sources++
+		}
+		if sources != 1 {
+			return nil, fmt.Errorf("mapping %q: expected exactly one source, found %d", rm.Resource, sources)
+		}
+	}
+	return &conf, nil
+}
+
+// mappingContext holds what is needed to resolve the device identifiers
+type mappingContext struct {
+	sysfs   string
+	devRoot string
+	environ []string
+	pciDevs *pcidev.PCIDevices
+}
+
+// GetDevicesFromMappings returns the devices found using the mapping config, sorted by address.
+// Device node paths and CDI spec dirs are relative to devRoot, usually "/".
+func GetDevicesFromMappings(conf *MappingConfig, sysfs, devRoot string, environ []string, pciDevs *pcidev.PCIDevices) ([]DeviceInfo, error) {
+	mc := mappingContext{
+		sysfs:   sysfs,
+		devRoot: devRoot,
+		environ: environ,
+		pciDevs: pciDevs,
+	}
+	var devSets [][]DeviceInfo
+	for _, rm := range conf.Mappings {
+		var pciInfos []pcidev.PCIDeviceInfo
+		var err error
+		switch {
+		case rm.Env != nil:
+			pciInfos, err = mc.fromEnv(rm.Env)
+		case rm.DevNodes != nil:
+			pciInfos, err = mc.fromDevNodes(rm.DevNodes)
+		case rm.CDI != nil:
+			pciInfos, err = mc.fromCDI(rm.CDI)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("mapping %q: %w", rm.Resource, err)
+		}
+		var devs []DeviceInfo
+		for _, pciInfo := range pciInfos {
+			log.Printf("PCI: %s: %v", rm.Resource, pciInfo)
+			devs = append(devs, DeviceInfo{
+				Resource: rm.Resource,
+				Address:  pciInfo.Address(),
+			})
+		}
+		devSets = append(devSets, devs)
+	}
+	return MergeDevices(devSets...), nil
+}
+
+func (mc mappingContext) fromEnv(src *EnvSource) ([]pcidev.PCIDeviceInfo, error) {
+	sep := src.Separator
+	if sep == "" {
+		sep = ","
+	}
+	var ret []pcidev.PCIDeviceInfo
+	for _, envVar := range mc.environ {
+		pair := strings.SplitN(envVar, "=", 2)
+		if len(pair) != 2 || pair[1] == "" {
+			continue
+		}
+		if ok, _ := path.Match(src.Pattern, pair[0]); !ok {
+			continue
+		}
+		for _, devID := range strings.Split(pair[1], sep) {
+			devID = strings.TrimSpace(devID)
+			if devID == "" {
+				continue
+			}
+			pciInfo, err := mc.resolve(devID)
+			if errors.Is(err, pcidev.ErrNoPCIDevice) {
+				log.Printf("PCI: %s: %q: %v - SKIP", pair[0], devID, err)
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("cannot resolve %q from %q: %w", devID, pair[0], err)
+			}
+			ret = append(ret, pciInfo)
+		}
+	}
+	return ret, nil
+}
+
+func (mc mappingContext) fromDevNodes(src *DevNodesSource) ([]pcidev.PCIDeviceInfo, error) {
+	var ret []pcidev.PCIDeviceInfo
+	for _, pattern := range src.Paths {
+		matches, err := filepath.Glob(filepath.Join(mc.devRoot, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			pciInfo, err := mc.pciDevs.FindByDevNode(mc.sysfs, match)
+			if errors.Is(err, pcidev.ErrNoPCIDevice) {
+				log.Printf("PCI: %q: %v - SKIP", match, err)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, pciInfo)
+		}
+	}
+	return ret, nil
+}
+
+// cdiSpec is the subset of the CDI spec we care about, see
+// https://github.com/container-orchestrated-devices/container-device-interface
+type cdiSpec struct {
+	Kind    string `json:"kind"`
+	Devices []struct {
+		Name           string `json:"name"`
+		ContainerEdits struct {
+			DeviceNodes []struct {
+				Path string `json:"path"`
+			} `json:"deviceNodes"`
+		} `json:"containerEdits"`
+	} `json:"devices"`
+}
+
+func (mc mappingContext) fromCDI(src *CDISource) ([]pcidev.PCIDeviceInfo, error) {
+	specDirs := src.SpecDirs
+	if len(specDirs) == 0 {
+		specDirs = DefaultCDISpecDirs
+	}
+	var ret []pcidev.PCIDeviceInfo
+	for _, specDir := range specDirs {
+		specs, err := readCDISpecs(filepath.Join(mc.devRoot, specDir))
+		if err != nil {
+			return nil, err
+		}
+		for _, spec := range specs {
+			if src.Kind != "" && spec.Kind != src.Kind {
+				continue
+			}
+			for _, dev := range spec.Devices {
+				for _, node := range dev.ContainerEdits.DeviceNodes {
+					nodePath := filepath.Join(mc.devRoot, node.Path)
+					if _, err := os.Stat(nodePath); err != nil {
+						// not allocated to this container
+						continue
+					}
+					pciInfo, err := mc.pciDevs.FindByDevNode(mc.sysfs, nodePath)
+					if errors.Is(err, pcidev.ErrNoPCIDevice) {
+						log.Printf("PCI: CDI %s=%s: %q: %v - SKIP", spec.Kind, dev.Name, node.Path, err)
+						continue
+					}
+					if err != nil {
+						return nil, fmt.Errorf("CDI device %s=%s: %w", spec.Kind, dev.Name, err)
+					}
+					ret = append(ret, pciInfo)
+				}
+			}
+		}
+	}
+	return ret, nil
+}
+
+// readCDISpecs reads all the spec files in a directory. A missing directory holds no specs.
+func readCDISpecs(specDir string) ([]cdiSpec, error) {
+	entries, err := ioutil.ReadDir(specDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		switch filepath.Ext(entry.Name()) {
+		case ".json", ".yaml":
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var specs []cdiSpec
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(specDir, name))
+		if err != nil {
+			return nil, err
+		}
+		var spec cdiSpec
+		if err := yaml.Unmarshal(data, &spec); err != nil {
+			return nil, fmt.Errorf("malformed CDI spec %q: %w", name, err)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// resolve finds the PCI device from its identifier, either a PCI address or a device node path
+func (mc mappingContext) resolve(devID string) (pcidev.PCIDeviceInfo, error) {
+	if pciAddressRe.MatchString(devID) {
+		pciInfo, found := mc.pciDevs.FindByAddress(strings.ToLower(devID))
+		if !found {
+			return nil, fmt.Errorf("%w: unknown PCI address", pcidev.ErrNoPCIDevice)
+		}
+		return pciInfo, nil
+	}
+	if filepath.IsAbs(devID) {
+		return mc.pciDevs.FindByDevNode(mc.sysfs, filepath.Join(mc.devRoot, devID))
+	}
+	return nil, fmt.Errorf("%w: unsupported identifier", pcidev.ErrNoPCIDevice)
+}
@@ -0,0 +1,396 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numalign
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
+	fakesysfs "github.com/ffromani/numalign/pkg/topologyinfo/sysfs/fake"
+)
+
+func TestParseMappingConfig(t *testing.T) {
+	type tcase struct {
+		name    string
+		data    string
+		wantErr bool
+	}
+
+	for _, tc := range []tcase{
+		{
+			name: "all sources",
+			data: `
+mappings:
+- resource: intel.com/fpga
+  env:
+    pattern: "FPGA_DEVICE_*"
+- resource: example.com/nvme
+  devNodes:
+    paths: ["/dev/nvme*n1"]
+- resource: nvidia.com/gpu
+  cdi:
+    kind: nvidia.com/gpu
+`,
+		},
+		{
+			name: "missing resource",
+			data: `
+mappings:
+- env:
+    pattern: "FPGA_DEVICE_*"
+`,
+			wantErr: true,
+		},
+		{
+			name: "no source",
+			data: `
+mappings:
+- resource: intel.com/fpga
+`,
+			wantErr: true,
+		},
+		{
+			name: "too many sources",
+			data: `
+mappings:
+- resource: intel.com/fpga
+  env:
+    pattern: "FPGA_DEVICE_*"
+  cdi: {}
+`,
+			wantErr: true,
+		},
+		{
+			name: "bad pattern",
+			data: `
+mappings:
+- resource: intel.com/fpga
+  env:
+    pattern: "FPGA_[DEVICE"
+`,
+			wantErr: true,
+		},
+		{
+			name: "unknown field",
+			data: `
+mappings:
+- resource: intel.com/fpga
+  envs:
+    pattern: "FPGA_DEVICE_*"
+`,
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseMappingConfig([]byte(tc.data))
+			if (err != nil) != tc.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetDevicesFromMappingsEnv(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+
+	sysDevs := fs.AddTree("sys", "bus", "pci", "devices")
+	for addr, node := range map[string]string{
+		"0000:af:00.0": "1",
+		"0000:af:00.1": "1",
+		"0000:3b:00.0": "0",
+	} {
+		sysDevs.Add(addr, fakesysfs.MakeAttrs(map[string]string{
+			"numa_node": node,
+			"class":     "0x120000",
+			"vendor":    "0x8086",
+			"device":    "0x0b30",
+		}))
+	}
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	sysfs := filepath.Join(fs.Base(), "sys")
+	pciDevs, err := pcidev.NewPCIDevices(sysfs)
+	if err != nil {
+		t.Fatalf("error in NewPCIDevices: %v", err)
+	}
+
+	conf, err := ParseMappingConfig([]byte(`
+mappings:
+- resource: intel.com/fpga
+  env:
+    pattern: "FPGA_DEVICE_*"
+- resource: example.com/accel
+  env:
+    pattern: ACCEL_DEVICES
+    separator: ";"
+`))
+	if err != nil {
+		t.Fatalf("error parsing the config: %v", err)
+	}
+
+	environ := []string{
+		"HOME=/root",
+		"FPGA_DEVICE_0=0000:AF:00.0",
+		"FPGA_DEVICE_1=0000:af:00.1",
+		"ACCEL_DEVICES=0000:3b:00.0;GPU-8f5c",
+	}
+	devs, err := GetDevicesFromMappings(conf, sysfs, DefaultDevRoot, environ, pciDevs)
+	if err != nil {
+		t.Fatalf("error in GetDevicesFromMappings: %v", err)
+	}
+	expected := []DeviceInfo{
+		{Resource: "example.com/accel", Address: "0000:3b:00.0"},
+		{Resource: "intel.com/fpga", Address: "0000:af:00.0"},
+		{Resource: "intel.com/fpga", Address: "0000:af:00.1"},
+	}
+	if !cmp.Equal(devs, expected) {
+		t.Errorf("devices mismatch: %v", cmp.Diff(devs, expected))
+	}
+
+	// skipped like the identifiers of other kinds
+	environ = append(environ, "FPGA_DEVICE_2=0000:af:00.7")
+	devs, err = GetDevicesFromMappings(conf, sysfs, DefaultDevRoot, environ, pciDevs)
+	if err != nil {
+		t.Fatalf("error in GetDevicesFromMappings with an unknown device: %v", err)
+	}
+	if !cmp.Equal(devs, expected) {
+		t.Errorf("devices mismatch with an unknown device: %v", cmp.Diff(devs, expected))
+	}
+}
+
+func TestGetDevicesFromMappingsDevNodesCDI(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+
+	sysDevs := fs.AddTree("sys", "bus", "pci", "devices")
+	for addr, node := range map[string]string{
+		"0000:af:00.0": "1",
+		"0000:af:00.1": "1",
+	} {
+		sysDevs.Add(addr, fakesysfs.MakeAttrs(map[string]string{
+			"numa_node": node,
+			"class":     "0x120000",
+			"vendor":    "0x8086",
+			"device":    "0x0b30",
+		})).Add("dfl", nil)
+	}
+	fs.AddTree("sys", "devices", "virtual", "mem", "full")
+	// the device nodes below are links to the real /dev/null (1:3), /dev/zero (1:5) and /dev/full (1:7):
+	// the fake sysfs tells which devices they are
+	fs.AddTree("sys", "dev", "char").
+		AddLink("1:3", "../../bus/pci/devices/0000:af:00.0/dfl").
+		AddLink("1:5", "../../bus/pci/devices/0000:af:00.1/dfl").
+		AddLink("1:7", "../../devices/virtual/mem/full")
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	devRoot := filepath.Join(fs.Base(), "root")
+	for _, dir := range []string{"dev", "etc/cdi"} {
+		if err := os.MkdirAll(filepath.Join(devRoot, dir), 0755); err != nil {
+			t.Fatalf("error creating %q: %v", dir, err)
+		}
+	}
+	for name, target := range map[string]string{
+		"dfl-port.0": "/dev/null",
+		"dfl-port.1": "/dev/zero",
+		"dfl-port.9": "/dev/full",
+	} {
+		if err := os.Symlink(target, filepath.Join(devRoot, "dev", name)); err != nil {
+			t.Fatalf("error creating the device node %q: %v", name, err)
+		}
+	}
+	spec := `
+cdiVersion: "0.3.0"
+kind: "intel.com/fpga"
+devices:
+- name: "afu0"
+  containerEdits:
+    deviceNodes:
+    - path: "/dev/dfl-port.0"
+- name: "afu2"
+  containerEdits:
+    deviceNodes:
+    - path: "/dev/dfl-port.2"
+- name: "virtual"
+  containerEdits:
+    deviceNodes:
+    - path: "/dev/dfl-port.9"
+`
+	if err := ioutil.WriteFile(filepath.Join(devRoot, "etc", "cdi", "fpga.yaml"), []byte(spec), 0644); err != nil {
+		t.Fatalf("error writing the CDI spec: %v", err)
+	}
+
+	sysfs := filepath.Join(fs.Base(), "sys")
+	pciDevs, err := pcidev.NewPCIDevices(sysfs)
+	if err != nil {
+		t.Fatalf("error in NewPCIDevices: %v", err)
+	}
+
+	type tcase struct {
+		name     string
+		conf     string
+		expected []DeviceInfo
+	}
+	for _, tc := range []tcase{
+		{
+			name: "device nodes",
+			conf: `
+mappings:
+- resource: example.com/fpga
+  devNodes:
+    paths: ["/dev/dfl-port.*"]
+`,
+			expected: []DeviceInfo{
+				{Resource: "example.com/fpga", Address: "0000:af:00.0"},
+				{Resource: "example.com/fpga", Address: "0000:af:00.1"},
+			},
+		},
+		{
+			name: "CDI, default spec dirs",
+			conf: `
+mappings:
+- resource: intel.com/fpga
+  cdi:
+    kind: intel.com/fpga
+`,
+			expected: []DeviceInfo{
+				{Resource: "intel.com/fpga", Address: "0000:af:00.0"},
+			},
+		},
+		{
+			name: "CDI, other kind",
+			conf: `
+mappings:
+- resource: nvidia.com/gpu
+  cdi:
+    kind: nvidia.com/gpu
+    specDirs: ["/etc/cdi"]
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conf, err := ParseMappingConfig([]byte(tc.conf))
+			if err != nil {
+				t.Fatalf("error parsing the config: %v", err)
+			}
+			devs, err := GetDevicesFromMappings(conf, sysfs, devRoot, nil, pciDevs)
+			if err != nil {
+				t.Fatalf("error in GetDevicesFromMappings: %v", err)
+			}
+			if !cmp.Equal(devs, tc.expected) {
+				t.Errorf("devices mismatch: %v", cmp.Diff(devs, tc.expected))
+			}
+		})
+	}
+}
+
+func TestReadCDISpecs(t *testing.T) {
+	specDir, err := ioutil.TempDir("/tmp", "cdispecs")
+	if err != nil {
+		t.Fatalf("error creating temp spec dir: %v", err)
+	}
+	defer os.RemoveAll(specDir)
+
+	specs := map[string]string{
+		"fpga.yaml": `
+cdiVersion: "0.3.0"
+kind: "intel.com/fpga"
+devices:
+- name: "afu0"
+  containerEdits:
+    deviceNodes:
+    - path: "/dev/dfl-port.0"
+`,
+		"gpu.json": `{"cdiVersion":"0.3.0","kind":"nvidia.com/gpu","devices":[{"name":"0","containerEdits":{"deviceNodes":[{"path":"/dev/nvidia0"},{"path":"/dev/dri/renderD128"}]}}],"containerEdits":{"deviceNodes":[{"path":"/dev/nvidiactl"}]}}`,
+		"README":   "ignored",
+	}
+	for name, data := range specs {
+		if err := ioutil.WriteFile(filepath.Join(specDir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("error writing %q: %v", name, err)
+		}
+	}
+
+	got, err := readCDISpecs(specDir)
+	if err != nil {
+		t.Fatalf("error reading the specs: %v", err)
+	}
+	var nodes []string
+	for _, spec := range got {
+		for _, dev := range spec.Devices {
+			for _, node := range dev.ContainerEdits.DeviceNodes {
+				nodes = append(nodes, spec.Kind+"="+dev.Name+":"+node.Path)
+			}
+		}
+	}
+	expected := []string{
+		"intel.com/fpga=afu0:/dev/dfl-port.0",
+		"nvidia.com/gpu=0:/dev/nvidia0",
+		"nvidia.com/gpu=0:/dev/dri/renderD128",
+	}
+	if !cmp.Equal(nodes, expected) {
+		t.Errorf("device nodes mismatch: %v", cmp.Diff(nodes, expected))
+	}
+
+	if got, err := readCDISpecs(filepath.Join(specDir, "missing")); err != nil || len(got) != 0 {
+		t.Errorf("unexpected result reading missing dir: %v %v", got, err)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numalign
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGetAllowedMemNodeList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "numalign-status")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	type tcase struct {
+		name     string
+		status   string
+		expected []int
+		fails    bool
+	}
+	for idx, tc := range []tcase{
+		{
+			name:     "single node",
+			status:   "Name:\tnumalign\nCpus_allowed_list:\t0-3\nMems_allowed:\t00000000,00000001\nMems_allowed_list:\t0\n",
+			expected: []int{0},
+		},
+		{
+			name:     "range and list",
+			status:   "Name:\tnumalign\nMems_allowed_list:\t0-1,3\nvoluntary_ctxt_switches:\t1\n",
+			expected: []int{0, 1, 3},
+		},
+		{
+			name:   "missing key",
+			status: "Name:\tnumalign\nCpus_allowed_list:\t0-3\n",
+			fails:  true,
+		},
+		{
+			name:   "malformed list",
+			status: "Mems_allowed_list:\t0-x\n",
+			fails:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			statusFile := filepath.Join(dir, fmt.Sprintf("status%d", idx))
+			if err := ioutil.WriteFile(statusFile, []byte(tc.status), 0644); err != nil {
+				t.Fatalf("error writing the status file: %v", err)
+			}
+			got, err := GetAllowedMemNodeList(statusFile)
+			if tc.fails {
+				if err == nil {
+					t.Errorf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !cmp.Equal(got, tc.expected) {
+				t.Errorf("memory nodes mismatch: got %v expected %v", got, tc.expected)
+			}
+		})
+	}
+
+	if _, err := GetAllowedMemNodeList(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("read memory nodes from a missing status file")
+	}
+}
+
+func TestGetMemNodeMap(t *testing.T) {
+	type tcase struct {
+		name     string
+		nodes    []int
+		expected map[int]bool
+	}
+	for _, tc := range []tcase{
+		{name: "none", nodes: nil, expected: map[int]bool{}},
+		{name: "single", nodes: []int{1}, expected: map[int]bool{1: true}},
+		{name: "duplicates", nodes: []int{0, 2, 2}, expected: map[int]bool{0: true, 2: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := GetMemNodeMap(tc.nodes)
+			if !cmp.Equal(got, tc.expected) {
+				t.Errorf("memory node map mismatch: got %v expected %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCheckAlignmentMemoryNodes(t *testing.T) {
+	type tcase struct {
+		name      string
+		cpuIDs    []int
+		devNodes  map[string]int
+		memNodes  []int
+		aligned   bool
+		numaNodes []int
+	}
+	for _, tc := range []tcase{
+		{
+			name:      "memory on the CPU node",
+			cpuIDs:    []int{0, 1},
+			devNodes:  map[string]int{},
+			memNodes:  []int{0},
+			aligned:   true,
+			numaNodes: []int{0},
+		},
+		{
+			name:      "memory on the CPU and device node",
+			cpuIDs:    []int{8, 9},
+			devNodes:  map[string]int{"0000:d8:02.1": 2},
+			memNodes:  []int{2},
+			aligned:   true,
+			numaNodes: []int{2},
+		},
+		{
+			name:      "memory on another node",
+			cpuIDs:    []int{0, 1},
+			devNodes:  map[string]int{},
+			memNodes:  []int{1},
+			aligned:   false,
+			numaNodes: []int{0, 1},
+		},
+		{
+			name:      "memory on all the nodes",
+			cpuIDs:    []int{4, 5},
+			devNodes:  map[string]int{"0000:3b:02.1": 1},
+			memNodes:  []int{0, 1, 2, 3},
+			aligned:   false,
+			numaNodes: []int{0, 1, 2, 3},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			R := newTestResources(t, tc.cpuIDs, tc.devNodes, tc.memNodes)
+			res := R.CheckAlignment()
+			if res.Aligned != tc.aligned {
+				t.Errorf("aligned mismatch: got %v expected %v", res.Aligned, tc.aligned)
+			}
+			if !cmp.Equal(res.NUMANodes, tc.numaNodes) {
+				t.Errorf("NUMA nodes mismatch: got %v expected %v", res.NUMANodes, tc.numaNodes)
+			}
+			for _, memNode := range tc.memNodes {
+				if line := fmt.Sprintf("MEM node#%02d=true\n", memNode); !strings.Contains(R.String(), line) {
+					t.Errorf("missing %q in %q", line, R.String())
+				}
+			}
+		})
+	}
+}
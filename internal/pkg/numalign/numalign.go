@@ -17,19 +17,20 @@
 package numalign
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
-	"reflect"
 	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/ffromani/cpuset"
+	"github.com/ffromani/numalign/pkg/irqs"
+	"github.com/ffromani/numalign/pkg/numamaps"
 	"github.com/ffromani/numalign/pkg/topologyinfo/cpus"
+	"github.com/ffromani/numalign/pkg/topologyinfo/numa/distances"
 	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
 )
 
@@ -39,6 +40,63 @@ const (
 	SysBusPCIDevicesDir     = "/sys/bus/pci/devices/"
 )
 
+const (
+	DefaultSysFSRoot  = "/sys"
+	DefaultProcFSRoot = "/proc"
+	DefaultDevRoot    = "/"
+)
+
+// Options tells NewResources what to check, and where to find the informations
+type Options struct {
+	// SysFSRoot is the sysfs mount point. DefaultSysFSRoot if empty.
+	SysFSRoot string
+	// ProcFSRoot is the procfs mount point. DefaultProcFSRoot if empty.
+	ProcFSRoot string
+	// Environ is the environment to discover the devices from, in the same format as os.Environ().
+	Environ []string
+	// Pids are the processes to check. The calling process ("self") if empty.
+	Pids []string
+	// AnnotationsFile, if not empty, is a downward API file to read the Multus network-status annotation from.
+	AnnotationsFile string
+	// Netdevs are network interfaces whose backing PCI devices should be checked as well.
+	Netdevs []string
+	// RDMADevs are RDMA devices, by name or userspace verbs device, whose backing PCI devices should be checked as well.
+	RDMADevs []string
+	// BlockDevs are block devices, by name or path, whose backing PCI devices should be checked as well.
+	BlockDevs []string
+	// MappingFile, if not empty, is a MappingConfig file telling how to find the devices of other resources.
+	MappingFile string
+	// DevRoot is the root of the BlockDevs paths, and of the device node paths and CDI spec dirs found using the MappingFile.
+	// DefaultDevRoot if empty.
+	DevRoot string
+	// DeviceFilter selects which of the devices found above are checked. All of them if empty.
+	DeviceFilter pcidev.Filter
+	// ExclusiveCPUs tells that the CPUs of the processes are reserved to them, like the ones the kubelet
+	// CPU manager static policy assigns to Guaranteed pods. The isolated CPUs always are.
+	ExclusiveCPUs bool
+}
+
+func (opts Options) sysFSRoot() string {
+	if opts.SysFSRoot == "" {
+		return DefaultSysFSRoot
+	}
+	return opts.SysFSRoot
+}
+
+func (opts Options) procFSRoot() string {
+	if opts.ProcFSRoot == "" {
+		return DefaultProcFSRoot
+	}
+	return opts.ProcFSRoot
+}
+
+func (opts Options) devRoot() string {
+	if opts.DevRoot == "" {
+		return DefaultDevRoot
+	}
+	return opts.DevRoot
+}
+
 func splitCPUList(cpuList string) ([]int, error) {
 	ret, err := cpuset.Parse(cpuList)
 	if err != nil {
@@ -50,52 +108,154 @@ func splitCPUList(cpuList string) ([]int, error) {
 type Resources struct {
 	CPUToNUMANode     map[int]int
 	PCIDevsToNUMANode map[string]int
+	// PCIDevsNUMAInference tells how the NUMA node of the devices reporting none was inferred, by PCI address.
+	// PCIDevsToNUMANode holds the inferred NUMA nodes. May lack entries.
+	PCIDevsNUMAInference map[string]pcidev.NUMAInference
+	// PCIDevsInfo holds what the device plugins told about the devices, by PCI address. May lack entries.
+	PCIDevsInfo map[string]DeviceInfo
+	// SkippedNetdevs are the network interfaces requested for checking, but not backed by PCI devices
+	SkippedNetdevs []string
+	// SkippedBlockDevs are the block devices requested for checking, but not backed by PCI devices
+	SkippedBlockDevs []string
+	// MemoryNUMANodes is the set of NUMA nodes the process is allowed to allocate memory from
+	MemoryNUMANodes map[int]bool
+	// PageResidency reports where the pages of the processes actually are. May be nil if unavailable.
+	PageResidency *numamaps.Info
+	// CPUsPerNUMANode are all the CPUs of each NUMA node in the system, allowed or not
+	CPUsPerNUMANode map[int]cpus.CPUIdList
+	// Distances between the NUMA nodes. May be nil if unavailable.
+	Distances *distances.Distances
+	// Threads is the CPU affinity of all the threads of all the processes checked
+	Threads []ThreadAffinity
+	// AffinityMismatches are the threads whose affinity differs from the one of the first process
+	AffinityMismatches []AffinityMismatch
+	// DeviceIRQs are the interrupts of each device, by PCI address. Lacks the devices whose interrupts cannot be read.
+	DeviceIRQs map[string][]irqs.Info
+	// IsolatedCPUs are the CPUs isolated from the kernel scheduler (isolcpus=). May be empty.
+	IsolatedCPUs []int
+	// ExclusiveCPUs tells that all the CPUs in CPUToNUMANode are reserved to the processes checked
+	ExclusiveCPUs bool
 }
 
-type Result struct {
-	Aligned    bool `json:"aligned"`
-	NUMACellID int  `json:"numacellid"`
+// CheckAlignment checks if all the resources are on the same NUMA node
+func (R *Resources) CheckAlignment() Result {
+	return R.CheckAlignmentWithPolicy(PolicySingleNUMANode)
 }
 
-func (re Result) Text() string {
-	var b strings.Builder
-	fmt.Fprintf(&b, "STATUS ALIGNED=%v\n", re.Aligned)
-	fmt.Fprintf(&b, "NUMA NODE=%v\n", re.NUMACellID)
-	return b.String()
+// CheckMemoryResidency verifies that no more than maxRemoteRatio (0..1) of the resident memory
+// is on NUMA nodes other than the ones the resources are aligned to.
+func (R *Resources) CheckMemoryResidency(res Result, maxRemoteRatio float64) (Result, error) {
+	if R.PageResidency == nil {
+		return res, fmt.Errorf("page residency data not available")
+	}
+	if !res.Aligned {
+		// nothing to compare against
+		return res, nil
+	}
+	ratio := numamaps.RemoteRatio(R.PageResidency.ResidentBytes(), res.NUMANodes...)
+	res.RemoteMemory = &ratio
+	if ratio > maxRemoteRatio {
+		res.Aligned = false
+		res.addReason(ReasonMemoryResidentRemote, "%.2f%% of resident memory is outside NUMA nodes %s, max allowed %.2f%%", ratio*100.0, cpuset.Unparse(res.NUMANodes), maxRemoteRatio*100.0)
+	}
+	return res, nil
 }
 
-func (re Result) JSON() string {
-	var b strings.Builder
-	enc := json.NewEncoder(&b)
-	enc.Encode(re)
-	return b.String()
+// CheckHugePagesResidency verifies that all the hugepages in use are on the NUMA nodes the resources are aligned to.
+func (R *Resources) CheckHugePagesResidency(res Result) (Result, error) {
+	if R.PageResidency == nil {
+		return res, fmt.Errorf("page residency data not available")
+	}
+	allowed := make(map[int]bool)
+	for _, node := range res.NUMANodes {
+		allowed[node] = true
+	}
+	nodes := []int{}
+	for node, pages := range R.PageResidency.Huge.Pages {
+		if pages == 0 {
+			continue
+		}
+		nodes = append(nodes, node)
+		if !allowed[node] {
+			res.Aligned = false
+			res.addReason(ReasonHugePagesRemote, "%d hugepages are on NUMA node %d, outside NUMA nodes %s", pages, node, cpuset.Unparse(res.NUMANodes))
+		}
+	}
+	sort.Ints(nodes)
+	res.HugePagesNUMANodes = nodes
+	return res, nil
 }
 
-func (R *Resources) CheckAlignment() Result {
-	numacellID := -1
-	for _, cpuNode := range R.CPUToNUMANode {
-		if numacellID == -1 {
-			numacellID = cpuNode
-		} else if numacellID != cpuNode {
-			return Result{
-				Aligned:    false,
-				NUMACellID: -1,
-			}
+// CheckIRQAffinity verifies that the interrupts of the devices run on CPUs of the NUMA node of their device,
+// and never on the exclusive or isolated CPUs of the container. The NUMA node is checked only against the
+// effective affinity: the allowed one is usually all the CPUs, while the kernel picks few of them.
+func (R *Resources) CheckIRQAffinity(res Result) (Result, error) {
+	if R.DeviceIRQs == nil {
+		return res, fmt.Errorf("IRQ data not available")
+	}
+	isolated := make(map[int]bool)
+	for _, cpuID := range R.IsolatedCPUs {
+		isolated[cpuID] = true
+	}
+	cpuNodes := make(map[int]int)
+	for node, cpuIDs := range R.CPUsPerNUMANode {
+		for _, cpuID := range cpuIDs {
+			cpuNodes[cpuID] = node
 		}
 	}
-	for _, devNode := range R.PCIDevsToNUMANode {
-		// TODO: explain -1
-		if devNode != -1 && numacellID != devNode {
-			return Result{
-				Aligned:    false,
-				NUMACellID: -1,
+
+	var pciDevs []string
+	for pciDev := range R.DeviceIRQs {
+		pciDevs = append(pciDevs, pciDev)
+	}
+	sort.Strings(pciDevs)
+	for _, pciDev := range pciDevs {
+		devNode, ok := R.PCIDevsToNUMANode[pciDev]
+		if !ok {
+			continue
+		}
+		desc := R.deviceInfo(pciDev).String()
+		for _, info := range R.DeviceIRQs[pciDev] {
+			var remote, local []int
+			for _, cpuID := range info.EffectiveAffinity {
+				if node, ok := cpuNodes[cpuID]; ok && devNode != pcidev.NUMANodeUnknown && node != devNode {
+					remote = append(remote, cpuID)
+				}
+			}
+			// the shared CPUs serve interrupts anyway
+			for _, cpuID := range info.CPUs() {
+				if _, ok := R.CPUToNUMANode[cpuID]; ok && (R.ExclusiveCPUs || isolated[cpuID]) {
+					local = append(local, cpuID)
+				}
+			}
+			if len(remote) > 0 {
+				res.Aligned = false
+				res.addReason(ReasonIRQRemote, "IRQ %d (%s) of %s on NUMA node %d runs on CPUs %s of other NUMA nodes", info.IRQ, info.Source, desc, devNode, cpuset.Unparse(remote))
+			}
+			if len(local) > 0 {
+				res.Aligned = false
+				res.addReason(ReasonIRQOnContainerCPUs, "IRQ %d (%s) of %s runs on the exclusive or isolated CPUs %s of the container", info.IRQ, info.Source, desc, cpuset.Unparse(local))
 			}
 		}
 	}
-	return Result{
-		Aligned:    true,
-		NUMACellID: numacellID,
+	return res, nil
+}
+
+// getDeviceIRQs reads the affinity of all the interrupts of a device
+func getDeviceIRQs(sysfs, procfs, pciDev string) ([]irqs.Info, error) {
+	irqList, err := irqs.ForPCIDevice(sysfs, pciDev)
+	if err != nil {
+		return nil, err
 	}
+	infos := []irqs.Info{}
+	for _, irq := range irqList {
+		info, err := irqs.Read(procfs, irq)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
 }
 
 func (R *Resources) MakeValidationScript() string {
@@ -104,6 +264,8 @@ func (R *Resources) MakeValidationScript() string {
 	buf.WriteString("#!/bin/sh -x\n")
 	buf.WriteString("echo \"checking which CPUs are allocated to the the container:\"\n")
 	buf.WriteString("grep Cpus_allowed_list /proc/self/status\n")
+	buf.WriteString("echo \"checking which NUMA nodes the container is allowed to allocate memory from:\"\n")
+	buf.WriteString("grep Mems_allowed_list /proc/self/status\n")
 	buf.WriteString("echo \"checking which SRIOV VFs are allocated to the container:\"\n")
 	buf.WriteString("env | grep PCIDEVICE_OPENSHIFT_IO\n")
 	buf.WriteString("echo \"checking the NUMA cell of the CPUs allocated to the container:\"\n")
@@ -138,24 +300,62 @@ func (R *Resources) String() string {
 		numacellID := R.PCIDevsToNUMANode[k]
 		b.WriteString(fmt.Sprintf("PCI %s=%02d\n", k, numacellID))
 	}
+	var memKeys []int
+	for mk := range R.MemoryNUMANodes {
+		memKeys = append(memKeys, mk)
+	}
+	sort.Ints(memKeys)
+	for _, k := range memKeys {
+		allowed := R.MemoryNUMANodes[k]
+		b.WriteString(fmt.Sprintf("MEM node#%02d=%v\n", k, allowed))
+	}
 	return b.String()
 }
 
 func GetAllowedCPUList(statusFile string) ([]int, error) {
-	var cpuIDs []int
+	return getStatusList(statusFile, "Cpus_allowed_list")
+}
+
+// GetIsolatedCPUList reads the CPUs isolated from the kernel scheduler, like /sys/devices/system/cpu/isolated.
+// Empty if the kernel does not report them.
+func GetIsolatedCPUList(isolatedFile string) ([]int, error) {
+	content, err := ioutil.ReadFile(isolatedFile)
+	if os.IsNotExist(err) {
+		return []int{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return splitCPUList(strings.TrimSpace(string(content)))
+}
+
+func GetAllowedMemNodeList(statusFile string) ([]int, error) {
+	return getStatusList(statusFile, "Mems_allowed_list")
+}
+
+func getStatusList(statusFile, key string) ([]int, error) {
+	var ids []int
 	var err error
 	content, err := ioutil.ReadFile(statusFile)
 	if err != nil {
-		return cpuIDs, err
+		return ids, err
 	}
 	lines := strings.Split(string(content), "\n")
 	for _, line := range lines {
-		if strings.HasPrefix(line, "Cpus_allowed_list") {
+		if strings.HasPrefix(line, key) {
 			pair := strings.SplitN(line, ":", 2)
 			return splitCPUList(strings.TrimSpace(pair[1]))
 		}
 	}
-	return cpuIDs, fmt.Errorf("malformed status file: %s", statusFile)
+	return ids, fmt.Errorf("malformed status file: %s", statusFile)
+}
+
+func GetMemNodeMap(memNodeIDs []int) map[int]bool {
+	memNodes := make(map[int]bool)
+	for _, memNodeID := range memNodeIDs {
+		memNodes[memNodeID] = true
+	}
+	return memNodes
 }
 
 func GetCPUToNUMANodeMap(sysNodeDir string, cpuIDs []int) (map[int]int, error) {
@@ -177,24 +377,6 @@ func GetCPUToNUMANodeMap(sysNodeDir string, cpuIDs []int) (map[int]int, error) {
 	return CPUMap, nil
 }
 
-func GetPCIDevicesFromEnv(environ []string) []string {
-	var pciDevs []string
-	for _, envVar := range environ {
-		if !strings.HasPrefix(envVar, "PCIDEVICE_") {
-			continue
-		}
-		if strings.Contains(envVar, "_INFO=") {
-			// sriov device plugin found in OpenShift >= 4.13 (TODO: narro down version)
-			continue
-		}
-		pair := strings.SplitN(envVar, "=", 2)
-		for _, pciDev := range strings.Split(pair[1], ",") {
-			pciDevs = append(pciDevs, pciDev)
-		}
-	}
-	return pciDevs
-}
-
 func GetPCIDeviceToNumaNodeMap(sysBusPCIDir string, pciDevs []string) (map[string]int, error) {
 	if len(pciDevs) == 0 {
 		log.Printf("PCI: devices: none found - SKIP")
@@ -262,10 +444,14 @@ func GetCPUNUMANodes(cpusPerNUMA map[int][]int) map[int]int {
 	return CPUToNUMANode
 }
 
-func NewResources(pids []string) (*Resources, error) {
+// NewResources gathers the resources of the processes and of the devices described by the options.
+func NewResources(opts Options) (*Resources, error) {
 	var err error
 
-	cpuRes, err := cpus.NewCPUs("/sys")
+	sysfs := opts.sysFSRoot()
+	procfs := opts.procFSRoot()
+
+	cpuRes, err := cpus.NewCPUs(sysfs)
 	if err != nil {
 		return nil, err
 	}
@@ -273,56 +459,169 @@ func NewResources(pids []string) (*Resources, error) {
 		log.Printf("CPU: NUMA cell %02d: %s\n", idx, cpuset.Unparse(cpuRes.NUMANodeCPUs[idx]))
 	}
 
-	pciDevs := GetPCIDevicesFromEnv(os.Environ())
-	pciInfos, err := pcidev.NewPCIDevices("/sys")
+	dists, err := distances.NewDistancesFromSysfs(sysfs)
+	if err != nil {
+		// not fatal: only needed to pick the closest nodes when resources span more of them
+		log.Printf("NUMA: cannot read distances: %v", err)
+		dists = nil
+	}
+
+	pciInfos, err := pcidev.NewPCIDevices(sysfs)
 	if err != nil {
 		return nil, err
 	}
-	for _, pciDev := range pciDevs {
-		if pciInfo, found := pciInfos.FindByAddress(pciDev); found {
-			log.Printf("PCI: %v", pciInfo)
+	devInfos := ResolveRDMADevices(sysfs, pciInfos, GetDevicesFromEnv(opts.Environ))
+	if opts.AnnotationsFile != "" {
+		annDevInfos, err := GetDevicesFromAnnotationsFile(opts.AnnotationsFile)
+		if err != nil {
+			return nil, err
+		}
+		devInfos = MergeDevices(devInfos, annDevInfos)
+	}
+	netDevInfos, skippedNetdevs, err := GetDevicesFromNetdevs(sysfs, pciInfos, opts.Netdevs)
+	if err != nil {
+		return nil, err
+	}
+	devInfos = MergeDevices(devInfos, netDevInfos)
+	rdmaDevInfos, err := GetDevicesFromRDMADevs(sysfs, pciInfos, opts.RDMADevs)
+	if err != nil {
+		return nil, err
+	}
+	devInfos = MergeDevices(devInfos, rdmaDevInfos)
+	blockDevInfos, skippedBlockDevs, err := GetDevicesFromBlockDevs(sysfs, opts.devRoot(), opts.BlockDevs)
+	if err != nil {
+		return nil, err
+	}
+	devInfos = MergeDevices(devInfos, blockDevInfos)
+	if opts.MappingFile != "" {
+		mappingConf, err := LoadMappingConfig(opts.MappingFile)
+		if err != nil {
+			return nil, err
+		}
+		mappedDevInfos, err := GetDevicesFromMappings(mappingConf, sysfs, opts.devRoot(), opts.Environ, pciInfos)
+		if err != nil {
+			return nil, err
+		}
+		devInfos = MergeDevices(devInfos, mappedDevInfos)
+	}
+	devInfos = FilterDevices(pciInfos, devInfos, opts.DeviceFilter)
+	var pciDevs []string
+	pciDevsInfo := make(map[string]DeviceInfo)
+	for _, devInfo := range devInfos {
+		pciDevs = append(pciDevs, devInfo.Address)
+		pciDevsInfo[devInfo.Address] = devInfo
+		if pciInfo, found := pciInfos.FindByAddress(devInfo.Address); found {
+			log.Printf("PCI: %s: %v", devInfo.Resource, pciInfo)
 		}
 	}
 
 	var pidStrings []string
-	if len(pids) > 1 {
-		pidStrings = append(pidStrings, pids...)
+	if len(opts.Pids) > 0 {
+		pidStrings = append(pidStrings, opts.Pids...)
 	} else {
 		pidStrings = append(pidStrings, "self")
 	}
 
 	var refCpuIDs []int
-	refCpuIDs, err = GetAllowedCPUList(filepath.Join("/proc", pidStrings[0], "status"))
+	refCpuIDs, err = GetAllowedCPUList(filepath.Join(procfs, pidStrings[0], "status"))
 	if err != nil {
 		return nil, err
 	}
+	sort.Ints(refCpuIDs)
 	log.Printf("CPU: allowed for %q: %v", pidStrings[0], refCpuIDs)
 
-	for _, pidString := range pidStrings[1:] {
-		cpuIDs, err := GetAllowedCPUList(filepath.Join("/proc", pidString, "status"))
+	var threads []ThreadAffinity
+	for _, pidString := range pidStrings {
+		pidThreads, err := GetThreadsAffinity(procfs, pidString)
 		if err != nil {
 			return nil, err
 		}
-		log.Printf("CPU: allowed for %q: %v", pidString, cpuIDs)
+		for _, thread := range pidThreads {
+			log.Printf("CPU: allowed for %q thread %d (%s): %v", pidString, thread.Tid, thread.Name, thread.CPUs)
+		}
+		threads = append(threads, pidThreads...)
+	}
+
+	mismatches := FindAffinityMismatches(refCpuIDs, threads)
+	for _, mm := range mismatches {
+		log.Printf("CPU: allowed set differs pid %d thread %d (%v) expected (%v)", mm.Pid, mm.Tid, mm.CPUs, mm.ExpectedCPUs)
+	}
+
+	// the alignment must consider all the CPUs any thread can run on
+	cpuIDs := ThreadsCPUs(append(threads, ThreadAffinity{CPUs: refCpuIDs}))
+
+	memNodeIDs, err := GetAllowedMemNodeList(filepath.Join(procfs, pidStrings[0], "status"))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("MEM: allowed for %q: %v", pidStrings[0], memNodeIDs)
 
-		if !reflect.DeepEqual(refCpuIDs, cpuIDs) {
-			log.Fatalf("CPU: allowed set differs pid %q (%v) pid %q (%v)", pidStrings[0], refCpuIDs, pidString, cpuIDs)
+	pageResidency := numamaps.NewInfo()
+	for _, pidString := range pidStrings {
+		info, err := numamaps.FromPID(procfs, pidString)
+		if err != nil {
+			// not fatal: requires ptrace-like access to other processes, and only needed by optional checks
+			log.Printf("MEM: cannot read page residency for %q: %v", pidString, err)
+			pageResidency = nil
+			break
 		}
+		log.Printf("MEM: resident for %q: %v", pidString, info.ResidentBytes())
+		pageResidency.Merge(info)
 	}
 
-	CPUToNUMANode, err := GetCPUToNUMANodeMap(SysDevicesSystemNodeDir, refCpuIDs)
+	CPUToNUMANode, err := GetCPUToNUMANodeMap(filepath.Join(sysfs, "devices", "system", "node"), cpuIDs)
 	if err != nil {
 		return nil, err
 	}
 
-	NUMAPerDev, err := GetPCIDeviceToNumaNodeMap(SysBusPCIDevicesDir, pciDevs)
+	NUMAPerDev, err := GetPCIDeviceToNumaNodeMap(filepath.Join(sysfs, "bus", "pci", "devices"), pciDevs)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Resources{
-		CPUToNUMANode:     CPUToNUMANode,
-		PCIDevsToNUMANode: NUMAPerDev,
-	}, nil
+	deviceIRQs := make(map[string][]irqs.Info)
+	for _, pciDev := range pciDevs {
+		infos, err := getDeviceIRQs(sysfs, procfs, pciDev)
+		if err != nil {
+			// not fatal: only needed by optional checks, which skip this device
+			log.Printf("IRQ: cannot read interrupts of %s: %v", pciDev, err)
+			continue
+		}
+		for _, info := range infos {
+			log.Printf("IRQ: %s: %d (%s) CPUs %s", pciDev, info.IRQ, info.Source, cpuset.Unparse(info.CPUs()))
+		}
+		deviceIRQs[pciDev] = infos
+	}
+
+	isolatedCPUs, err := GetIsolatedCPUList(filepath.Join(sysfs, "devices", "system", "cpu", "isolated"))
+	if err != nil {
+		// not fatal: only needed by optional checks
+		log.Printf("CPU: cannot read the isolated CPUs: %v", err)
+	}
+	log.Printf("CPU: isolated: %v", isolatedCPUs)
+
+	cpusPerNode := make(map[int][]int)
+	for node, cpuIDs := range cpuRes.NUMANodeCPUs {
+		cpusPerNode[node] = cpuIDs
+	}
+
+	R := &Resources{
+		CPUToNUMANode:      CPUToNUMANode,
+		PCIDevsToNUMANode:  NUMAPerDev,
+		PCIDevsInfo:        pciDevsInfo,
+		SkippedNetdevs:     skippedNetdevs,
+		SkippedBlockDevs:   skippedBlockDevs,
+		MemoryNUMANodes:    GetMemNodeMap(memNodeIDs),
+		PageResidency:      pageResidency,
+		CPUsPerNUMANode:    cpuRes.NUMANodeCPUs,
+		Distances:          dists,
+		Threads:            threads,
+		AffinityMismatches: mismatches,
+		DeviceIRQs:         deviceIRQs,
+		IsolatedCPUs:       isolatedCPUs,
+		ExclusiveCPUs:      opts.ExclusiveCPUs,
+	}
+	R.InferDeviceNUMANodes(pciInfos, cpusPerNode)
+	return R, nil
 
 }
@@ -0,0 +1,186 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numalign
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	fakesysfs "github.com/ffromani/numalign/pkg/topologyinfo/sysfs/fake"
+)
+
+// setupFakeNode creates a fake sysfs and procfs: 2 NUMA nodes with 2 CPUs each, a NIC on devNUMANode and
+// a process allowed to run on the CPUs and the memory of node 1. Returns the base path and the teardown function.
+func setupFakeNode(t *testing.T, devNUMANode int) (string, func()) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+	t.Logf("sysfs at %q", fs.Base())
+
+	devSys := fs.AddTree("sys", "devices", "system")
+	devNode := devSys.Add("node", map[string]string{
+		"online": "0-1\n",
+	})
+	devNode.Add("node0", map[string]string{
+		"cpulist":  "0-1\n",
+		"distance": "10 20\n",
+	})
+	devNode.Add("node1", map[string]string{
+		"cpulist":  "2-3\n",
+		"distance": "20 10\n",
+	})
+	devCpu := devSys.Add("cpu", map[string]string{
+		"present": "0-3\n",
+		"online":  "0-3\n",
+	})
+	for cpuID := 0; cpuID < 4; cpuID++ {
+		devCpu.Add(fmt.Sprintf("cpu%d", cpuID), nil).Add("topology", map[string]string{
+			"thread_siblings_list": fmt.Sprintf("%d\n", cpuID),
+			"core_siblings_list":   "0-3\n",
+			"physical_package_id":  "0\n",
+		})
+	}
+
+	sysDevs := fs.AddTree("sys", "bus", "pci", "devices")
+	sysDevs.Add("0000:3b:02.1", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node": fmt.Sprintf("%d", devNUMANode),
+		"class":     "0x020000",
+		"vendor":    "0x8086",
+		"device":    "0x154c",
+	}))
+
+	status := "Name:\tapp\nTgid:\t42\nPid:\t42\nCpus_allowed_list:\t2-3\nMems_allowed_list:\t1\n"
+	// procfs can be faked the same way
+	fs.AddTree("proc").Add("self", map[string]string{
+		"status": status,
+	}).Add("task", nil).Add("42", map[string]string{
+		"status": status,
+	})
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	return fs.Base(), func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}
+}
+
+func TestNewResourcesFakeSysfs(t *testing.T) {
+	base, teardown := setupFakeNode(t, 1)
+	defer teardown()
+
+	R, err := NewResources(Options{
+		SysFSRoot:  filepath.Join(base, "sys"),
+		ProcFSRoot: filepath.Join(base, "proc"),
+		Environ: []string{
+			"HOME=/root",
+			"PCIDEVICE_OPENSHIFT_IO_INTELNICS=0000:3b:02.1",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !cmp.Equal(R.CPUToNUMANode, map[int]int{2: 1, 3: 1}) {
+		t.Errorf("unexpected CPUs: %v", R.CPUToNUMANode)
+	}
+	if !cmp.Equal(R.PCIDevsToNUMANode, map[string]int{"0000:3b:02.1": 1}) {
+		t.Errorf("unexpected devices: %v", R.PCIDevsToNUMANode)
+	}
+	if !cmp.Equal(R.MemoryNUMANodes, map[int]bool{1: true}) {
+		t.Errorf("unexpected memory nodes: %v", R.MemoryNUMANodes)
+	}
+	if R.PageResidency != nil {
+		t.Errorf("unexpected page residency: %v", R.PageResidency)
+	}
+
+	res := R.CheckAlignment()
+	if !res.Aligned || res.NUMACellID != 1 {
+		t.Errorf("unexpected result: %s", res.JSON())
+	}
+	for _, ri := range res.Resources {
+		if ri.Kind == ResourceDevice && ri.Resource != "openshift.io/intelnics" {
+			t.Errorf("unexpected device resource: %v", ri)
+		}
+	}
+}
+
+func TestNewResourcesDeviceIRQs(t *testing.T) {
+	base, teardown := setupFakeNode(t, 1)
+	defer teardown()
+
+	// one device with a readable MSI vector, one with a broken msi_irqs directory
+	sysDevs := filepath.Join(base, "sys", "bus", "pci", "devices")
+	files := map[string]string{
+		filepath.Join(sysDevs, "0000:3b:02.1", "msi_irqs", "130"):            "",
+		filepath.Join(base, "proc", "irq", "130", "smp_affinity_list"):       "0-1\n",
+		filepath.Join(base, "proc", "irq", "130", "effective_affinity_list"): "0\n",
+		filepath.Join(sysDevs, "0000:3b:02.2", "numa_node"):                  "1\n",
+		filepath.Join(sysDevs, "0000:3b:02.2", "class"):                      "0x020000\n",
+		filepath.Join(sysDevs, "0000:3b:02.2", "vendor"):                     "0x8086\n",
+		filepath.Join(sysDevs, "0000:3b:02.2", "device"):                     "0x154c\n",
+		filepath.Join(sysDevs, "0000:3b:02.2", "msi_irqs", "bogus"):          "",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("error creating %q: %v", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("error writing %q: %v", path, err)
+		}
+	}
+
+	R, err := NewResources(Options{
+		SysFSRoot:  filepath.Join(base, "sys"),
+		ProcFSRoot: filepath.Join(base, "proc"),
+		Environ: []string{
+			"PCIDEVICE_OPENSHIFT_IO_INTELNICS=0000:3b:02.1,0000:3b:02.2",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(R.DeviceIRQs) != 1 || len(R.DeviceIRQs["0000:3b:02.1"]) != 1 {
+		t.Fatalf("unexpected device IRQs: %v", R.DeviceIRQs)
+	}
+	res, err := R.CheckIRQAffinity(R.CheckAlignment())
+	if err != nil {
+		t.Fatalf("unexpected IRQ check error: %v", err)
+	}
+	if res.Aligned {
+		t.Errorf("IRQ of 0000:3b:02.1 on the CPUs of NUMA node 0 not reported: %s", res.JSON())
+	}
+}
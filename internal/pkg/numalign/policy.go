@@ -0,0 +1,187 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numalign
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
+)
+
+// Policy mirrors the kubelet Topology Manager policies
+// https://kubernetes.io/docs/tasks/administer-cluster/topology-manager/
+type Policy string
+
+const (
+	// PolicySingleNUMANode requires all the resources on the same NUMA node
+	PolicySingleNUMANode Policy = "single-numa-node"
+	// PolicyRestricted requires the resources to span the narrowest possible set of NUMA nodes
+	PolicyRestricted Policy = "restricted"
+	// PolicyBestEffort reports the NUMA nodes used, but never fails
+	PolicyBestEffort Policy = "best-effort"
+)
+
+func ParsePolicy(s string) (Policy, error) {
+	switch Policy(s) {
+	case PolicySingleNUMANode, PolicyRestricted, PolicyBestEffort:
+		return Policy(s), nil
+	}
+	return Policy(""), fmt.Errorf("unsupported policy: %q", s)
+}
+
+func (R *Resources) CheckAlignmentWithPolicy(policy Policy) Result {
+	used := R.UsedNUMANodes()
+	narrowest := R.NarrowestNUMANodes()
+	res := Result{
+		Version:    ResultVersion,
+		NUMACellID: -1,
+		NUMANodes:  used,
+		Narrowest:  len(used) == len(narrowest) && R.nodesDistance(used) == R.nodesDistance(narrowest),
+		Policy:     string(policy),
+
+		AffinityMismatches: R.AffinityMismatches,
+	}
+	if len(used) == 1 {
+		res.NUMACellID = used[0]
+	}
+
+	R.describe(&res)
+
+	switch policy {
+	case PolicySingleNUMANode:
+		res.Aligned = len(used) == 1
+	case PolicyRestricted:
+		res.Aligned = res.Narrowest
+	case PolicyBestEffort:
+		res.Aligned = true
+	}
+	return res
+}
+
+// UsedNUMANodes returns the sorted set of NUMA nodes the resources are on.
+// Devices with unknown NUMA node are ignored.
+func (R *Resources) UsedNUMANodes() []int {
+	nodes := make(map[int]bool)
+	for _, cpuNode := range R.CPUToNUMANode {
+		nodes[cpuNode] = true
+	}
+	for _, devNode := range R.PCIDevsToNUMANode {
+		if devNode == pcidev.NUMANodeUnknown {
+			continue
+		}
+		nodes[devNode] = true
+	}
+	for memNode := range R.MemoryNUMANodes {
+		nodes[memNode] = true
+	}
+	return sortedNodes(nodes)
+}
+
+// NarrowestNUMANodes returns the smallest set of NUMA nodes which can hold all the CPUs and
+// which includes all the devices. If more sets of the same size are possible, the one with
+// the smallest distance between the nodes is preferred.
+func (R *Resources) NarrowestNUMANodes() []int {
+	required := make(map[int]bool)
+	for _, devNode := range R.PCIDevsToNUMANode {
+		if devNode == pcidev.NUMANodeUnknown {
+			continue
+		}
+		required[devNode] = true
+	}
+
+	allNodes := make(map[int]bool)
+	for node := range R.CPUsPerNUMANode {
+		allNodes[node] = true
+	}
+	candidates := sortedNodes(allNodes)
+
+	for size := 1; size <= len(candidates); size++ {
+		var best []int
+		bestDistance := 0
+		forEachCombination(candidates, size, func(nodes []int) {
+			if !R.canHold(nodes, required) {
+				return
+			}
+			dist := R.nodesDistance(nodes)
+			if best == nil || dist < bestDistance {
+				best = append([]int{}, nodes...)
+				bestDistance = dist
+			}
+		})
+		if best != nil {
+			return best
+		}
+	}
+	return candidates
+}
+
+func (R *Resources) canHold(nodes []int, required map[int]bool) bool {
+	found := 0
+	capacity := 0
+	for _, node := range nodes {
+		if required[node] {
+			found++
+		}
+		capacity += len(R.CPUsPerNUMANode[node])
+	}
+	return found == len(required) && capacity >= len(R.CPUToNUMANode)
+}
+
+// nodesDistance returns the sum of the distances between each pair of the given nodes
+func (R *Resources) nodesDistance(nodes []int) int {
+	if R.Distances == nil {
+		return 0
+	}
+	total := 0
+	for i := 0; i < len(nodes); i++ {
+		for j := i + 1; j < len(nodes); j++ {
+			dist, err := R.Distances.BetweenNodes(nodes[i], nodes[j])
+			if err != nil {
+				continue
+			}
+			total += dist
+		}
+	}
+	return total
+}
+
+func forEachCombination(items []int, size int, fn func([]int)) {
+	comb := make([]int, 0, size)
+	var walk func(start int)
+	walk = func(start int) {
+		if len(comb) == size {
+			fn(comb)
+			return
+		}
+		for i := start; i < len(items); i++ {
+			comb = append(comb, items[i])
+			walk(i + 1)
+			comb = comb[:len(comb)-1]
+		}
+	}
+	walk(0)
+}
+
+func sortedNodes(nodes map[int]bool) []int {
+	ret := []int{}
+	for node := range nodes {
+		ret = append(ret, node)
+	}
+	sort.Ints(ret)
+	return ret
+}
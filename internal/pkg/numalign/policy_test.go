@@ -0,0 +1,183 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numalign
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ffromani/numalign/pkg/topologyinfo/cpus"
+	"github.com/ffromani/numalign/pkg/topologyinfo/numa/distances"
+)
+
+// 4 nodes, 4 CPUs each. Node 0 is close to 1, node 2 is close to 3.
+func newTestResources(t *testing.T, cpuIDs []int, devNodes map[string]int, memNodes []int) *Resources {
+	cpusPerNode := map[int]cpus.CPUIdList{
+		0: {0, 1, 2, 3},
+		1: {4, 5, 6, 7},
+		2: {8, 9, 10, 11},
+		3: {12, 13, 14, 15},
+	}
+	dists, err := distances.NewDistancesFromData(map[string]string{
+		"0": "10 12 20 20",
+		"1": "12 10 20 20",
+		"2": "20 20 10 12",
+		"3": "20 20 12 10",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cpuMap := make(map[int]int)
+	for node, cpuList := range cpusPerNode {
+		for _, cpuID := range cpuList {
+			cpuMap[cpuID] = node
+		}
+	}
+	R := Resources{
+		CPUToNUMANode:     make(map[int]int),
+		PCIDevsToNUMANode: devNodes,
+		MemoryNUMANodes:   GetMemNodeMap(memNodes),
+		CPUsPerNUMANode:   cpusPerNode,
+		Distances:         dists,
+	}
+	for _, cpuID := range cpuIDs {
+		R.CPUToNUMANode[cpuID] = cpuMap[cpuID]
+	}
+	return &R
+}
+
+func TestCheckAlignmentWithPolicy(t *testing.T) {
+	type tcase struct {
+		name      string
+		cpuIDs    []int
+		devNodes  map[string]int
+		memNodes  []int
+		policy    Policy
+		aligned   bool
+		numaNodes []int
+		narrowest bool
+	}
+
+	for _, tc := range []tcase{
+		{
+			name:      "single node",
+			cpuIDs:    []int{4, 5},
+			devNodes:  map[string]int{"0000:3b:02.1": 1},
+			memNodes:  []int{1},
+			policy:    PolicySingleNUMANode,
+			aligned:   true,
+			numaNodes: []int{1},
+			narrowest: true,
+		},
+		{
+			name:      "single node, unknown device node",
+			cpuIDs:    []int{4, 5},
+			devNodes:  map[string]int{"0000:3b:02.1": -1},
+			memNodes:  []int{1},
+			policy:    PolicySingleNUMANode,
+			aligned:   true,
+			numaNodes: []int{1},
+			narrowest: true,
+		},
+		{
+			name:      "memory spans more nodes",
+			cpuIDs:    []int{4, 5},
+			devNodes:  map[string]int{"0000:3b:02.1": 1},
+			memNodes:  []int{0, 1},
+			policy:    PolicySingleNUMANode,
+			aligned:   false,
+			numaNodes: []int{0, 1},
+			narrowest: false,
+		},
+		{
+			name:      "split CPUs, restricted, closest nodes",
+			cpuIDs:    []int{0, 1, 2, 3, 4, 5},
+			devNodes:  map[string]int{},
+			memNodes:  []int{0, 1},
+			policy:    PolicyRestricted,
+			aligned:   true,
+			numaNodes: []int{0, 1},
+			narrowest: true,
+		},
+		{
+			name:      "split CPUs, single-numa-node",
+			cpuIDs:    []int{0, 1, 2, 3, 4, 5},
+			devNodes:  map[string]int{},
+			memNodes:  []int{0, 1},
+			policy:    PolicySingleNUMANode,
+			aligned:   false,
+			numaNodes: []int{0, 1},
+			narrowest: true,
+		},
+		{
+			name:      "split CPUs, restricted, far nodes",
+			cpuIDs:    []int{0, 1, 2, 3, 8, 9},
+			devNodes:  map[string]int{},
+			memNodes:  []int{0, 2},
+			policy:    PolicyRestricted,
+			aligned:   false,
+			numaNodes: []int{0, 2},
+			narrowest: false,
+		},
+		{
+			name:      "split CPUs, restricted, far nodes required by devices",
+			cpuIDs:    []int{0, 1, 8, 9},
+			devNodes:  map[string]int{"0000:3b:02.1": 0, "0000:d8:02.1": 2},
+			memNodes:  []int{0, 2},
+			policy:    PolicyRestricted,
+			aligned:   true,
+			numaNodes: []int{0, 2},
+			narrowest: true,
+		},
+		{
+			name:      "split CPUs, best-effort",
+			cpuIDs:    []int{0, 4},
+			devNodes:  map[string]int{},
+			memNodes:  []int{0, 1},
+			policy:    PolicyBestEffort,
+			aligned:   true,
+			numaNodes: []int{0, 1},
+			narrowest: false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			R := newTestResources(t, tc.cpuIDs, tc.devNodes, tc.memNodes)
+			res := R.CheckAlignmentWithPolicy(tc.policy)
+			if res.Aligned != tc.aligned {
+				t.Errorf("aligned mismatch: got %v expected %v", res.Aligned, tc.aligned)
+			}
+			if !cmp.Equal(res.NUMANodes, tc.numaNodes) {
+				t.Errorf("NUMA nodes mismatch: got %v expected %v", res.NUMANodes, tc.numaNodes)
+			}
+			if res.Narrowest != tc.narrowest {
+				t.Errorf("narrowest mismatch: got %v expected %v (narrowest=%v)", res.Narrowest, tc.narrowest, R.NarrowestNUMANodes())
+			}
+		})
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, name := range []string{"single-numa-node", "restricted", "best-effort"} {
+		if _, err := ParsePolicy(name); err != nil {
+			t.Errorf("unexpected error parsing %q: %v", name, err)
+		}
+	}
+	if _, err := ParsePolicy("none"); err == nil {
+		t.Errorf("unsupported policy parsed without errors")
+	}
+}
@@ -0,0 +1,287 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numalign
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ffromani/cpuset"
+
+	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
+)
+
+// ResultVersion is the version of the Result JSON format. Bump on incompatible changes.
+const ResultVersion = 1
+
+type ResourceKind string
+
+const (
+	ResourceCPU    ResourceKind = "cpu"
+	ResourceDevice ResourceKind = "device"
+	ResourceMemory ResourceKind = "memory"
+)
+
+// ResourceInfo reports the NUMA node of a single resource
+type ResourceInfo struct {
+	Kind ResourceKind `json:"kind"`
+	// ID is the CPU id, the PCI address or the memory NUMA node id, depending on Kind
+	ID         string `json:"id"`
+	NUMACellID int    `json:"numacellid"`
+	// Resource is the extended resource name the device was allocated for, if known
+	Resource string `json:"resource,omitempty"`
+	// ReportedNUMACellID is the NUMA node reported by the kernel, if NUMACellID was inferred. Devices only.
+	ReportedNUMACellID *int `json:"reportednumacellid,omitempty"`
+	// NUMASource tells where NUMACellID was inferred from, if it was. Devices only.
+	NUMASource string `json:"numasource,omitempty"`
+	// NUMAConfidence tells how much NUMACellID can be trusted, if it was inferred. Devices only.
+	NUMAConfidence string `json:"numaconfidence,omitempty"`
+}
+
+// ReasonCode is a machine-readable explanation of a finding of the checks
+type ReasonCode string
+
+const (
+	// ReasonCPUSplit means the CPUs are on more NUMA nodes
+	ReasonCPUSplit ReasonCode = "CPU_SPLIT"
+	// ReasonDeviceRemote means a device is on a NUMA node without any of the CPUs
+	ReasonDeviceRemote ReasonCode = "DEVICE_REMOTE"
+	// ReasonDeviceNUMAUnknown means the firmware reports no NUMA node for a device, and none could be inferred
+	ReasonDeviceNUMAUnknown ReasonCode = "DEVICE_NUMA_UNKNOWN"
+	// ReasonDeviceNUMAInferred means the firmware reports no NUMA node for a device, and the likely one was inferred
+	ReasonDeviceNUMAInferred ReasonCode = "DEVICE_NUMA_INFERRED"
+	// ReasonMemoryRemote means memory can be allocated from a NUMA node without any of the CPUs
+	ReasonMemoryRemote ReasonCode = "MEMORY_REMOTE"
+	// ReasonNotNarrowest means the resources span more, or farther, NUMA nodes than needed
+	ReasonNotNarrowest ReasonCode = "NOT_NARROWEST"
+	// ReasonMemoryResidentRemote means too much resident memory is outside the NUMA nodes in use
+	ReasonMemoryResidentRemote ReasonCode = "MEMORY_RESIDENT_REMOTE"
+	// ReasonHugePagesRemote means hugepages in use are outside the NUMA nodes in use
+	ReasonHugePagesRemote ReasonCode = "HUGEPAGES_REMOTE"
+	// ReasonThreadAffinityMismatch means a thread can run on CPUs different from the process ones
+	ReasonThreadAffinityMismatch ReasonCode = "THREAD_AFFINITY_MISMATCH"
+	// ReasonIRQRemote means a device interrupt runs on CPUs of NUMA nodes other than the device one
+	ReasonIRQRemote ReasonCode = "IRQ_REMOTE"
+	// ReasonIRQOnContainerCPUs means a device interrupt runs on the exclusive or isolated CPUs of the container
+	ReasonIRQOnContainerCPUs ReasonCode = "IRQ_ON_CONTAINER_CPUS"
+	// ReasonNetdevSkipped means a network interface was not checked, because not backed by a PCI device
+	ReasonNetdevSkipped ReasonCode = "NETDEV_SKIPPED"
+	// ReasonBlockDevSkipped means a block device was not checked, because not backed by a PCI device
+	ReasonBlockDevSkipped ReasonCode = "BLOCKDEV_SKIPPED"
+)
+
+type Reason struct {
+	Code    ReasonCode `json:"code"`
+	Message string     `json:"message"`
+}
+
+type Result struct {
+	Version int  `json:"version"`
+	Aligned bool `json:"aligned"`
+	// NUMACellID is the NUMA node all the resources are on, -1 if they span more nodes
+	NUMACellID int `json:"numacellid"`
+	// NUMANodes are all the NUMA nodes the resources are on
+	NUMANodes []int `json:"numanodes"`
+	// Narrowest is true if NUMANodes is the smallest (and closest) set of nodes possible
+	Narrowest bool   `json:"narrowest"`
+	Policy    string `json:"policy"`
+	// Resources reports the NUMA node of each resource checked
+	Resources []ResourceInfo `json:"resources"`
+	// Reasons explains the findings of the checks, even if they don't make the check fail
+	Reasons []Reason `json:"reasons,omitempty"`
+	// RemoteMemory is the share (0..1) of resident memory not on NUMANodes. nil if not checked.
+	RemoteMemory *float64 `json:"remotememory,omitempty"`
+	// HugePagesNUMANodes are the NUMA nodes on which the hugepages in use are. nil if not checked.
+	HugePagesNUMANodes []int `json:"hugepagesnumanodes,omitempty"`
+	// AffinityMismatches are the threads whose affinity differs from the one of the first process
+	AffinityMismatches []AffinityMismatch `json:"affinitymismatches,omitempty"`
+}
+
+func (re *Result) addReason(code ReasonCode, format string, args ...interface{}) {
+	re.Reasons = append(re.Reasons, Reason{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// HasReason tells if the result includes at least a finding with the given code
+func (re Result) HasReason(code ReasonCode) bool {
+	for _, reason := range re.Reasons {
+		if reason.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (re Result) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "STATUS ALIGNED=%v\n", re.Aligned)
+	fmt.Fprintf(&b, "NUMA NODE=%v\n", re.NUMACellID)
+	fmt.Fprintf(&b, "NUMA NODES=%s NARROWEST=%v\n", cpuset.Unparse(re.NUMANodes), re.Narrowest)
+	if re.RemoteMemory != nil {
+		fmt.Fprintf(&b, "REMOTE MEMORY=%.2f%%\n", *re.RemoteMemory*100.0)
+	}
+	if re.HugePagesNUMANodes != nil {
+		fmt.Fprintf(&b, "HUGEPAGES NUMA NODES=%s\n", cpuset.Unparse(re.HugePagesNUMANodes))
+	}
+	for _, mm := range re.AffinityMismatches {
+		fmt.Fprintf(&b, "THREAD pid=%d tid=%d name=%q CPUS=%s EXPECTED=%s\n", mm.Pid, mm.Tid, mm.Name, cpuset.Unparse(mm.CPUs), cpuset.Unparse(mm.ExpectedCPUs))
+	}
+	return b.String()
+}
+
+// Explain returns a human-friendly description of the result and of its reasons
+func (re Result) Explain() string {
+	var b strings.Builder
+	verdict := "aligned"
+	if !re.Aligned {
+		verdict = "NOT aligned"
+	}
+	fmt.Fprintf(&b, "resources are %s according to the %q policy\n", verdict, re.Policy)
+	fmt.Fprintf(&b, "resources are on NUMA nodes %s", cpuset.Unparse(re.NUMANodes))
+	if re.Narrowest {
+		fmt.Fprintf(&b, ", which is the narrowest set possible\n")
+	} else {
+		fmt.Fprintf(&b, ", which is NOT the narrowest set possible\n")
+	}
+	for _, kind := range []ResourceKind{ResourceCPU, ResourceDevice, ResourceMemory} {
+		var items []string
+		for _, ri := range re.Resources {
+			if ri.Kind != kind {
+				continue
+			}
+			item := fmt.Sprintf("%s@%d", ri.ID, ri.NUMACellID)
+			if ri.ReportedNUMACellID != nil && ri.NUMASource != "" {
+				item += fmt.Sprintf("(inferred,%s)", ri.NUMAConfidence)
+			}
+			items = append(items, item)
+		}
+		if len(items) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%-6s (id@node): %s\n", kind, strings.Join(items, " "))
+	}
+	if len(re.Reasons) == 0 {
+		fmt.Fprintf(&b, "no issues found\n")
+	}
+	for _, reason := range re.Reasons {
+		fmt.Fprintf(&b, "- %s: %s\n", reason.Code, reason.Message)
+	}
+	return b.String()
+}
+
+func (re Result) JSON() string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.Encode(re)
+	return b.String()
+}
+
+func (R *Resources) deviceInfo(devAddr string) DeviceInfo {
+	if devInfo, ok := R.PCIDevsInfo[devAddr]; ok {
+		return devInfo
+	}
+	return DeviceInfo{Address: devAddr}
+}
+
+// describe fills the per-resource data and the reasons common to all the policies
+func (R *Resources) describe(res *Result) {
+	cpuNodes := make(map[int]bool)
+	var cpuIDs []int
+	for cpuID, cpuNode := range R.CPUToNUMANode {
+		cpuIDs = append(cpuIDs, cpuID)
+		cpuNodes[cpuNode] = true
+	}
+	sort.Ints(cpuIDs)
+	for _, cpuID := range cpuIDs {
+		res.Resources = append(res.Resources, ResourceInfo{
+			Kind:       ResourceCPU,
+			ID:         strconv.Itoa(cpuID),
+			NUMACellID: R.CPUToNUMANode[cpuID],
+		})
+	}
+	cpuNodeIDs := sortedNodes(cpuNodes)
+	if len(cpuNodeIDs) > 1 {
+		res.addReason(ReasonCPUSplit, "CPUs are on NUMA nodes %s", cpuset.Unparse(cpuNodeIDs))
+	}
+
+	var devAddrs []string
+	for devAddr := range R.PCIDevsToNUMANode {
+		devAddrs = append(devAddrs, devAddr)
+	}
+	sort.Strings(devAddrs)
+	for _, devAddr := range devAddrs {
+		devNode := R.PCIDevsToNUMANode[devAddr]
+		devInfo := R.deviceInfo(devAddr)
+		ri := ResourceInfo{
+			Kind:       ResourceDevice,
+			ID:         devAddr,
+			NUMACellID: devNode,
+			Resource:   devInfo.Resource,
+		}
+		ni, inferred := R.PCIDevsNUMAInference[devAddr]
+		if inferred {
+			reported := ni.Reported
+			ri.ReportedNUMACellID = &reported
+			ri.NUMASource = string(ni.Source)
+			ri.NUMAConfidence = string(ni.Confidence)
+		}
+		res.Resources = append(res.Resources, ri)
+
+		if devNode == pcidev.NUMANodeUnknown {
+			res.addReason(ReasonDeviceNUMAUnknown, "%s reports unknown NUMA node", devInfo)
+			continue
+		}
+		if inferred && ni.Inferred() {
+			res.addReason(ReasonDeviceNUMAInferred, "%s reports unknown NUMA node, likely on NUMA node %d according to %s (confidence %s)", devInfo, devNode, ni.Source, ni.Confidence)
+		}
+		if !cpuNodes[devNode] {
+			res.addReason(ReasonDeviceRemote, "%s is on NUMA node %d, CPUs are on NUMA nodes %s", devInfo, devNode, cpuset.Unparse(cpuNodeIDs))
+		}
+	}
+
+	memNodeIDs := sortedNodes(R.MemoryNUMANodes)
+	for _, memNode := range memNodeIDs {
+		res.Resources = append(res.Resources, ResourceInfo{
+			Kind:       ResourceMemory,
+			ID:         strconv.Itoa(memNode),
+			NUMACellID: memNode,
+		})
+		if !cpuNodes[memNode] {
+			res.addReason(ReasonMemoryRemote, "memory can be allocated from NUMA node %d, CPUs are on NUMA nodes %s", memNode, cpuset.Unparse(cpuNodeIDs))
+		}
+	}
+
+	if !res.Narrowest {
+		res.addReason(ReasonNotNarrowest, "resources are on NUMA nodes %s, narrowest set is %s", cpuset.Unparse(res.NUMANodes), cpuset.Unparse(R.NarrowestNUMANodes()))
+	}
+
+	for _, name := range R.SkippedNetdevs {
+		res.addReason(ReasonNetdevSkipped, "netdev %s is not backed by a PCI device", name)
+	}
+	for _, name := range R.SkippedBlockDevs {
+		res.addReason(ReasonBlockDevSkipped, "block device %s is not backed by a PCI device", name)
+	}
+
+	for _, mm := range R.AffinityMismatches {
+		res.addReason(ReasonThreadAffinityMismatch, "thread %d (%s) of pid %d can run on CPUs %s, process can run on %s", mm.Tid, mm.Name, mm.Pid, cpuset.Unparse(mm.CPUs), cpuset.Unparse(mm.ExpectedCPUs))
+	}
+}
@@ -0,0 +1,291 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numalign
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ffromani/numalign/pkg/irqs"
+	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
+)
+
+func TestResultReasons(t *testing.T) {
+	type tcase struct {
+		name     string
+		cpuIDs   []int
+		devNodes map[string]int
+		memNodes []int
+		reasons  []ReasonCode
+	}
+
+	for _, tc := range []tcase{
+		{
+			name:     "aligned",
+			cpuIDs:   []int{4, 5},
+			devNodes: map[string]int{"0000:3b:02.1": 1},
+			memNodes: []int{1},
+			reasons:  nil,
+		},
+		{
+			name:     "split CPUs",
+			cpuIDs:   []int{3, 4},
+			devNodes: map[string]int{},
+			memNodes: []int{0, 1},
+			reasons:  []ReasonCode{ReasonCPUSplit, ReasonNotNarrowest},
+		},
+		{
+			name:     "remote and unknown devices",
+			cpuIDs:   []int{4, 5},
+			devNodes: map[string]int{"0000:3b:02.1": 0, "0000:3b:02.2": -1},
+			memNodes: []int{1},
+			reasons:  []ReasonCode{ReasonDeviceRemote, ReasonDeviceNUMAUnknown, ReasonNotNarrowest},
+		},
+		{
+			name:     "remote memory",
+			cpuIDs:   []int{4, 5},
+			devNodes: map[string]int{},
+			memNodes: []int{0, 1},
+			reasons:  []ReasonCode{ReasonMemoryRemote, ReasonNotNarrowest},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			R := newTestResources(t, tc.cpuIDs, tc.devNodes, tc.memNodes)
+			res := R.CheckAlignment()
+			var codes []ReasonCode
+			for _, reason := range res.Reasons {
+				codes = append(codes, reason.Code)
+			}
+			if !cmp.Equal(codes, tc.reasons) {
+				t.Errorf("reasons mismatch: got %v expected %v", codes, tc.reasons)
+			}
+			if len(res.Resources) != len(tc.cpuIDs)+len(tc.devNodes)+len(tc.memNodes) {
+				t.Errorf("resources miscount: %v", res.Resources)
+			}
+		})
+	}
+}
+
+func TestResultSkippedBlockDevs(t *testing.T) {
+	R := newTestResources(t, []int{4, 5}, map[string]int{}, []int{1})
+	R.SkippedBlockDevs = []string{"/data"}
+	res := R.CheckAlignment()
+	if !res.Aligned {
+		t.Errorf("skipped block device failed the check: %v", res.Reasons)
+	}
+	exp := []Reason{{Code: ReasonBlockDevSkipped, Message: "block device /data is not backed by a PCI device"}}
+	if !cmp.Equal(res.Reasons, exp) {
+		t.Errorf("reasons mismatch: got %v expected %v", res.Reasons, exp)
+	}
+}
+
+func TestResultJSONVersioned(t *testing.T) {
+	R := newTestResources(t, []int{0, 1}, map[string]int{"0000:3b:02.1": 0}, []int{0})
+	res := R.CheckAlignment()
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(res.JSON()), &data); err != nil {
+		t.Fatalf("cannot decode JSON result: %v", err)
+	}
+	if ver, ok := data["version"].(float64); !ok || int(ver) != ResultVersion {
+		t.Errorf("unexpected version: %v", data["version"])
+	}
+	expected := []ResourceInfo{
+		{Kind: ResourceCPU, ID: "0", NUMACellID: 0},
+		{Kind: ResourceCPU, ID: "1", NUMACellID: 0},
+		{Kind: ResourceDevice, ID: "0000:3b:02.1", NUMACellID: 0},
+		{Kind: ResourceMemory, ID: "0", NUMACellID: 0},
+	}
+	if !cmp.Equal(res.Resources, expected) {
+		t.Errorf("resources mismatch: %v", cmp.Diff(res.Resources, expected))
+	}
+}
+
+func TestCheckIRQAffinity(t *testing.T) {
+	type tcase struct {
+		name       string
+		deviceIRQs map[string][]irqs.Info
+		exclusive  bool
+		isolated   []int
+		reasons    []ReasonCode
+	}
+
+	devNodes := map[string]int{"0000:3b:02.1": 1, "0000:3b:02.2": -1}
+	for _, tc := range []tcase{
+		{
+			name: "housekeeping CPUs on the device node",
+			deviceIRQs: map[string][]irqs.Info{
+				"0000:3b:02.1": {
+					{IRQ: 120, Affinity: []int{0, 1, 2, 3, 4, 5, 6, 7}, EffectiveAffinity: []int{6}},
+					{IRQ: 121, Affinity: []int{6, 7}},
+				},
+				"0000:3b:02.2": {{IRQ: 122, Affinity: []int{0}}},
+			},
+		},
+		{
+			name: "remote CPUs",
+			deviceIRQs: map[string][]irqs.Info{
+				"0000:3b:02.1": {{IRQ: 120, Affinity: []int{0, 1, 2, 3}, EffectiveAffinity: []int{2}}},
+			},
+			reasons: []ReasonCode{ReasonIRQRemote},
+		},
+		{
+			name: "remote allowed CPUs, no effective affinity",
+			deviceIRQs: map[string][]irqs.Info{
+				"0000:3b:02.1": {{IRQ: 120, Affinity: []int{0, 1, 2, 3, 4, 5, 6, 7}}},
+			},
+		},
+		{
+			name: "shared container CPUs",
+			deviceIRQs: map[string][]irqs.Info{
+				"0000:3b:02.1": {{IRQ: 120, Affinity: []int{4, 5, 6, 7}, EffectiveAffinity: []int{4}}},
+			},
+		},
+		{
+			name: "exclusive container CPUs",
+			deviceIRQs: map[string][]irqs.Info{
+				"0000:3b:02.1": {{IRQ: 120, Affinity: []int{4, 5, 6, 7}}},
+			},
+			exclusive: true,
+			reasons:   []ReasonCode{ReasonIRQOnContainerCPUs},
+		},
+		{
+			name: "isolated container CPUs",
+			deviceIRQs: map[string][]irqs.Info{
+				"0000:3b:02.1": {{IRQ: 120, Affinity: []int{0, 1, 2, 3, 4, 5, 6, 7}}},
+			},
+			isolated: []int{5, 13},
+			reasons:  []ReasonCode{ReasonIRQOnContainerCPUs},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			R := newTestResources(t, []int{4, 5}, devNodes, []int{1})
+			R.DeviceIRQs = tc.deviceIRQs
+			R.ExclusiveCPUs = tc.exclusive
+			R.IsolatedCPUs = tc.isolated
+			res := R.CheckAlignment()
+			// only the IRQ findings
+			res.Reasons = nil
+			res, err := R.CheckIRQAffinity(res)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var codes []ReasonCode
+			for _, reason := range res.Reasons {
+				codes = append(codes, reason.Code)
+			}
+			if !cmp.Equal(codes, tc.reasons) {
+				t.Errorf("reasons mismatch: got %v expected %v", codes, tc.reasons)
+			}
+			if len(tc.reasons) > 0 && res.Aligned {
+				t.Errorf("IRQ findings did not make the check fail")
+			}
+		})
+	}
+
+	R := newTestResources(t, []int{4, 5}, devNodes, []int{1})
+	if _, err := R.CheckIRQAffinity(R.CheckAlignment()); err == nil {
+		t.Errorf("missing IRQ data not reported")
+	}
+}
+
+func TestGetIsolatedCPUList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "numalign-isolated")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	isolatedFile := filepath.Join(dir, "isolated")
+	for content, expected := range map[string][]int{
+		"\n":      {},
+		"2-3,6\n": {2, 3, 6},
+	} {
+		if err := ioutil.WriteFile(isolatedFile, []byte(content), 0644); err != nil {
+			t.Fatalf("error writing the isolated file: %v", err)
+		}
+		got, err := GetIsolatedCPUList(isolatedFile)
+		if err != nil || !cmp.Equal(got, expected) {
+			t.Errorf("isolated CPUs mismatch for %q: got %v (%v) expected %v", content, got, err, expected)
+		}
+	}
+
+	// older kernels don't report them
+	got, err := GetIsolatedCPUList(filepath.Join(dir, "missing"))
+	if err != nil || len(got) != 0 {
+		t.Errorf("unexpected isolated CPUs from a missing file: %v (%v)", got, err)
+	}
+}
+
+func TestUnknownNUMANodes(t *testing.T) {
+	devNodes := map[string]int{"0000:3b:02.1": 1, "0000:3b:02.2": -1}
+	R := newTestResources(t, []int{4, 5}, devNodes, []int{1})
+	R.PCIDevsNUMAInference = map[string]pcidev.NUMAInference{
+		"0000:3b:02.1": {Reported: -1, Node: 1, Source: pcidev.NUMASourceParent, Confidence: pcidev.NUMAConfidenceMedium},
+		"0000:3b:02.2": {Reported: -1, Node: -1, Source: pcidev.NUMASourceNone, Confidence: pcidev.NUMAConfidenceNone},
+	}
+	res := R.CheckAlignment()
+
+	reported := -1
+	expected := []ResourceInfo{
+		{Kind: ResourceDevice, ID: "0000:3b:02.1", NUMACellID: 1, ReportedNUMACellID: &reported, NUMASource: "parent", NUMAConfidence: "medium"},
+		{Kind: ResourceDevice, ID: "0000:3b:02.2", NUMACellID: -1, ReportedNUMACellID: &reported, NUMAConfidence: "none"},
+	}
+	var devRes []ResourceInfo
+	for _, ri := range res.Resources {
+		if ri.Kind == ResourceDevice {
+			devRes = append(devRes, ri)
+		}
+	}
+	if !cmp.Equal(devRes, expected) {
+		t.Errorf("device resources mismatch: %v", cmp.Diff(devRes, expected))
+	}
+
+	type tcase struct {
+		mode    UnknownNUMAMode
+		aligned bool
+		reasons []ReasonCode
+	}
+	for _, tc := range []tcase{
+		{UnknownNUMAPass, true, []ReasonCode{ReasonDeviceNUMAInferred}},
+		{UnknownNUMAWarn, true, []ReasonCode{ReasonDeviceNUMAInferred, ReasonDeviceNUMAUnknown}},
+		{UnknownNUMAFail, false, []ReasonCode{ReasonDeviceNUMAInferred, ReasonDeviceNUMAUnknown}},
+	} {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			got := CheckUnknownNUMANodes(res, tc.mode)
+			var codes []ReasonCode
+			for _, reason := range got.Reasons {
+				codes = append(codes, reason.Code)
+			}
+			if !cmp.Equal(codes, tc.reasons) {
+				t.Errorf("reasons mismatch: got %v expected %v", codes, tc.reasons)
+			}
+			if got.Aligned != tc.aligned {
+				t.Errorf("aligned mismatch: got %v expected %v", got.Aligned, tc.aligned)
+			}
+		})
+	}
+
+	if _, err := ParseUnknownNUMAMode("ignore"); err == nil {
+		t.Errorf("unsupported mode parsed")
+	}
+}
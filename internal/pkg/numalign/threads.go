@@ -0,0 +1,85 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numalign
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/ffromani/numalign/pkg/procs"
+)
+
+// ThreadAffinity is the set of CPUs a thread is allowed to run on
+type ThreadAffinity struct {
+	Pid  int    `json:"pid"`
+	Tid  int    `json:"tid"`
+	Name string `json:"name"`
+	CPUs []int  `json:"cpus"`
+}
+
+// AffinityMismatch is a thread whose CPU affinity differs from the reference one
+type AffinityMismatch struct {
+	ThreadAffinity
+	ExpectedCPUs []int `json:"expectedcpus"`
+}
+
+// GetThreadsAffinity returns the CPU affinity of all the threads of the given process
+func GetThreadsAffinity(procfsRoot, pid string) ([]ThreadAffinity, error) {
+	infos, err := procs.Threads(procfsRoot, pid)
+	if err != nil {
+		return nil, err
+	}
+	var ret []ThreadAffinity
+	for _, info := range infos {
+		cpuIDs := append([]int{}, info.Affinity...)
+		sort.Ints(cpuIDs)
+		ret = append(ret, ThreadAffinity{
+			Pid:  int(info.Tgid),
+			Tid:  int(info.Pid),
+			Name: info.Name,
+			CPUs: cpuIDs,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Tid < ret[j].Tid })
+	return ret, nil
+}
+
+// FindAffinityMismatches returns all the threads whose affinity differs from the given reference
+func FindAffinityMismatches(refCPUIDs []int, threads []ThreadAffinity) []AffinityMismatch {
+	var mismatches []AffinityMismatch
+	for _, thread := range threads {
+		if reflect.DeepEqual(refCPUIDs, thread.CPUs) {
+			continue
+		}
+		mismatches = append(mismatches, AffinityMismatch{
+			ThreadAffinity: thread,
+			ExpectedCPUs:   refCPUIDs,
+		})
+	}
+	return mismatches
+}
+
+// ThreadsCPUs returns the sorted union of the CPUs all the given threads are allowed to run on
+func ThreadsCPUs(threads []ThreadAffinity) []int {
+	cpuIDs := make(map[int]bool)
+	for _, thread := range threads {
+		for _, cpuID := range thread.CPUs {
+			cpuIDs[cpuID] = true
+		}
+	}
+	return sortedNodes(cpuIDs)
+}
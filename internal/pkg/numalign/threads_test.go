@@ -0,0 +1,95 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numalign
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	fakesysfs "github.com/ffromani/numalign/pkg/topologyinfo/sysfs/fake"
+)
+
+func makeTaskStatus(name string, tgid, pid int, cpuList string) map[string]string {
+	return map[string]string{
+		"status": fmt.Sprintf("Name:\t%s\nTgid:\t%d\nPid:\t%d\nCpus_allowed_list:\t%s\nMems_allowed_list:\t0\n", name, tgid, pid, cpuList),
+	}
+}
+
+func TestThreadsAffinity(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+	t.Logf("procfs at %q", fs.Base())
+
+	tasks := fs.AddTree("proc", "1700", "task")
+	tasks.Add("1700", makeTaskStatus("dpdk-testpmd", 1700, 1700, "2-5"))
+	tasks.Add("1701", makeTaskStatus("eal-intr-thread", 1700, 1701, "2-5"))
+	tasks.Add("1702", makeTaskStatus("lcore-worker-3", 1700, 1702, "3"))
+	tasks.Add("1703", makeTaskStatus("lcore-worker-4", 1700, 1703, "4"))
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	threads, err := GetThreadsAffinity(filepath.Join(fs.Base(), "proc"), "1700")
+	if err != nil {
+		t.Fatalf("error in GetThreadsAffinity: %v", err)
+	}
+	if len(threads) != 4 {
+		t.Fatalf("unexpected threads: %v", threads)
+	}
+
+	mismatches := FindAffinityMismatches([]int{2, 3, 4, 5}, threads)
+	expected := []AffinityMismatch{
+		{
+			ThreadAffinity: ThreadAffinity{Pid: 1700, Tid: 1702, Name: "lcore-worker-3", CPUs: []int{3}},
+			ExpectedCPUs:   []int{2, 3, 4, 5},
+		},
+		{
+			ThreadAffinity: ThreadAffinity{Pid: 1700, Tid: 1703, Name: "lcore-worker-4", CPUs: []int{4}},
+			ExpectedCPUs:   []int{2, 3, 4, 5},
+		},
+	}
+	if !cmp.Equal(mismatches, expected) {
+		t.Errorf("mismatches differ: %v", cmp.Diff(mismatches, expected))
+	}
+
+	if cpuIDs := ThreadsCPUs(threads); !cmp.Equal(cpuIDs, []int{2, 3, 4, 5}) {
+		t.Errorf("unexpected threads CPUs: %v", cpuIDs)
+	}
+}
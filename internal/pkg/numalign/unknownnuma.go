@@ -0,0 +1,83 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numalign
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
+)
+
+// UnknownNUMAMode tells how the devices whose NUMA node is unknown, even after inference, affect the result
+type UnknownNUMAMode string
+
+const (
+	// UnknownNUMAPass ignores the devices with unknown NUMA node
+	UnknownNUMAPass UnknownNUMAMode = "pass"
+	// UnknownNUMAWarn reports the devices with unknown NUMA node, without affecting the alignment
+	UnknownNUMAWarn UnknownNUMAMode = "warn"
+	// UnknownNUMAFail makes the check fail if any device has unknown NUMA node
+	UnknownNUMAFail UnknownNUMAMode = "fail"
+)
+
+func ParseUnknownNUMAMode(s string) (UnknownNUMAMode, error) {
+	switch UnknownNUMAMode(s) {
+	case UnknownNUMAPass, UnknownNUMAWarn, UnknownNUMAFail:
+		return UnknownNUMAMode(s), nil
+	}
+	return UnknownNUMAMode(""), fmt.Errorf("unsupported unknown NUMA node mode: %q", s)
+}
+
+// InferDeviceNUMANodes replaces the unknown NUMA nodes of the devices with the likely ones, if they can be inferred.
+// cpusPerNode are all the CPUs of each NUMA node. The inference of each device is recorded in PCIDevsNUMAInference.
+func (R *Resources) InferDeviceNUMANodes(pciDevs *pcidev.PCIDevices, cpusPerNode map[int][]int) {
+	for devAddr, devNode := range R.PCIDevsToNUMANode {
+		if devNode != pcidev.NUMANodeUnknown {
+			continue
+		}
+		ni, ok := pciDevs.InferNUMANode(devAddr, cpusPerNode)
+		if !ok {
+			continue
+		}
+		log.Printf("PCI: %s: unknown NUMA node, inferred %d from %q (confidence %s)", devAddr, ni.Node, ni.Source, ni.Confidence)
+		if R.PCIDevsNUMAInference == nil {
+			R.PCIDevsNUMAInference = make(map[string]pcidev.NUMAInference)
+		}
+		R.PCIDevsNUMAInference[devAddr] = ni
+		R.PCIDevsToNUMANode[devAddr] = ni.Node
+	}
+}
+
+// CheckUnknownNUMANodes applies the given mode to the devices whose NUMA node is unknown
+func CheckUnknownNUMANodes(res Result, mode UnknownNUMAMode) Result {
+	switch mode {
+	case UnknownNUMAPass:
+		var reasons []Reason
+		for _, reason := range res.Reasons {
+			if reason.Code != ReasonDeviceNUMAUnknown {
+				reasons = append(reasons, reason)
+			}
+		}
+		res.Reasons = reasons
+	case UnknownNUMAFail:
+		if res.HasReason(ReasonDeviceNUMAUnknown) {
+			res.Aligned = false
+		}
+	}
+	return res
+}
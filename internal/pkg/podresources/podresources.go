@@ -0,0 +1,192 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package podresources
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	podresourcesapi "k8s.io/kubelet/pkg/apis/podresources/v1"
+
+	"github.com/ffromani/numalign/internal/pkg/numalign"
+	"github.com/ffromani/numalign/pkg/topologyinfo/cpus"
+	"github.com/ffromani/numalign/pkg/topologyinfo/numa/distances"
+	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
+)
+
+const (
+	// DefaultSocketPath is where the kubelet exposes the PodResources API
+	DefaultSocketPath = "/var/lib/kubelet/pod-resources/kubelet.sock"
+	// DefaultTimeout is the default time allowed to connect and to complete each call
+	DefaultTimeout = 10 * time.Second
+)
+
+// Connect returns a client for the PodResources API served at the given unix socket path.
+// The "unix://" prefix is optional. The returned connection must be closed once done.
+func Connect(socketPath string, timeout time.Duration) (podresourcesapi.PodResourcesListerClient, *grpc.ClientConn, error) {
+	socketPath = strings.TrimPrefix(socketPath, "unix://")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, socketPath,
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", addr)
+		}),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot connect to %q: %w", socketPath, err)
+	}
+	return podresourcesapi.NewPodResourcesListerClient(conn), conn, nil
+}
+
+// ContainerReport is the alignment of the exclusive resources of a container
+type ContainerReport struct {
+	Namespace string          `json:"namespace"`
+	Pod       string          `json:"pod"`
+	Container string          `json:"container"`
+	Result    numalign.Result `json:"result"`
+}
+
+// Topology is the node data needed to map resources to NUMA nodes
+type Topology struct {
+	CPUs       *cpus.CPUs
+	PCIDevices *pcidev.PCIDevices
+	// Distances may be nil if unavailable
+	Distances *distances.Distances
+}
+
+// Audit checks the alignment of the exclusive resources of all the containers running on the node
+func Audit(ctx context.Context, cli podresourcesapi.PodResourcesListerClient, topo Topology, policy numalign.Policy) ([]ContainerReport, error) {
+	resp, err := cli.List(ctx, &podresourcesapi.ListPodResourcesRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list pod resources: %w", err)
+	}
+
+	cpusPerNUMANode := topo.CPUs.NUMANodeCPUs
+	allocResp, err := cli.GetAllocatableResources(ctx, &podresourcesapi.AllocatableResourcesRequest{})
+	if err != nil {
+		// not fatal: requires the KubeletPodResourcesGetAllocatable feature gate
+		log.Printf("PODRES: cannot get allocatable resources: %v - using all the CPUs", err)
+	} else {
+		cpusPerNUMANode = groupCPUsByNUMANode(topo.CPUs, allocResp.GetCpuIds())
+	}
+
+	allCPUsPerNUMANode := toIntMap(topo.CPUs.NUMANodeCPUs)
+	cpuToNUMANode := numalign.GetCPUNUMANodes(allCPUsPerNUMANode)
+
+	var reports []ContainerReport
+	for _, podRes := range resp.GetPodResources() {
+		for _, cntRes := range podRes.GetContainers() {
+			R := numalign.Resources{
+				CPUToNUMANode:     make(map[int]int),
+				PCIDevsToNUMANode: make(map[string]int),
+				PCIDevsInfo:       make(map[string]numalign.DeviceInfo),
+				MemoryNUMANodes:   make(map[int]bool),
+				CPUsPerNUMANode:   cpusPerNUMANode,
+				Distances:         topo.Distances,
+			}
+			for _, cpuID := range cntRes.GetCpuIds() {
+				R.CPUToNUMANode[int(cpuID)] = cpuToNUMANode[int(cpuID)]
+			}
+			for _, dev := range cntRes.GetDevices() {
+				for _, devID := range dev.GetDeviceIds() {
+					R.PCIDevsToNUMANode[devID] = deviceNUMANode(topo.PCIDevices, devID, dev.GetTopology())
+					R.PCIDevsInfo[devID] = numalign.DeviceInfo{
+						Resource: dev.GetResourceName(),
+						Address:  devID,
+					}
+				}
+			}
+			for _, mem := range cntRes.GetMemory() {
+				for _, node := range mem.GetTopology().GetNodes() {
+					R.MemoryNUMANodes[int(node.GetID())] = true
+				}
+			}
+
+			if len(R.CPUToNUMANode) == 0 && len(R.PCIDevsToNUMANode) == 0 && len(R.MemoryNUMANodes) == 0 {
+				continue // nothing exclusive, nothing to check
+			}
+			if topo.PCIDevices != nil {
+				R.InferDeviceNUMANodes(topo.PCIDevices, allCPUsPerNUMANode)
+			}
+
+			reports = append(reports, ContainerReport{
+				Namespace: podRes.GetNamespace(),
+				Pod:       podRes.GetName(),
+				Container: cntRes.GetName(),
+				Result:    R.CheckAlignmentWithPolicy(policy),
+			})
+		}
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Namespace != reports[j].Namespace {
+			return reports[i].Namespace < reports[j].Namespace
+		}
+		if reports[i].Pod != reports[j].Pod {
+			return reports[i].Pod < reports[j].Pod
+		}
+		return reports[i].Container < reports[j].Container
+	})
+	return reports, nil
+}
+
+// deviceNUMANode prefers what sysfs tells about PCI devices, falling back to what the device plugin reported
+func deviceNUMANode(pciDevs *pcidev.PCIDevices, devID string, topo *podresourcesapi.TopologyInfo) int {
+	if pciDevs != nil {
+		if devInfo, found := pciDevs.FindByAddress(devID); found {
+			return devInfo.NUMANode()
+		}
+	}
+	nodes := topo.GetNodes()
+	if len(nodes) != 1 {
+		return pcidev.NUMANodeUnknown
+	}
+	return int(nodes[0].GetID())
+}
+
+func groupCPUsByNUMANode(cpuRes *cpus.CPUs, cpuIDs []int64) map[int]cpus.CPUIdList {
+	cpuToNUMANode := numalign.GetCPUNUMANodes(toIntMap(cpuRes.NUMANodeCPUs))
+	ret := make(map[int]cpus.CPUIdList)
+	for _, node := range cpuRes.NUMANodes {
+		ret[node] = cpus.CPUIdList{}
+	}
+	for _, cpuID := range cpuIDs {
+		node, ok := cpuToNUMANode[int(cpuID)]
+		if !ok {
+			continue
+		}
+		ret[node] = append(ret[node], int(cpuID))
+	}
+	return ret
+}
+
+func toIntMap(data map[int]cpus.CPUIdList) map[int][]int {
+	ret := make(map[int][]int)
+	for key, val := range data {
+		ret[key] = val
+	}
+	return ret
+}
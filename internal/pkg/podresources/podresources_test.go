@@ -0,0 +1,143 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package podresources
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	podresourcesapi "k8s.io/kubelet/pkg/apis/podresources/v1"
+
+	"github.com/ffromani/numalign/internal/pkg/numalign"
+	"github.com/ffromani/numalign/pkg/topologyinfo/cpus"
+)
+
+type fakeServer struct {
+	podresourcesapi.UnimplementedPodResourcesListerServer
+	resp *podresourcesapi.ListPodResourcesResponse
+}
+
+func (fs *fakeServer) List(ctx context.Context, req *podresourcesapi.ListPodResourcesRequest) (*podresourcesapi.ListPodResourcesResponse, error) {
+	return fs.resp, nil
+}
+
+func numaTopo(nodes ...int64) *podresourcesapi.TopologyInfo {
+	ti := &podresourcesapi.TopologyInfo{}
+	for _, node := range nodes {
+		ti.Nodes = append(ti.Nodes, &podresourcesapi.NUMANode{ID: node})
+	}
+	return ti
+}
+
+func TestAudit(t *testing.T) {
+	base, err := ioutil.TempDir("", "numalign-podres")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	sockPath := filepath.Join(base, "kubelet.sock")
+	lis, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("error listening on %q: %v", sockPath, err)
+	}
+
+	srv := grpc.NewServer()
+	podresourcesapi.RegisterPodResourcesListerServer(srv, &fakeServer{
+		resp: &podresourcesapi.ListPodResourcesResponse{
+			PodResources: []*podresourcesapi.PodResources{
+				{
+					Name:      "pod-aligned",
+					Namespace: "default",
+					Containers: []*podresourcesapi.ContainerResources{
+						{
+							Name:   "cnt",
+							CpuIds: []int64{0, 1},
+							Devices: []*podresourcesapi.ContainerDevices{
+								{
+									ResourceName: "example.com/nic",
+									DeviceIds:    []string{"0000:3b:00.1"},
+									Topology:     numaTopo(0),
+								},
+							},
+							Memory: []*podresourcesapi.ContainerMemory{
+								{MemoryType: "memory", Size_: 1 << 30, Topology: numaTopo(0)},
+							},
+						},
+						{
+							// shared pool only: must be skipped
+							Name: "sidecar",
+						},
+					},
+				},
+				{
+					Name:      "pod-split",
+					Namespace: "default",
+					Containers: []*podresourcesapi.ContainerResources{
+						{
+							Name:   "cnt",
+							CpuIds: []int64{1, 2},
+						},
+					},
+				},
+			},
+		},
+	})
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	cli, conn, err := Connect("unix://"+sockPath, 5*time.Second)
+	if err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+	defer conn.Close()
+
+	topo := Topology{
+		CPUs: &cpus.CPUs{
+			Present:   cpus.CPUIdList{0, 1, 2, 3},
+			Online:    cpus.CPUIdList{0, 1, 2, 3},
+			NUMANodes: cpus.CPUIdList{0, 1},
+			NUMANodeCPUs: map[int]cpus.CPUIdList{
+				0: {0, 1},
+				1: {2, 3},
+			},
+		},
+	}
+	reports, err := Audit(context.Background(), cli, topo, numalign.PolicySingleNUMANode)
+	if err != nil {
+		t.Fatalf("unexpected audit error: %v", err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("expected 2 reports, got %d: %#v", len(reports), reports)
+	}
+
+	if reports[0].Pod != "pod-aligned" || !reports[0].Result.Aligned || reports[0].Result.NUMACellID != 0 {
+		t.Errorf("unexpected report for aligned pod: %#v", reports[0])
+	}
+	if reports[1].Pod != "pod-split" || reports[1].Result.Aligned {
+		t.Errorf("unexpected report for split pod: %#v", reports[1])
+	}
+	if !reports[1].Result.HasReason(numalign.ReasonCPUSplit) {
+		t.Errorf("missing CPU split reason: %#v", reports[1].Result.Reasons)
+	}
+}
@@ -18,15 +18,8 @@ package sriovscan
 
 import (
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
-	"strconv"
-	"strings"
-)
 
-const (
-	SysBusPCIDevicesDir = "/sys/bus/pci/devices/"
+	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
 )
 
 type PCIDeviceInfo struct {
@@ -40,48 +33,21 @@ func (pdi PCIDeviceInfo) String() string {
 	return fmt.Sprintf("pci@%s numa_node=%d physfn=%v vfn=%v", pdi.Address, pdi.NUMANode, pdi.IsPhysFn, pdi.IsVFn)
 }
 
-func GetPCIDeviceInfo(sysPCIDir string) ([]PCIDeviceInfo, error) {
+// FromPCIDevices converts the devices discovered by the pcidev package
+func FromPCIDevices(devInfos pcidev.PCIDeviceInfoList) []PCIDeviceInfo {
 	var pciDevs []PCIDeviceInfo
-
-	entries, err := ioutil.ReadDir(SysBusPCIDevicesDir)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, entry := range entries {
-		isPhysFn := false
-		isVFn := false
-		if _, err := os.Stat(filepath.Join(sysPCIDir, entry.Name(), "sriov_numvfs")); err == nil {
-			isPhysFn = true
-		} else if !os.IsNotExist(err) {
-			// unexpected error. Bail out
-			return nil, err
+	for _, devInfo := range devInfos {
+		pdi := PCIDeviceInfo{
+			Address:  devInfo.Address(),
+			NUMANode: devInfo.NUMANode(),
 		}
-		if _, err := os.Stat(filepath.Join(sysPCIDir, entry.Name(), "physfn")); err == nil {
-			isVFn = true
-		} else if !os.IsNotExist(err) {
-			// unexpected error. Bail out
-			return nil, err
+		if sdi, ok := devInfo.(pcidev.SRIOVDeviceInfo); ok {
+			pdi.IsPhysFn = sdi.IsPhysFn
+			pdi.IsVFn = sdi.IsVFn
 		}
-
-		content, err := ioutil.ReadFile(filepath.Join(sysPCIDir, entry.Name(), "numa_node"))
-		if err != nil {
-			return nil, err
-		}
-		nodeNum, err := strconv.Atoi(strings.TrimSpace(string(content)))
-		if err != nil {
-			return nil, err
-		}
-
-		pciDevs = append(pciDevs, PCIDeviceInfo{
-			Address:  entry.Name(),
-			NUMANode: nodeNum,
-			IsPhysFn: isPhysFn,
-			IsVFn:    isVFn,
-		})
+		pciDevs = append(pciDevs, pdi)
 	}
-
-	return pciDevs, nil
+	return pciDevs
 }
 
 func CountPCIDevicePerNUMANode(pciDevs []PCIDeviceInfo) map[int]int {
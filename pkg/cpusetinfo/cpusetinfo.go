@@ -35,7 +35,9 @@ const (
 
 const (
 	onlineCPUsPath            string = "devices/system/cpu/online"
+	onlineNodesPath           string = "devices/system/node/online"
 	cpusetFile                string = "cpuset.cpus"
+	memsetFile                string = "cpuset.mems"
 	threadSiblingListTmplPath string = "devices/system/cpu/cpu%d/topology/thread_siblings_list"
 )
 
@@ -49,20 +51,40 @@ const (
 
 // GetCPUSetForPID retrieves the cpuset allowed for a process, given its pid
 func GetCPUSetForPID(fsh FSHandle, pid int) (cpuset.CPUSet, error) {
+	return getCPUSetFileForPID(fsh, pid, cpusetFile, onlineCPUsPath)
+}
+
+// GetMemSetForPID retrieves the set of the NUMA nodes whose memory is allowed for a process, given its pid
+func GetMemSetForPID(fsh FSHandle, pid int) (cpuset.CPUSet, error) {
+	return getCPUSetFileForPID(fsh, pid, memsetFile, onlineNodesPath)
+}
+
+// GetCPUSetCGroupPathForPID retrieves the cpuset cgroup path of a process, relative to the
+// cgroup mount point, and the cgroup version. The path is empty if the process is in the root cgroup.
+func GetCPUSetCGroupPathForPID(fsh FSHandle, pid int) (string, string, error) {
 	cgroupsFile, err := os.Open(cGroupsFileForPID(fsh, pid))
 	if err != nil {
-		return cpuset.CPUSet{}, err
+		return "", "", err
 	}
 	defer cgroupsFile.Close()
 
-	cpusPath := ""
 	subPath, version := GetCPUSetCGroupPathFromReader(cgroupsFile)
+	return subPath, version, nil
+}
+
+func getCPUSetFileForPID(fsh FSHandle, pid int, fileName, fallbackPath string) (cpuset.CPUSet, error) {
+	subPath, version, err := GetCPUSetCGroupPathForPID(fsh, pid)
+	if err != nil {
+		return cpuset.CPUSet{}, err
+	}
+
+	cpusPath := ""
 	if subPath == "" {
-		cpusPath = filepath.Join(fsh.GetSysMountPoint(), onlineCPUsPath)
+		cpusPath = filepath.Join(fsh.GetSysMountPoint(), fallbackPath)
 	} else {
 		switch version {
 		case cgroupV1:
-			cpusPath = filepath.Join(fsh.GetCGroupsMountPoint(), "cpuset", subPath, cpusetFile)
+			cpusPath = filepath.Join(fsh.GetCGroupsMountPoint(), "cpuset", subPath, fileName)
 		default:
 			return cpuset.CPUSet{}, fmt.Errorf("detected unsupported cgroup version: %q", version)
 		}
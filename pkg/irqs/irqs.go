@@ -0,0 +1,158 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+// Package irqs reads the interrupts of the PCI devices and the CPUs they can run on.
+package irqs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ffromani/cpuset"
+)
+
+const (
+	// SMPAffinityList is the file holding the CPUs an IRQ is allowed to run on
+	SMPAffinityList = "smp_affinity_list"
+	// EffectiveAffinityList is the file holding the CPUs an IRQ actually runs on
+	EffectiveAffinityList = "effective_affinity_list"
+)
+
+// Info is an interrupt and its CPU affinity
+type Info struct {
+	IRQ int
+	// Source is the name of the handler, like "nvme0q1". Empty if unknown.
+	Source string
+	// Affinity are the CPUs the IRQ is allowed to run on
+	Affinity []int
+	// EffectiveAffinity are the CPUs the IRQ actually runs on. Empty if not reported by the kernel.
+	EffectiveAffinity []int
+}
+
+// CPUs returns the CPUs the IRQ runs on: the effective affinity if known, the allowed CPUs otherwise
+func (info Info) CPUs() []int {
+	if len(info.EffectiveAffinity) > 0 {
+		return info.EffectiveAffinity
+	}
+	return info.Affinity
+}
+
+// List returns all the IRQs in procfs, sorted
+func List(procfs string) ([]int, error) {
+	files, err := ioutil.ReadDir(filepath.Join(procfs, "irq"))
+	if err != nil {
+		return nil, err
+	}
+	var irqs []int
+	for _, file := range files {
+		irq, err := strconv.Atoi(file.Name())
+		if err != nil {
+			continue // just skip not-irq-looking dirs
+		}
+		irqs = append(irqs, irq)
+	}
+	sort.Ints(irqs)
+	return irqs, nil
+}
+
+// Read returns the affinity of an IRQ. The effective affinity is optional.
+func Read(procfs string, irq int) (Info, error) {
+	affinity, err := ReadAffinityList(procfs, irq, SMPAffinityList)
+	if err != nil {
+		return Info{}, err
+	}
+	effective, err := ReadAffinityList(procfs, irq, EffectiveAffinityList)
+	if err != nil && !os.IsNotExist(err) {
+		return Info{}, err
+	}
+	return Info{
+		IRQ:               irq,
+		Source:            FindSource(procfs, irq),
+		Affinity:          affinity,
+		EffectiveAffinity: effective,
+	}, nil
+}
+
+// ReadAffinityList parses an affinity file of an IRQ, like SMPAffinityList
+func ReadAffinityList(procfs string, irq int, name string) ([]int, error) {
+	content, err := ioutil.ReadFile(filepath.Join(procfs, "irq", strconv.Itoa(irq), name))
+	if err != nil {
+		return nil, err
+	}
+	return cpuset.Parse(strings.TrimSpace(string(content)))
+}
+
+// FindSource returns the name of the handler of an IRQ, "MISSING" if the IRQ is unknown
+func FindSource(procfs string, irq int) string {
+	irqDir := filepath.Join(procfs, "irq", strconv.Itoa(irq))
+	files, err := ioutil.ReadDir(irqDir)
+	if err != nil {
+		return "MISSING"
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			return file.Name()
+		}
+	}
+	return ""
+}
+
+// ForPCIDevice returns the IRQs of a PCI device, sorted: the MSI(-X) vectors if any,
+// the legacy INTx line otherwise. Devices bound to vfio-pci or without driver usually have none.
+func ForPCIDevice(sysfs, address string) ([]int, error) {
+	devPath := filepath.Join(sysfs, "bus", "pci", "devices", address)
+	if _, err := os.Stat(devPath); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(filepath.Join(devPath, "msi_irqs"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var irqs []int
+	for _, file := range files {
+		irq, err := strconv.Atoi(file.Name())
+		if err != nil {
+			return nil, fmt.Errorf("unexpected MSI entry %q for %s", file.Name(), address)
+		}
+		irqs = append(irqs, irq)
+	}
+	if len(irqs) > 0 {
+		sort.Ints(irqs)
+		return irqs, nil
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(devPath, "irq"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	irq, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, err
+	}
+	if irq == 0 {
+		// no legacy interrupt assigned
+		return nil, nil
+	}
+	return []int{irq}, nil
+}
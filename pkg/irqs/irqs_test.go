@@ -0,0 +1,130 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package irqs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	fakesysfs "github.com/ffromani/numalign/pkg/topologyinfo/sysfs/fake"
+)
+
+func TestDeviceIRQs(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+
+	sysDevs := fs.AddTree("sys", "bus", "pci", "devices")
+	nvmeDev := sysDevs.Add("0000:5e:00.0", fakesysfs.MakeAttrs(map[string]string{
+		"irq": "16",
+	}))
+	nvmeDev.Add("msi_irqs", fakesysfs.MakeAttrs(map[string]string{
+		"128": "msix",
+		"127": "msix",
+	}))
+	sysDevs.Add("0000:00:1f.3", fakesysfs.MakeAttrs(map[string]string{
+		"irq": "19",
+	}))
+	sysDevs.Add("0000:3b:02.1", fakesysfs.MakeAttrs(map[string]string{
+		"irq": "0",
+	}))
+
+	procIRQ := fs.AddTree("proc", "irq")
+	procIRQ.Add("127", fakesysfs.MakeAttrs(map[string]string{
+		"smp_affinity_list":       "0-3",
+		"effective_affinity_list": "2",
+	})).Add("nvme0q0", nil)
+	procIRQ.Add("128", fakesysfs.MakeAttrs(map[string]string{
+		"smp_affinity_list": "1,3",
+	})).Add("nvme0q1", nil)
+	procIRQ.Add("19", fakesysfs.MakeAttrs(map[string]string{
+		"smp_affinity_list": "0-3",
+	}))
+	procIRQ.Add("default_smp_affinity", nil)
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	sysfs := filepath.Join(fs.Base(), "sys")
+	procfs := filepath.Join(fs.Base(), "proc")
+
+	irqList, err := List(procfs)
+	if err != nil {
+		t.Fatalf("error listing IRQs: %v", err)
+	}
+	if !cmp.Equal(irqList, []int{19, 127, 128}) {
+		t.Errorf("unexpected IRQs: %v", irqList)
+	}
+
+	for addr, expected := range map[string][]int{
+		"0000:5e:00.0": {127, 128},
+		"0000:00:1f.3": {19},
+		"0000:3b:02.1": nil,
+	} {
+		got, err := ForPCIDevice(sysfs, addr)
+		if err != nil {
+			t.Errorf("error reading IRQs of %s: %v", addr, err)
+			continue
+		}
+		if !cmp.Equal(got, expected) {
+			t.Errorf("unexpected IRQs of %s: %v expected %v", addr, got, expected)
+		}
+	}
+	if _, err := ForPCIDevice(sysfs, "0000:af:00.0"); err == nil {
+		t.Errorf("missing device reported no error")
+	}
+
+	for _, expected := range []Info{
+		{IRQ: 127, Source: "nvme0q0", Affinity: []int{0, 1, 2, 3}, EffectiveAffinity: []int{2}},
+		{IRQ: 128, Source: "nvme0q1", Affinity: []int{1, 3}},
+		{IRQ: 19, Source: "", Affinity: []int{0, 1, 2, 3}},
+	} {
+		got, err := Read(procfs, expected.IRQ)
+		if err != nil {
+			t.Errorf("error reading IRQ %d: %v", expected.IRQ, err)
+			continue
+		}
+		if !cmp.Equal(got, expected) {
+			t.Errorf("IRQ %d mismatch: %v", expected.IRQ, cmp.Diff(got, expected))
+		}
+	}
+	info, _ := Read(procfs, 127)
+	if !cmp.Equal(info.CPUs(), []int{2}) {
+		t.Errorf("unexpected CPUs for IRQ 127: %v", info.CPUs())
+	}
+}
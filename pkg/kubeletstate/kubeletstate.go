@@ -0,0 +1,240 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+// Package kubeletstate reads the checkpoint files the kubelet resource managers persist on disk.
+package kubeletstate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpuset"
+)
+
+const (
+	DefaultKubeletDir string = "/var/lib/kubelet"
+
+	CPUManagerStateFile    string = "cpu_manager_state"
+	MemoryManagerStateFile string = "memory_manager_state"
+	DeviceCheckpointFile   string = "device-plugins/kubelet_internal_checkpoint"
+)
+
+// CPUManagerState is the content of the cpu_manager_state file
+type CPUManagerState struct {
+	PolicyName    string `json:"policyName"`
+	DefaultCPUSet string `json:"defaultCpuSet"`
+	// Entries maps pod UID -> container name -> exclusive cpuset
+	Entries  map[string]map[string]string `json:"entries,omitempty"`
+	Checksum uint64                       `json:"checksum"`
+}
+
+// DefaultCPUs returns the CPUs of the shared pool
+func (cms CPUManagerState) DefaultCPUs() (cpuset.CPUSet, error) {
+	return cpuset.Parse(cms.DefaultCPUSet)
+}
+
+// ContainerCPUs returns the exclusive CPUs of a container. The bool is false if the container has no exclusive CPUs.
+func (cms CPUManagerState) ContainerCPUs(podUID, containerName string) (cpuset.CPUSet, bool, error) {
+	cnts, ok := cms.Entries[podUID]
+	if !ok {
+		return cpuset.CPUSet{}, false, nil
+	}
+	cpus, ok := cnts[containerName]
+	if !ok {
+		return cpuset.CPUSet{}, false, nil
+	}
+	res, err := cpuset.Parse(cpus)
+	return res, true, err
+}
+
+// MemoryBlock is a memory assignment of a container
+type MemoryBlock struct {
+	NUMAAffinity []int  `json:"numaAffinity"`
+	Type         string `json:"type"`
+	Size         uint64 `json:"size"`
+}
+
+// MemoryManagerState is the content of the memory_manager_state file.
+// The machine state is ignored.
+type MemoryManagerState struct {
+	PolicyName string `json:"policyName"`
+	// Entries maps pod UID -> container name -> memory blocks
+	Entries  map[string]map[string][]MemoryBlock `json:"entries,omitempty"`
+	Checksum uint64                              `json:"checksum"`
+}
+
+// ContainerNUMANodes returns all the NUMA nodes the memory of a container is pinned to.
+// The bool is false if the container has no memory pinned.
+func (mms MemoryManagerState) ContainerNUMANodes(podUID, containerName string) (cpuset.CPUSet, bool) {
+	cnts, ok := mms.Entries[podUID]
+	if !ok {
+		return cpuset.CPUSet{}, false
+	}
+	blocks, ok := cnts[containerName]
+	if !ok || len(blocks) == 0 {
+		return cpuset.CPUSet{}, false
+	}
+	b := cpuset.NewBuilder()
+	for _, block := range blocks {
+		b.Add(block.NUMAAffinity...)
+	}
+	return b.Result(), true
+}
+
+// PodDevicesEntry is a device assignment of a container
+type PodDevicesEntry struct {
+	PodUID        string
+	ContainerName string
+	ResourceName  string
+	// DeviceIDs maps NUMA node -> device IDs. Checkpoints written by kubelets older than 1.20
+	// have no NUMA information: all their devices are reported on NUMA node -1.
+	DeviceIDs map[int64][]string
+}
+
+// AllDeviceIDs returns the sorted device IDs of the entry, regardless of their NUMA node
+func (pde PodDevicesEntry) AllDeviceIDs() []string {
+	var ret []string
+	for _, devIDs := range pde.DeviceIDs {
+		ret = append(ret, devIDs...)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (pde *PodDevicesEntry) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		PodUID        string
+		ContainerName string
+		ResourceName  string
+		DeviceIDs     json.RawMessage
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	pde.PodUID = raw.PodUID
+	pde.ContainerName = raw.ContainerName
+	pde.ResourceName = raw.ResourceName
+	pde.DeviceIDs = make(map[int64][]string)
+	if len(raw.DeviceIDs) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(raw.DeviceIDs, &pde.DeviceIDs); err == nil {
+		return nil
+	}
+	var devIDs []string
+	if err := json.Unmarshal(raw.DeviceIDs, &devIDs); err != nil {
+		return fmt.Errorf("unsupported DeviceIDs format for %s/%s: %w", raw.PodUID, raw.ContainerName, err)
+	}
+	pde.DeviceIDs[-1] = devIDs
+	return nil
+}
+
+// DeviceCheckpoint is the content of the device manager checkpoint file
+type DeviceCheckpoint struct {
+	Data struct {
+		PodDeviceEntries []PodDevicesEntry
+		// RegisteredDevices maps resource name -> device IDs
+		RegisteredDevices map[string][]string
+	}
+	Checksum uint64
+}
+
+// ContainerDevices returns the device entries of a container
+func (dc DeviceCheckpoint) ContainerDevices(podUID, containerName string) []PodDevicesEntry {
+	var ret []PodDevicesEntry
+	for _, entry := range dc.Data.PodDeviceEntries {
+		if entry.PodUID == podUID && entry.ContainerName == containerName {
+			ret = append(ret, entry)
+		}
+	}
+	return ret
+}
+
+func ReadCPUManagerState(r io.Reader) (CPUManagerState, error) {
+	var cms CPUManagerState
+	err := json.NewDecoder(r).Decode(&cms)
+	return cms, err
+}
+
+func ReadMemoryManagerState(r io.Reader) (MemoryManagerState, error) {
+	var mms MemoryManagerState
+	err := json.NewDecoder(r).Decode(&mms)
+	return mms, err
+}
+
+func ReadDeviceCheckpoint(r io.Reader) (DeviceCheckpoint, error) {
+	var dc DeviceCheckpoint
+	err := json.NewDecoder(r).Decode(&dc)
+	return dc, err
+}
+
+// State is the state of all the kubelet resource managers. Any field is nil if the state is not available.
+type State struct {
+	CPUManager    *CPUManagerState
+	MemoryManager *MemoryManagerState
+	Devices       *DeviceCheckpoint
+}
+
+// FromDir reads all the checkpoint files found in the kubelet state directory. Missing files are not an error.
+func FromDir(kubeletDir string) (State, error) {
+	var st State
+	var err error
+
+	err = readFile(filepath.Join(kubeletDir, CPUManagerStateFile), func(r io.Reader) error {
+		cms, err := ReadCPUManagerState(r)
+		st.CPUManager = &cms
+		return err
+	})
+	if err != nil {
+		return st, err
+	}
+
+	err = readFile(filepath.Join(kubeletDir, MemoryManagerStateFile), func(r io.Reader) error {
+		mms, err := ReadMemoryManagerState(r)
+		st.MemoryManager = &mms
+		return err
+	})
+	if err != nil {
+		return st, err
+	}
+
+	err = readFile(filepath.Join(kubeletDir, DeviceCheckpointFile), func(r io.Reader) error {
+		dc, err := ReadDeviceCheckpoint(r)
+		st.Devices = &dc
+		return err
+	})
+	return st, err
+}
+
+func readFile(path string, decode func(r io.Reader) error) error {
+	src, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer src.Close()
+	if err := decode(src); err != nil {
+		return fmt.Errorf("cannot decode %q: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package kubeletstate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const cpuManagerStateData string = `{"policyName":"static","defaultCpuSet":"0,5-7","entries":{"b1c81bdc-1bc5-4d39-a173-b74598538a91":{"cnt":"1-4"}},"checksum":2896574063}`
+
+const memoryManagerStateData string = `{"policyName":"Static","machineState":{"0":{"numberOfAssignments":1,"memoryMap":{"memory":{"total":33554432000,"systemReserved":0,"allocatable":33554432000,"reserved":1073741824,"free":32480690176}},"cells":[0]}},"entries":{"b1c81bdc-1bc5-4d39-a173-b74598538a91":{"cnt":[{"numaAffinity":[0],"type":"memory","size":1073741824},{"numaAffinity":[1],"type":"hugepages-1Gi","size":1073741824}]}},"checksum":1580843012}`
+
+const deviceCheckpointData string = `{"Data":{"PodDeviceEntries":[{"PodUID":"b1c81bdc-1bc5-4d39-a173-b74598538a91","ContainerName":"cnt","ResourceName":"openshift.io/intelnics","DeviceIDs":{"0":["0000:3b:02.2","0000:3b:02.1"]},"AllocResp":"CkQKNlBDSURFVklDRV9PUEVOU0hJRlRfSU9fSU5URUxOSUNT"}],"RegisteredDevices":{"openshift.io/intelnics":["0000:3b:02.1","0000:3b:02.2","0000:3b:02.3"]}},"Checksum":3854436589}`
+
+const deviceCheckpointDataPre120 string = `{"Data":{"PodDeviceEntries":[{"PodUID":"b1c81bdc-1bc5-4d39-a173-b74598538a91","ContainerName":"cnt","ResourceName":"openshift.io/intelnics","DeviceIDs":["0000:3b:02.1"],"AllocResp":""}],"RegisteredDevices":{"openshift.io/intelnics":["0000:3b:02.1"]}},"Checksum":1}`
+
+const podUID string = "b1c81bdc-1bc5-4d39-a173-b74598538a91"
+
+func TestReadCPUManagerState(t *testing.T) {
+	cms, err := ReadCPUManagerState(strings.NewReader(cpuManagerStateData))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defCPUs, err := cms.DefaultCPUs()
+	if err != nil || !cmp.Equal(defCPUs.ToSlice(), []int{0, 5, 6, 7}) {
+		t.Errorf("unexpected default cpus: %v (err=%v)", defCPUs, err)
+	}
+	cpus, found, err := cms.ContainerCPUs(podUID, "cnt")
+	if err != nil || !found || !cmp.Equal(cpus.ToSlice(), []int{1, 2, 3, 4}) {
+		t.Errorf("unexpected container cpus: %v found=%v (err=%v)", cpus, found, err)
+	}
+	if _, found, _ := cms.ContainerCPUs(podUID, "sidecar"); found {
+		t.Errorf("unexpected cpus for sidecar container")
+	}
+}
+
+func TestReadMemoryManagerState(t *testing.T) {
+	mms, err := ReadMemoryManagerState(strings.NewReader(memoryManagerStateData))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nodes, found := mms.ContainerNUMANodes(podUID, "cnt")
+	if !found || !cmp.Equal(nodes.ToSlice(), []int{0, 1}) {
+		t.Errorf("unexpected container NUMA nodes: %v found=%v", nodes, found)
+	}
+}
+
+func TestReadDeviceCheckpoint(t *testing.T) {
+	testCases := []struct {
+		description string
+		data        string
+		expected    map[int64][]string
+	}{
+		{
+			description: "with NUMA information",
+			data:        deviceCheckpointData,
+			expected:    map[int64][]string{0: {"0000:3b:02.2", "0000:3b:02.1"}},
+		},
+		{
+			description: "older than 1.20",
+			data:        deviceCheckpointDataPre120,
+			expected:    map[int64][]string{-1: {"0000:3b:02.1"}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.description, func(t *testing.T) {
+			dc, err := ReadDeviceCheckpoint(strings.NewReader(testCase.data))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			entries := dc.ContainerDevices(podUID, "cnt")
+			if len(entries) != 1 {
+				t.Fatalf("unexpected entries: %v", entries)
+			}
+			if entries[0].ResourceName != "openshift.io/intelnics" {
+				t.Errorf("unexpected resource name: %q", entries[0].ResourceName)
+			}
+			if !cmp.Equal(entries[0].DeviceIDs, testCase.expected) {
+				t.Errorf("unexpected device IDs: %v expected %v", entries[0].DeviceIDs, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestFromDir(t *testing.T) {
+	base, err := ioutil.TempDir("", "kubeletstate")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(base)
+
+	err = ioutil.WriteFile(filepath.Join(base, CPUManagerStateFile), []byte(cpuManagerStateData), 0644)
+	if err != nil {
+		t.Fatalf("error writing the state: %v", err)
+	}
+
+	st, err := FromDir(base)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if st.CPUManager == nil || st.CPUManager.PolicyName != "static" {
+		t.Errorf("unexpected cpu manager state: %v", st.CPUManager)
+	}
+	if st.MemoryManager != nil || st.Devices != nil {
+		t.Errorf("unexpected state from missing files: %v %v", st.MemoryManager, st.Devices)
+	}
+}
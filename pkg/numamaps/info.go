@@ -0,0 +1,185 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numamaps
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+ * keep this handy:
+ * https://www.kernel.org/doc/html/latest/admin-guide/mm/numa_memory_policy.html
+ * man 5 proc, section /proc/[pid]/numa_maps
+ */
+
+// PageCounts reports the resident pages of a given kind, per NUMA node
+type PageCounts struct {
+	// NUMA node -> resident pages
+	Pages map[int]uint64
+	// NUMA node -> resident bytes
+	Bytes map[int]uint64
+}
+
+func newPageCounts() PageCounts {
+	return PageCounts{
+		Pages: make(map[int]uint64),
+		Bytes: make(map[int]uint64),
+	}
+}
+
+func (pc PageCounts) add(node int, pages, pageSize uint64) {
+	pc.Pages[node] += pages
+	pc.Bytes[node] += pages * pageSize
+}
+
+// Info reports where the pages of a process are resident, split by kind
+type Info struct {
+	Anon PageCounts
+	File PageCounts
+	Huge PageCounts
+}
+
+func NewInfo() *Info {
+	return &Info{
+		Anon: newPageCounts(),
+		File: newPageCounts(),
+		Huge: newPageCounts(),
+	}
+}
+
+// Merge adds the counters of another Info to this one
+func (info *Info) Merge(other *Info) {
+	for _, kind := range []struct {
+		dst PageCounts
+		src PageCounts
+	}{
+		{info.Anon, other.Anon},
+		{info.File, other.File},
+		{info.Huge, other.Huge},
+	} {
+		for node, pages := range kind.src.Pages {
+			kind.dst.Pages[node] += pages
+		}
+		for node, size := range kind.src.Bytes {
+			kind.dst.Bytes[node] += size
+		}
+	}
+}
+
+// ResidentBytes returns the resident bytes of all the kinds, per NUMA node
+func (info *Info) ResidentBytes() map[int]uint64 {
+	ret := make(map[int]uint64)
+	for _, pc := range []PageCounts{info.Anon, info.File, info.Huge} {
+		for node, size := range pc.Bytes {
+			ret[node] += size
+		}
+	}
+	return ret
+}
+
+// RemoteRatio returns the share, in the range [0, 1], of the resident bytes not on the given NUMA nodes
+func RemoteRatio(residentBytes map[int]uint64, nodeIDs ...int) float64 {
+	local := make(map[int]bool)
+	for _, nodeID := range nodeIDs {
+		local[nodeID] = true
+	}
+	var total, remote uint64
+	for node, size := range residentBytes {
+		total += size
+		if !local[node] {
+			remote += size
+		}
+	}
+	if total == 0 {
+		return 0.0
+	}
+	return float64(remote) / float64(total)
+}
+
+func FromPID(procfsRoot, pid string) (*Info, error) {
+	src, err := os.Open(filepath.Join(procfsRoot, pid, "numa_maps"))
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	return ReadInfo(src)
+}
+
+// ReadInfo parses data in the /proc/<pid>/numa_maps format
+func ReadInfo(rd io.Reader) (*Info, error) {
+	info := NewInfo()
+	src := bufio.NewScanner(rd)
+	for src.Scan() {
+		// entry format is "address policy key[=value] key[=value]..."
+		items := strings.Fields(src.Text())
+		if len(items) < 2 {
+			continue
+		}
+
+		isHuge := false
+		isFile := false
+		pageSize := uint64(4096) // the kernel always reports it, but let's be safe
+		nodePages := make(map[int]uint64)
+		for _, item := range items[2:] {
+			if item == "huge" {
+				isHuge = true
+				continue
+			}
+			kv := strings.SplitN(item, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch {
+			case kv[0] == "file":
+				isFile = true
+			case kv[0] == "kernelpagesize_kB":
+				v, err := strconv.ParseUint(kv[1], 10, 64)
+				if err != nil {
+					return info, fmt.Errorf("malformed page size %q: %w", kv[1], err)
+				}
+				pageSize = v * 1024
+			case strings.HasPrefix(kv[0], "N"):
+				node, err := strconv.Atoi(kv[0][1:])
+				if err != nil {
+					continue // not a node counter
+				}
+				v, err := strconv.ParseUint(kv[1], 10, 64)
+				if err != nil {
+					return info, fmt.Errorf("malformed page count %q: %w", item, err)
+				}
+				nodePages[node] = v
+			}
+		}
+
+		pc := info.Anon
+		if isHuge {
+			pc = info.Huge
+		} else if isFile {
+			pc = info.File
+		}
+		for node, pages := range nodePages {
+			pc.add(node, pages, pageSize)
+		}
+	}
+	return info, src.Err()
+}
@@ -0,0 +1,84 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numamaps
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const numaMapsData string = `55d0c1a00000 default file=/usr/bin/dpdk-testpmd mapped=300 mapmax=2 N0=200 N1=100 kernelpagesize_kB=4
+55d0c2e5f000 default heap anon=1024 dirty=1024 N1=1024 kernelpagesize_kB=4
+7f5d40000000 bind:1 file=/dev/hugepages/rtemap_0 huge dirty=4 N1=4 kernelpagesize_kB=2048
+7f5d48000000 bind:1 file=/dev/hugepages/rtemap_1 huge dirty=1 N0=1 kernelpagesize_kB=2048
+7ffd1c1f1000 default stack anon=33 dirty=33 N0=1 N1=32 kernelpagesize_kB=4
+7ffd1c3b2000 default
+`
+
+func TestReadInfo(t *testing.T) {
+	info, err := ReadInfo(strings.NewReader(numaMapsData))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !cmp.Equal(info.Anon.Pages, map[int]uint64{0: 1, 1: 1056}) {
+		t.Errorf("anon pages mismatch: %v", info.Anon.Pages)
+	}
+	if !cmp.Equal(info.File.Pages, map[int]uint64{0: 200, 1: 100}) {
+		t.Errorf("file pages mismatch: %v", info.File.Pages)
+	}
+	if !cmp.Equal(info.Huge.Pages, map[int]uint64{0: 1, 1: 4}) {
+		t.Errorf("huge pages mismatch: %v", info.Huge.Pages)
+	}
+	if !cmp.Equal(info.Huge.Bytes, map[int]uint64{0: 2 << 20, 1: 8 << 20}) {
+		t.Errorf("huge bytes mismatch: %v", info.Huge.Bytes)
+	}
+
+	resident := info.ResidentBytes()
+	expected := map[int]uint64{
+		0: 201*4096 + 2<<20,
+		1: 1156*4096 + 8<<20,
+	}
+	if !cmp.Equal(resident, expected) {
+		t.Errorf("resident bytes mismatch: got %v expected %v", resident, expected)
+	}
+}
+
+func TestReadInfoMalformed(t *testing.T) {
+	_, err := ReadInfo(strings.NewReader("7ffd1c1f1000 default anon=1 N0=foo kernelpagesize_kB=4\n"))
+	if err == nil {
+		t.Errorf("malformed data parsed without errors")
+	}
+}
+
+func TestRemoteRatio(t *testing.T) {
+	resident := map[int]uint64{0: 25, 1: 75}
+	if ratio := RemoteRatio(resident, 1); ratio != 0.25 {
+		t.Errorf("unexpected remote ratio for node 1: %v", ratio)
+	}
+	if ratio := RemoteRatio(resident, 0); ratio != 0.75 {
+		t.Errorf("unexpected remote ratio for node 0: %v", ratio)
+	}
+	if ratio := RemoteRatio(resident, 0, 1); ratio != 0.0 {
+		t.Errorf("unexpected remote ratio for nodes 0-1: %v", ratio)
+	}
+	if ratio := RemoteRatio(map[int]uint64{}, 0); ratio != 0.0 {
+		t.Errorf("unexpected remote ratio for no memory: %v", ratio)
+	}
+}
@@ -0,0 +1,225 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+// Package pciids parses the pci.ids database, to translate the PCI vendor, device and class identifiers in names.
+package pciids
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed" // for the fallback database
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultPaths are the locations the pci.ids database is installed to by the most common distributions
+var DefaultPaths = []string{
+	"/usr/share/hwdata/pci.ids",
+	"/usr/share/misc/pci.ids",
+	"/usr/share/pci.ids",
+}
+
+//go:embed pci.ids
+var embeddedIDs []byte
+
+type subsystemID struct {
+	vendor int64
+	device int64
+}
+
+type device struct {
+	name       string
+	subsystems map[subsystemID]string
+}
+
+type vendor struct {
+	name    string
+	devices map[int64]*device
+}
+
+type class struct {
+	name       string
+	subclasses map[int64]string
+}
+
+// DB is a parsed pci.ids database
+type DB struct {
+	vendors map[int64]*vendor
+	classes map[int64]*class
+}
+
+// Load parses the pci.ids database at path
+func Load(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// LoadDefault parses the first pci.ids database found in DefaultPaths,
+// falling back to the embedded copy, which only knows about the most common devices.
+func LoadDefault() (*DB, error) {
+	for _, path := range DefaultPaths {
+		db, err := Load(path)
+		if err == nil {
+			return db, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return Embedded()
+}
+
+// Embedded parses the embedded pci.ids database
+func Embedded() (*DB, error) {
+	return Parse(bytes.NewReader(embeddedIDs))
+}
+
+// Parse reads a database in the pci.ids format
+func Parse(r io.Reader) (*DB, error) {
+	db := &DB{
+		vendors: make(map[int64]*vendor),
+		classes: make(map[int64]*class),
+	}
+
+	var curVendor *vendor
+	var curDevice *device
+	var curClass *class
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		depth := len(line) - len(strings.TrimLeft(line, "\t"))
+		line = line[depth:]
+
+		switch {
+		case depth == 0 && strings.HasPrefix(line, "C "):
+			id, name, err := parseEntry(strings.TrimPrefix(line, "C "), 2)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			curVendor, curDevice = nil, nil
+			curClass = &class{name: name, subclasses: make(map[int64]string)}
+			db.classes[id] = curClass
+
+		case depth == 0:
+			id, name, err := parseEntry(line, 4)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			curClass, curDevice = nil, nil
+			curVendor = &vendor{name: name, devices: make(map[int64]*device)}
+			db.vendors[id] = curVendor
+
+		case depth == 1 && curVendor != nil:
+			id, name, err := parseEntry(line, 4)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			curDevice = &device{name: name, subsystems: make(map[subsystemID]string)}
+			curVendor.devices[id] = curDevice
+
+		case depth == 1 && curClass != nil:
+			id, name, err := parseEntry(line, 2)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			curClass.subclasses[id] = name
+
+		case depth == 2 && curDevice != nil:
+			subVendor, rest, err := parseEntry(line, 4)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			subDevice, name, err := parseEntry(rest, 4)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			curDevice.subsystems[subsystemID{vendor: subVendor, device: subDevice}] = name
+
+		default:
+			// programming interfaces: not reported by sysfs, we don't need them
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// parseEntry splits a "<hex id>  <name>" entry, where the id has exactly digits hex digits
+func parseEntry(line string, digits int) (int64, string, error) {
+	if len(line) < digits || (len(line) > digits && line[digits] != ' ') {
+		return 0, "", fmt.Errorf("malformed entry %q", line)
+	}
+	id, err := strconv.ParseInt(line[:digits], 16, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("malformed id in %q: %w", line, err)
+	}
+	return id, strings.TrimSpace(line[digits:]), nil
+}
+
+// Vendor returns the name of a vendor, empty if unknown
+func (db *DB) Vendor(vendorID int64) string {
+	if v, ok := db.vendors[vendorID]; ok {
+		return v.name
+	}
+	return ""
+}
+
+// Device returns the name of a device, empty if unknown
+func (db *DB) Device(vendorID, deviceID int64) string {
+	if v, ok := db.vendors[vendorID]; ok {
+		if d, ok := v.devices[deviceID]; ok {
+			return d.name
+		}
+	}
+	return ""
+}
+
+// Subsystem returns the name of the subsystem (the board or the card) of a device, empty if unknown
+func (db *DB) Subsystem(vendorID, deviceID, subVendorID, subDeviceID int64) string {
+	if v, ok := db.vendors[vendorID]; ok {
+		if d, ok := v.devices[deviceID]; ok {
+			return d.subsystems[subsystemID{vendor: subVendorID, device: subDeviceID}]
+		}
+	}
+	return ""
+}
+
+// Class returns the name of a device class, as the 16 bit class and subclass (e.g. 0x0200).
+// Returns the name of the base class if the subclass is unknown, empty if the class is unknown.
+func (db *DB) Class(classID int64) string {
+	c, ok := db.classes[classID>>8]
+	if !ok {
+		return ""
+	}
+	if name, ok := c.subclasses[classID&0xff]; ok {
+		return name
+	}
+	return c.name
+}
@@ -0,0 +1,102 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package pciids
+
+import (
+	"strings"
+	"testing"
+)
+
+const testIDs = `#
+#	List of PCI ID's
+#
+8086  Intel Corporation
+	1521  I350 Gigabit Network Connection
+		8086 0001  Ethernet Server Adapter I350-T4
+		8086 00a1  Ethernet Server Adapter I350-T2
+	158b  Ethernet Controller XXV710 for 25GbE SFP28
+15b3  Mellanox Technologies
+	1017  MT27800 Family [ConnectX-5]
+
+# List of known device classes, subclasses and programming interfaces
+C 01  Mass storage controller
+	08  Non-Volatile memory controller
+		02  NVM Express
+C 02  Network controller
+	00  Ethernet controller
+	07  Infiniband controller
+C 12  Processing accelerators
+`
+
+func TestParse(t *testing.T) {
+	db, err := Parse(strings.NewReader(testIDs))
+	if err != nil {
+		t.Fatalf("error parsing: %v", err)
+	}
+
+	type testCase struct {
+		got      string
+		expected string
+	}
+	for _, tc := range []testCase{
+		{db.Vendor(0x8086), "Intel Corporation"},
+		{db.Vendor(0x10ec), ""},
+		{db.Device(0x8086, 0x158b), "Ethernet Controller XXV710 for 25GbE SFP28"},
+		{db.Device(0x15b3, 0x1017), "MT27800 Family [ConnectX-5]"},
+		{db.Device(0x15b3, 0x1018), ""},
+		{db.Subsystem(0x8086, 0x1521, 0x8086, 0x00a1), "Ethernet Server Adapter I350-T2"},
+		{db.Subsystem(0x8086, 0x1521, 0x8086, 0x0002), ""},
+		{db.Class(0x0200), "Ethernet controller"},
+		{db.Class(0x0108), "Non-Volatile memory controller"},
+		{db.Class(0x1200), "Processing accelerators"},
+		{db.Class(0x0280), "Network controller"},
+		{db.Class(0x0b40), ""},
+	} {
+		if tc.got != tc.expected {
+			t.Errorf("got %q expected %q", tc.got, tc.expected)
+		}
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, data := range []string{
+		"808  Intel Corporation\n",
+		"8086  Intel Corporation\n\tzzzz  Bogus device\n",
+		"C 2  Network controller\n",
+	} {
+		if _, err := Parse(strings.NewReader(data)); err == nil {
+			t.Errorf("malformed data %q parsed without errors", data)
+		}
+	}
+}
+
+func TestEmbedded(t *testing.T) {
+	db, err := Embedded()
+	if err != nil {
+		t.Fatalf("error parsing the embedded database: %v", err)
+	}
+	if name := db.Device(0x1af4, 0x1041); name != "Virtio 1.0 network device" {
+		t.Errorf("unexpected device name: %q", name)
+	}
+	if name := db.Class(0x0207); name != "Infiniband controller" {
+		t.Errorf("unexpected class name: %q", name)
+	}
+	// the subset is redistributed under the upstream terms, which must ship with it
+	if !strings.Contains(string(embeddedIDs), "#\tThis file can be distributed under either the GNU General Public License\n#\t(version 2 or higher) or the 3-clause BSD License.\n") {
+		t.Errorf("the embedded database misses the upstream licensing notice")
+	}
+}
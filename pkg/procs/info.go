@@ -29,7 +29,11 @@ import (
 )
 
 type Info struct {
-	Pid      int32
+	// Pid is the thread id when reading the status of a task
+	Pid int32
+	// Tgid is the thread group id, IOW the pid of the process the thread belongs to
+	Tgid     int32
+	Name     string
 	Affinity []int
 }
 
@@ -61,6 +65,33 @@ func FromPID(procfsRoot string, pid int32) (Info, error) {
 	return parseProcStatus(filepath.Join(procfsRoot, fmt.Sprintf("%d", pid), "status"))
 }
 
+// Threads returns the informations about all the threads of a process, reading <procfs>/<pid>/task/*/status
+func Threads(procfsRoot, pid string) ([]Info, error) {
+	infos := []Info{}
+	taskRoot := filepath.Join(procfsRoot, pid, "task")
+	entries, err := ioutil.ReadDir(taskRoot)
+	if err != nil {
+		return infos, err
+	}
+
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			// doesn't look like a tid
+			continue
+		}
+		info, err := parseProcStatus(filepath.Join(taskRoot, entry.Name(), "status"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				// thread exited meanwhile
+				continue
+			}
+			return infos, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
 func parseProcStatus(path string) (Info, error) {
 	info := Info{}
 	file, err := os.Open(path)
@@ -80,6 +111,18 @@ func parseProcStatus(path string) (Info, error) {
 			}
 			info.Pid = int32(pid)
 		}
+		if strings.HasPrefix(line, "Tgid:") {
+			items := strings.SplitN(line, ":", 2)
+			tgid, err := strconv.Atoi(strings.TrimSpace(items[1]))
+			if err != nil {
+				return info, err
+			}
+			info.Tgid = int32(tgid)
+		}
+		if strings.HasPrefix(line, "Name:") {
+			items := strings.SplitN(line, ":", 2)
+			info.Name = strings.TrimSpace(items[1])
+		}
 		if strings.HasPrefix(line, "Cpus_allowed_list:") {
 			items := strings.SplitN(line, ":", 2)
 			cpuIDs, err := cpuset.Parse(strings.TrimSpace(items[1]))
@@ -0,0 +1,539 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+// Package snapshot captures the sysfs and procfs files the tools read into a single archive,
+// and sets up a tree from an archive the tools can run against.
+package snapshot
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// SysDir is the directory holding the sysfs content in the archive
+	SysDir = "sys"
+	// ProcDir is the directory holding the procfs content in the archive
+	ProcDir = "proc"
+)
+
+// sysLinks are symlinks recorded as they are
+var sysLinks = []string{
+	"bus/pci/devices/*",
+	"class/net/*",
+	"devices/system/node/node*/cpu[0-9]*",
+	"devices/system/cpu/cpu[0-9]*/node*",
+	"block/*",
+	"dev/block/*",
+	"dev/char/*",
+	"class/infiniband/*",
+	"class/infiniband_verbs/*",
+}
+
+// sysAttrs are recorded at their real location, resolving the symlinks in their path
+var sysAttrs = []string{
+	"devices/system/node/online",
+	"devices/system/node/possible",
+	"devices/system/node/has_cpu",
+	"devices/system/node/has_memory",
+	"devices/system/node/has_normal_memory",
+	"devices/system/node/node*/cpulist",
+	"devices/system/node/node*/cpumap",
+	"devices/system/node/node*/distance",
+	"devices/system/node/node*/meminfo",
+	"devices/system/node/node*/hugepages/hugepages-*/*",
+	"devices/system/cpu/present",
+	"devices/system/cpu/online",
+	"devices/system/cpu/possible",
+	"devices/system/cpu/isolated",
+	"devices/system/cpu/cpu[0-9]*/topology/*",
+	"class/net/*/device",
+	"class/net/*/operstate",
+	"block/*/dev",
+	"block/*/size",
+	"block/*/*/dev",
+	"class/infiniband/*/device",
+	"class/infiniband/*/ports/*/state",
+	"class/infiniband/*/ports/*/link_layer",
+	"class/infiniband/*/ports/*/rate",
+	"class/infiniband_verbs/*/device",
+	"class/infiniband_verbs/*/ibdev",
+}
+
+// pciDevAttrs are recorded for each device in bus/pci/devices
+var pciDevAttrs = []string{
+	"numa_node",
+	"class",
+	"vendor",
+	"device",
+	"subsystem_vendor",
+	"subsystem_device",
+	"revision",
+	"sriov_numvfs",
+	"sriov_totalvfs",
+	"local_cpulist",
+	"local_cpus",
+	"irq",
+	"current_link_speed",
+	"current_link_width",
+	"max_link_speed",
+	"max_link_width",
+	"physfn",
+	"virtfn*",
+	"driver",
+	"iommu_group",
+	"msi_irqs/*",
+	"net/*",
+	"infiniband/*",
+	"infiniband_verbs/*",
+	"virtio*/net/*",
+}
+
+// cgroupAttrs are recorded for each cpuset cgroup
+var cgroupAttrs = []string{
+	"cpuset.cpus",
+	"cpuset.mems",
+	"cpuset.effective_cpus",
+	"cpuset.effective_mems",
+}
+
+var procLinks = []string{
+	"self",
+}
+
+var procAttrs = []string{
+	"softirqs",
+	"interrupts",
+	"meminfo",
+	"irq/default_smp_affinity",
+	"irq/*/*",
+	"[0-9]*/status",
+	"[0-9]*/cgroup",
+	"[0-9]*/numa_maps",
+	"[0-9]*/task/[0-9]*/status",
+}
+
+type collector struct {
+	tw   *tar.Writer
+	seen map[string]bool
+}
+
+// Capture writes into w a gzipped tarball holding all the sysfs and procfs files the tools use.
+// Files which can't be read, like the attributes requiring privileges, are skipped.
+// The process environments are never captured.
+func Capture(w io.Writer, sysfsRoot, procfsRoot string) error {
+	gzw := gzip.NewWriter(w)
+	c := collector{
+		tw:   tar.NewWriter(gzw),
+		seen: make(map[string]bool),
+	}
+
+	if err := c.addLinks(sysfsRoot, SysDir, sysLinks); err != nil {
+		return err
+	}
+	if err := c.addAttrs(sysfsRoot, SysDir, sysAttrs); err != nil {
+		return err
+	}
+	var devAttrs []string
+	for _, attr := range pciDevAttrs {
+		devAttrs = append(devAttrs, filepath.Join("bus", "pci", "devices", "*", attr))
+	}
+	if err := c.addAttrs(sysfsRoot, SysDir, devAttrs); err != nil {
+		return err
+	}
+	if err := c.addCGroups(sysfsRoot, SysDir); err != nil {
+		return err
+	}
+
+	if err := c.addLinks(procfsRoot, ProcDir, procLinks); err != nil {
+		return err
+	}
+	if err := c.addAttrs(procfsRoot, ProcDir, procAttrs); err != nil {
+		return err
+	}
+
+	if err := c.tw.Close(); err != nil {
+		return err
+	}
+	return gzw.Close()
+}
+
+func (c *collector) addLinks(root, prefix string, patterns []string) error {
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(root, pattern))
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			rel, err := filepath.Rel(root, match)
+			if err != nil {
+				return err
+			}
+			if err := c.add(match, filepath.Join(prefix, rel)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *collector) addAttrs(root, prefix string, patterns []string) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(root, pattern))
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			// resolve the parent only: attributes may be symlinks (e.g. physfn)
+			dir, err := filepath.EvalSymlinks(filepath.Dir(match))
+			if err != nil {
+				// raced with a process exiting
+				continue
+			}
+			rel, err := filepath.Rel(realRoot, dir)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				// points outside the tree, like /proc/<pid>/cwd
+				continue
+			}
+			if err := c.add(match, filepath.Join(prefix, rel, filepath.Base(match))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *collector) addCGroups(sysfsRoot, prefix string) error {
+	cpusetRoot := filepath.Join(sysfsRoot, "fs", "cgroup", "cpuset")
+	if _, err := os.Stat(cpusetRoot); err != nil {
+		// cgroups v2 or not mounted
+		return nil
+	}
+	return filepath.Walk(cpusetRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(sysfsRoot, path)
+		if err != nil {
+			return err
+		}
+		for _, attr := range cgroupAttrs {
+			src := filepath.Join(path, attr)
+			if _, err := os.Lstat(src); err != nil {
+				continue
+			}
+			if err := c.add(src, filepath.Join(prefix, rel, attr)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// add records the host path under the given name, and all the parent directories
+func (c *collector) add(hostPath, name string) error {
+	if c.seen[name] {
+		return nil
+	}
+	info, err := os.Lstat(hostPath)
+	if err != nil {
+		// raced with a process exiting
+		return nil
+	}
+
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    int64(info.Mode().Perm()),
+		ModTime: info.ModTime(),
+	}
+	var data []byte
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(hostPath)
+		if err != nil {
+			return nil
+		}
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = target
+	case info.IsDir():
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+	default:
+		// sysfs and procfs report fake sizes, so we need to read the content upfront
+		data, err = ioutil.ReadFile(hostPath)
+		if err != nil {
+			// write-only or requiring privileges
+			return nil
+		}
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = int64(len(data))
+	}
+
+	if err := c.addParents(name); err != nil {
+		return err
+	}
+	if err := c.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if data != nil {
+		if _, err := c.tw.Write(data); err != nil {
+			return err
+		}
+	}
+	c.seen[name] = true
+	return nil
+}
+
+func (c *collector) addParents(name string) error {
+	var parents []string
+	for dir := filepath.Dir(name); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		if c.seen[dir] {
+			break
+		}
+		parents = append(parents, dir)
+	}
+	sort.Strings(parents) // shortest first
+	for _, dir := range parents {
+		err := c.tw.WriteHeader(&tar.Header{
+			Name:     dir + "/",
+			Typeflag: tar.TypeDir,
+			Mode:     0755,
+		})
+		if err != nil {
+			return err
+		}
+		c.seen[dir] = true
+	}
+	return nil
+}
+
+// Snapshot is a tree set up from an archive
+type Snapshot struct {
+	// Root is the directory the archive was extracted into
+	Root string
+}
+
+func (s *Snapshot) SysFSRoot() string {
+	return filepath.Join(s.Root, SysDir)
+}
+
+func (s *Snapshot) ProcFSRoot() string {
+	return filepath.Join(s.Root, ProcDir)
+}
+
+func (s *Snapshot) CGroupsRoot() string {
+	return filepath.Join(s.Root, SysDir, "fs", "cgroup")
+}
+
+// Cleanup removes the extracted tree
+func (s *Snapshot) Cleanup() error {
+	return os.RemoveAll(s.Root)
+}
+
+// Open extracts the archive at path into a temporary directory.
+// The caller should call Cleanup once done.
+func Open(path string) (*Snapshot, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	root, err := ioutil.TempDir("", "numalign-snapshot")
+	if err != nil {
+		return nil, err
+	}
+	snap := &Snapshot{Root: root}
+	if err := Extract(src, root); err != nil {
+		snap.Cleanup()
+		return nil, fmt.Errorf("cannot extract %q: %w", path, err)
+	}
+	return snap, nil
+}
+
+// Env is the system state a tool runs against: the live system or an extracted snapshot
+type Env struct {
+	SysFSRoot   string
+	ProcFSRoot  string
+	CGroupsRoot string
+
+	snap *Snapshot
+}
+
+// OpenEnv returns the live environment if path is empty, the snapshot at path otherwise.
+// The caller should call Cleanup once done, and Exit instead of os.Exit.
+func OpenEnv(path string, live Env) (*Env, error) {
+	if path == "" {
+		return &live, nil
+	}
+	snap, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Env{
+		SysFSRoot:   snap.SysFSRoot(),
+		ProcFSRoot:  snap.ProcFSRoot(),
+		CGroupsRoot: snap.CGroupsRoot(),
+		snap:        snap,
+	}, nil
+}
+
+// Cleanup removes the extracted snapshot, if any
+func (e *Env) Cleanup() {
+	if e.snap != nil {
+		e.snap.Cleanup()
+	}
+}
+
+// Exit removes the extracted snapshot, if any, and exits: os.Exit skips the deferred calls
+func (e *Env) Exit(code int) {
+	e.Cleanup()
+	os.Exit(code)
+}
+
+// Extract extracts the archive read from r into the dest directory, which must exist
+func Extract(r io.Reader, dest string) error {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gzr.Close()
+
+	realDest, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return checkLinks(realDest)
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(hdr.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("unsafe path in archive: %q", hdr.Name)
+		}
+		path := filepath.Join(realDest, name)
+
+		// the parents may be symlinks extracted before: never write outside dest
+		if err := checkAncestor(realDest, path); err != nil {
+			return fmt.Errorf("unsafe path in archive: %q: %w", hdr.Name, err)
+		}
+
+		if hdr.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if !isInside(realDest, parent) {
+			return fmt.Errorf("unsafe path in archive: %q", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			// sysfs links are relative and stay in the tree, like ../../../devices/pci0000:00/0000:00:03.0
+			if filepath.IsAbs(hdr.Linkname) || !isInside(realDest, filepath.Join(parent, hdr.Linkname)) {
+				return fmt.Errorf("unsafe link in archive: %q -> %q", hdr.Name, hdr.Linkname)
+			}
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			// O_EXCL fails on any existing entry, including symlinks, which are never followed
+			dst, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(dst, tr)
+			dst.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			// never generated by Capture
+			return fmt.Errorf("unsupported entry %q type %v", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+// checkAncestor fails if path, or its nearest existing ancestor, resolves outside realDest
+func checkAncestor(realDest, path string) error {
+	for dir := path; ; dir = filepath.Dir(dir) {
+		realDir, err := filepath.EvalSymlinks(dir)
+		if os.IsNotExist(err) && dir != realDest {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !isInside(realDest, realDir) {
+			return fmt.Errorf("%q resolves outside the tree", dir)
+		}
+		return nil
+	}
+}
+
+// checkLinks fails if any symlink in realDest resolves outside it. The links are checked as they are
+// extracted, but a chain like a -> . and b -> a/../.. escapes only once resolved.
+func checkLinks(realDest string) error {
+	return filepath.Walk(realDest, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			// dangling, like the driver links of a partial capture: still read with readlink
+			return nil
+		}
+		if !isInside(realDest, target) {
+			return fmt.Errorf("unsafe link in archive: %q resolves outside the tree", path)
+		}
+		return nil
+	})
+}
+
+func isInside(realDest, path string) bool {
+	rel, err := filepath.Rel(realDest, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
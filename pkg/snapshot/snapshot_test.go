@@ -0,0 +1,336 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ffromani/numalign/pkg/topologyinfo/numa"
+	"github.com/ffromani/numalign/pkg/topologyinfo/numa/distances"
+	fakesysfs "github.com/ffromani/numalign/pkg/topologyinfo/sysfs/fake"
+)
+
+func TestCaptureExtract(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+	t.Logf("sysfs at %q", fs.Base())
+
+	devNode := fs.AddTree("sys", "devices", "system", "node")
+	devNode.Add("node0", map[string]string{
+		"cpulist":  "0-3\n",
+		"distance": "10\n",
+	})
+	pciRoot := fs.AddTree("sys", "devices", "pci0000:00")
+	pciRoot.Add("0000:00:03.0", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node": "0",
+		"class":     "0x020000",
+		"vendor":    "0x1af4",
+		"device":    "0x1000",
+		"remove":    "", // write only on a real sysfs, must not be captured
+	}))
+	fs.AddTree("sys", "bus", "pci", "devices").AddLink("0000:00:03.0", "../../../devices/pci0000:00/0000:00:03.0")
+	fs.AddTree("proc").Add("42", map[string]string{
+		"status":  "Pid:\t42\n",
+		"environ": "SECRET=1",
+	}).Add("task", nil).Add("42", map[string]string{
+		"status": "Pid:\t42\n",
+	})
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	var buf bytes.Buffer
+	err = Capture(&buf, filepath.Join(fs.Base(), "sys"), filepath.Join(fs.Base(), "proc"))
+	if err != nil {
+		t.Fatalf("unexpected capture error: %v", err)
+	}
+
+	names := make(map[string]bool)
+	gzr, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tr := tar.NewReader(gzr)
+	for hdr, err := tr.Next(); err == nil; hdr, err = tr.Next() {
+		names[hdr.Name] = true
+	}
+	for _, name := range []string{"proc/42/environ", "sys/devices/pci0000:00/0000:00:03.0/remove"} {
+		if names[name] {
+			t.Errorf("unexpected entry %q", name)
+		}
+	}
+
+	dest, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dest)
+
+	err = Extract(bytes.NewReader(buf.Bytes()), dest)
+	if err != nil {
+		t.Fatalf("unexpected extract error: %v", err)
+	}
+
+	expected := map[string]string{
+		"sys/devices/system/node/node0/cpulist":  "0-3\n",
+		"sys/bus/pci/devices/0000:00:03.0/class": "0x020000\n",
+		"proc/42/task/42/status":                 "Pid:\t42\n",
+	}
+	for name, content := range expected {
+		data, err := ioutil.ReadFile(filepath.Join(dest, name))
+		if err != nil {
+			t.Errorf("missing %q: %v", name, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("unexpected content for %q: %q expected %q", name, data, content)
+		}
+	}
+}
+
+func TestCaptureOpenNUMA(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+	t.Logf("sysfs at %q", fs.Base())
+
+	devNode := fs.AddTree("sys", "devices", "system").Add("node", map[string]string{
+		"online":            "0-1\n",
+		"possible":          "0-1\n",
+		"has_cpu":           "0-1\n",
+		"has_memory":        "0-1\n",
+		"has_normal_memory": "0-1\n",
+	})
+	devNode.Add("node0", map[string]string{
+		"cpulist":  "0-1\n",
+		"distance": "10 21\n",
+	})
+	devNode.Add("node1", map[string]string{
+		"cpulist":  "2-3\n",
+		"distance": "21 10\n",
+	})
+	fs.AddTree("proc")
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	snapPath := filepath.Join(fs.Base(), "host.tar.gz")
+	out, err := os.Create(snapPath)
+	if err != nil {
+		t.Fatalf("error creating the snapshot file: %v", err)
+	}
+	err = Capture(out, filepath.Join(fs.Base(), "sys"), filepath.Join(fs.Base(), "proc"))
+	out.Close()
+	if err != nil {
+		t.Fatalf("unexpected capture error: %v", err)
+	}
+
+	snap, err := Open(snapPath)
+	if err != nil {
+		t.Fatalf("unexpected open error: %v", err)
+	}
+	defer snap.Cleanup()
+
+	nodes, err := numa.NewNodesFromSysFS(snap.SysFSRoot())
+	if err != nil {
+		t.Fatalf("unexpected error reading the NUMA nodes: %v", err)
+	}
+	if !cmp.Equal(nodes.Online, []int{0, 1}) || !cmp.Equal(nodes.WithMemory, []int{0, 1}) {
+		t.Errorf("unexpected NUMA nodes: %+v", nodes)
+	}
+
+	dists, err := distances.NewDistancesFromSysfs(snap.SysFSRoot())
+	if err != nil {
+		t.Fatalf("unexpected error reading the NUMA distances: %v", err)
+	}
+	if dist, err := dists.BetweenNodes(0, 1); err != nil || dist != 21 {
+		t.Errorf("unexpected distance between nodes 0 and 1: %d (%v)", dist, err)
+	}
+
+	env, err := OpenEnv(snapPath, Env{})
+	if err != nil {
+		t.Fatalf("unexpected error opening the snapshot environment: %v", err)
+	}
+	if _, err := numa.NewNodesFromSysFS(env.SysFSRoot); err != nil {
+		t.Errorf("unexpected error reading the NUMA nodes from the environment: %v", err)
+	}
+	env.Cleanup()
+	if _, err := os.Stat(env.SysFSRoot); !os.IsNotExist(err) {
+		t.Errorf("snapshot environment not removed: %v", err)
+	}
+}
+
+func TestOpenEnv(t *testing.T) {
+	live := Env{ProcFSRoot: "/host/proc"}
+	env, err := OpenEnv("", live)
+	if err != nil {
+		t.Fatalf("unexpected error opening the live environment: %v", err)
+	}
+	if env.ProcFSRoot != "/host/proc" || env.SysFSRoot != "" {
+		t.Errorf("unexpected live environment: %+v", env)
+	}
+	env.Cleanup()
+
+	if _, err := OpenEnv("/does/not/exist.tar.gz", live); err == nil {
+		t.Errorf("opened a missing snapshot")
+	}
+}
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+func makeArchive(t *testing.T, entries []tarEntry) []byte {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	for _, entry := range entries {
+		hdr := &tar.Header{
+			Name:     entry.name,
+			Typeflag: entry.typeflag,
+			Linkname: entry.linkname,
+			Mode:     0644,
+			Size:     int64(len(entry.content)),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("error writing %q: %v", entry.name, err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatalf("error writing %q: %v", entry.name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("error closing the archive: %v", err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatalf("error closing the archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractUnsafe(t *testing.T) {
+	victimDir, err := ioutil.TempDir("", "victim")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(victimDir)
+	victim := filepath.Join(victimDir, "victim")
+	if err := ioutil.WriteFile(victim, []byte("safe"), 0644); err != nil {
+		t.Fatalf("error writing the victim file: %v", err)
+	}
+
+	type tcase struct {
+		name    string
+		entries []tarEntry
+	}
+	for _, tc := range []tcase{
+		{
+			name: "absolute link, then a file through it",
+			entries: []tarEntry{
+				{name: "sys/evil", typeflag: tar.TypeSymlink, linkname: victim},
+				{name: "sys/evil", typeflag: tar.TypeReg, content: "owned"},
+			},
+		},
+		{
+			name: "relative link out of the tree",
+			entries: []tarEntry{
+				{name: "sys/evil", typeflag: tar.TypeSymlink, linkname: "../../../../../../../../.." + victim},
+				{name: "sys/evil", typeflag: tar.TypeReg, content: "owned"},
+			},
+		},
+		{
+			name: "link chain out of the tree",
+			entries: []tarEntry{
+				{name: "sys/a", typeflag: tar.TypeSymlink, linkname: "."},
+				{name: "sys/b", typeflag: tar.TypeSymlink, linkname: "a/../.."},
+				{name: "sys/b/victim", typeflag: tar.TypeReg, content: "owned"},
+			},
+		},
+		{
+			name: "file over a link in the tree",
+			entries: []tarEntry{
+				{name: "sys/target", typeflag: tar.TypeReg, content: "data"},
+				{name: "sys/link", typeflag: tar.TypeSymlink, linkname: "target"},
+				{name: "sys/link", typeflag: tar.TypeReg, content: "owned"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dest, err := ioutil.TempDir(victimDir, "snapshot")
+			if err != nil {
+				t.Fatalf("error creating temp dir: %v", err)
+			}
+			defer os.RemoveAll(dest)
+
+			if err := Extract(bytes.NewReader(makeArchive(t, tc.entries)), dest); err == nil {
+				t.Errorf("extracted an unsafe archive")
+			}
+			if data, err := ioutil.ReadFile(victim); err != nil || string(data) != "safe" {
+				t.Errorf("victim file changed: %q (%v)", data, err)
+			}
+			if data, err := ioutil.ReadFile(filepath.Join(dest, "sys", "target")); err == nil && string(data) != "data" {
+				t.Errorf("file in the tree changed: %q", data)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+// Package block maps the block devices, like NVMe namespaces, virtio or SCSI disks, to the PCI functions backing them.
+package block
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
+)
+
+const (
+	// PathBlock is the subpath which holds the block devices
+	PathBlock = "block"
+)
+
+var nvmeCtrlRe = regexp.MustCompile(`^nvme[0-9]+$`)
+
+const (
+	KindNVMe    = "nvme"
+	KindVirtio  = "virtio"
+	KindSCSI    = "scsi"
+	KindUnknown = "unknown"
+)
+
+// BlockDeviceInfo is a block device backed by a PCI function
+type BlockDeviceInfo struct {
+	// Name is the kernel name, like "nvme0n1"
+	Name string
+	// Kind tells how the device is attached, like KindNVMe
+	Kind  string
+	Major uint32
+	Minor uint32
+	// SizeBytes is the capacity of the device
+	SizeBytes int64
+	// Address is the FULL PCI address of the function backing the device
+	Address string
+	// NUMANode is the NUMA node of the PCI function. May be pcidev.NUMANodeUnknown.
+	NUMANode  int
+	sysfsPath string
+}
+
+// SysfsPath returns the resolved path on sysfs of this device
+func (bdi BlockDeviceInfo) SysfsPath() string {
+	return bdi.sysfsPath
+}
+
+func (bdi BlockDeviceInfo) String() string {
+	return fmt.Sprintf("%s (%s %d:%d) pci@%s numa_node=%d", bdi.Name, bdi.Kind, bdi.Major, bdi.Minor, bdi.Address, bdi.NUMANode)
+}
+
+// BlockDevices reports the block devices backed by PCI functions found in the system
+type BlockDevices struct {
+	Items   []BlockDeviceInfo
+	pciDevs *pcidev.PCIDevices
+}
+
+// NewBlockDevices extracts the information about the block devices from a given sysfs-like path.
+// Virtual devices, like loop or device-mapper, are skipped: see FindBacking.
+func NewBlockDevices(sysfs string) (*BlockDevices, error) {
+	pciDevs, err := pcidev.NewPCIDevices(sysfs)
+	if err != nil {
+		return nil, err
+	}
+
+	blockPath := filepath.Join(sysfs, PathBlock)
+	entries, err := ioutil.ReadDir(blockPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []BlockDeviceInfo
+	for _, entry := range entries {
+		devPath, err := filepath.EvalSymlinks(filepath.Join(blockPath, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		pciInfo, err := pciDevs.FindByPath(devPath)
+		if err != nil {
+			continue
+		}
+		bdi, err := newBlockDeviceInfo(entry.Name(), devPath, pciInfo)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, bdi)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return &BlockDevices{
+		Items:   items,
+		pciDevs: pciDevs,
+	}, nil
+}
+
+func newBlockDeviceInfo(name, devPath string, pciInfo pcidev.PCIDeviceInfo) (BlockDeviceInfo, error) {
+	major, minor, err := readDevNumber(filepath.Join(devPath, "dev"))
+	if err != nil {
+		return BlockDeviceInfo{}, err
+	}
+	// always in 512-byte sectors, regardless of the device block size
+	sectors, err := readInt64(filepath.Join(devPath, "size"))
+	if err != nil {
+		return BlockDeviceInfo{}, err
+	}
+	return BlockDeviceInfo{
+		Name:      name,
+		Kind:      kindFromPath(devPath),
+		Major:     major,
+		Minor:     minor,
+		SizeBytes: sectors * 512,
+		Address:   pciInfo.Address(),
+		NUMANode:  pciInfo.NUMANode(),
+		sysfsPath: devPath,
+	}, nil
+}
+
+// PerNUMA groups the block devices by the NUMA node of their PCI function
+func (bd BlockDevices) PerNUMA() map[int][]BlockDeviceInfo {
+	ret := make(map[int][]BlockDeviceInfo)
+	for _, bdi := range bd.Items {
+		ret[bdi.NUMANode] = append(ret[bdi.NUMANode], bdi)
+	}
+	return ret
+}
+
+// FindByName returns the block device with the given kernel name, like "nvme0n1"
+func (bd BlockDevices) FindByName(name string) (BlockDeviceInfo, bool) {
+	for _, bdi := range bd.Items {
+		if bdi.Name == name {
+			return bdi, true
+		}
+	}
+	return BlockDeviceInfo{}, false
+}
+
+// FindBacking returns the block devices backed by PCI functions which the block device with the given kernel name,
+// like "nvme0n1" or "dm-0", is on: see FindByDevNumber.
+func (bd BlockDevices) FindBacking(sysfs, name string) ([]BlockDeviceInfo, error) {
+	realPath, err := filepath.EvalSymlinks(filepath.Join(sysfs, PathBlock, name))
+	if err != nil {
+		return nil, err
+	}
+	return bd.findAll(realPath)
+}
+
+// FindByDevNumber returns the block devices backed by PCI functions which hold the given device number, following
+// <sysfs>/dev/block/<major>:<minor>. Partitions resolve to the device they are on. Stacked devices resolve to all
+// the devices they are built on: the slaves of device-mapper (LVM, dm-crypt, dm-multipath) and md RAID devices,
+// and the controllers of the native multipath NVMe namespaces, reported with the name of the namespace.
+// Returns pcidev.ErrNoPCIDevice if no PCI device backs the block device, like for loop devices.
+func (bd BlockDevices) FindByDevNumber(sysfs string, major, minor uint32) ([]BlockDeviceInfo, error) {
+	realPath, err := filepath.EvalSymlinks(filepath.Join(sysfs, pcidev.PathDevBlock, fmt.Sprintf("%d:%d", major, minor)))
+	if err != nil {
+		return nil, err
+	}
+	return bd.findAll(realPath)
+}
+
+// FindByPath returns the block devices backed by PCI functions which hold path: either a block device node,
+// like /dev/nvme0n1, or a file on a filesystem backed by a block device, like the mount point of a volume.
+// Returns pcidev.ErrNoPCIDevice if the block device is not backed by a PCI device.
+func (bd BlockDevices) FindByPath(sysfs, path string) ([]BlockDeviceInfo, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	dev := uint64(st.Dev)
+	if st.Mode&unix.S_IFMT == unix.S_IFBLK {
+		dev = uint64(st.Rdev)
+	}
+	bdis, err := bd.FindByDevNumber(sysfs, unix.Major(dev), unix.Minor(dev))
+	if os.IsNotExist(err) {
+		// no block device at all, like tmpfs or overlay
+		return nil, pcidev.ErrNoPCIDevice
+	}
+	return bdis, err
+}
+
+// findAll returns the devices backing the block device at the resolved sysfs path devPath, sorted by name
+func (bd BlockDevices) findAll(devPath string) ([]BlockDeviceInfo, error) {
+	found, err := bd.findBacking(devPath, make(map[string]bool), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, pcidev.ErrNoPCIDevice
+	}
+	// stacked devices may reach the same device more times, like LVM on two partitions of a disk
+	var ret []BlockDeviceInfo
+	seen := make(map[string]bool)
+	for _, bdi := range found {
+		key := bdi.Name + "@" + bdi.Address
+		if !seen[key] {
+			seen[key] = true
+			ret = append(ret, bdi)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Name != ret[j].Name {
+			return ret[i].Name < ret[j].Name
+		}
+		return ret[i].Address < ret[j].Address
+	})
+	return ret, nil
+}
+
+// findBacking appends to ret the devices backing the block device at the resolved sysfs path devPath
+func (bd BlockDevices) findBacking(devPath string, visited map[string]bool, ret []BlockDeviceInfo) ([]BlockDeviceInfo, error) {
+	if visited[devPath] {
+		return ret, nil
+	}
+	visited[devPath] = true
+
+	for _, bdi := range bd.Items {
+		if devPath == bdi.sysfsPath || strings.HasPrefix(devPath, bdi.sysfsPath+string(filepath.Separator)) {
+			return append(ret, bdi), nil
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(devPath, "partition")); err == nil {
+		// a partition of a stacked device, like md0p1
+		return bd.findBacking(filepath.Dir(devPath), visited, ret)
+	}
+
+	slavesPath := filepath.Join(devPath, "slaves")
+	slaves, err := ioutil.ReadDir(slavesPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, slave := range slaves {
+		slavePath, err := filepath.EvalSymlinks(filepath.Join(slavesPath, slave.Name()))
+		if err != nil {
+			return nil, err
+		}
+		ret, err = bd.findBacking(slavePath, visited, ret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// native multipath: .../nvme-subsystem/nvme-subsys0/nvme0n1, with the controllers linked as nvme-subsys0/nvme0
+	subsysPath := filepath.Dir(devPath)
+	if !strings.HasPrefix(filepath.Base(subsysPath), "nvme-subsys") || bd.pciDevs == nil {
+		return ret, nil
+	}
+	ctrls, err := ioutil.ReadDir(subsysPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, ctrl := range ctrls {
+		if ctrl.Mode()&os.ModeSymlink == 0 || !nvmeCtrlRe.MatchString(ctrl.Name()) {
+			continue
+		}
+		ctrlPath, err := filepath.EvalSymlinks(filepath.Join(subsysPath, ctrl.Name()))
+		if err != nil {
+			return nil, err
+		}
+		pciInfo, err := bd.pciDevs.FindByPath(ctrlPath)
+		if errors.Is(err, pcidev.ErrNoPCIDevice) {
+			// NVMe over fabrics
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		bdi, err := newBlockDeviceInfo(filepath.Base(devPath), devPath, pciInfo)
+		if err != nil {
+			return nil, err
+		}
+		bdi.Kind = KindNVMe
+		ret = append(ret, bdi)
+	}
+	return ret, nil
+}
+
+// kindFromPath tells how the device is attached from the intermediate devices in its path, like
+// .../0000:5e:00.0/nvme/nvme0/nvme0n1 or .../0000:00:02.0/virtio1/block/vda
+func kindFromPath(devPath string) string {
+	for _, item := range strings.Split(devPath, string(filepath.Separator)) {
+		switch {
+		case item == "nvme":
+			return KindNVMe
+		case strings.HasPrefix(item, "virtio"):
+			return KindVirtio
+		case strings.HasPrefix(item, "host") && isNumber(strings.TrimPrefix(item, "host")):
+			return KindSCSI
+		}
+	}
+	return KindUnknown
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// readDevNumber parses the "dev" attribute, like "259:0"
+func readDevNumber(path string) (uint32, uint32, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	items := strings.SplitN(strings.TrimSpace(string(content)), ":", 2)
+	if len(items) != 2 {
+		return 0, 0, fmt.Errorf("malformed device number in %q", path)
+	}
+	major, err := strconv.ParseUint(items[0], 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	minor, err := strconv.ParseUint(items[1], 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(major), uint32(minor), nil
+}
+
+func readInt64(path string) (int64, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+}
@@ -0,0 +1,227 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package block
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ffromani/numalign/pkg/topologyinfo/pcidev"
+	fakesysfs "github.com/ffromani/numalign/pkg/topologyinfo/sysfs/fake"
+)
+
+func TestNewBlockDevices(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+	t.Logf("sysfs at %q", fs.Base())
+
+	sysDevs := fs.AddTree("sys", "bus", "pci", "devices")
+	nvmeDev := sysDevs.Add("0000:5e:00.0", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node": "1",
+		"class":     "0x010802",
+		"vendor":    "0x8086",
+		"device":    "0x0a54",
+	}))
+	nvmeNs := nvmeDev.Add("nvme", nil).Add("nvme0", nil).Add("nvme0n1", fakesysfs.MakeAttrs(map[string]string{
+		"dev":  "259:0",
+		"size": "2048",
+	}))
+	nvmeNs.Add("nvme0n1p1", fakesysfs.MakeAttrs(map[string]string{
+		"dev":  "259:1",
+		"size": "1024",
+	}))
+	virtioDev := sysDevs.Add("0000:00:02.0", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node": "-1",
+		"class":     "0x010000",
+		"vendor":    "0x1af4",
+		"device":    "0x1042",
+	}))
+	virtioDev.Add("virtio1", nil).Add("block", nil).Add("vda", fakesysfs.MakeAttrs(map[string]string{
+		"dev":  "252:0",
+		"size": "8",
+	}))
+	hbaDev := sysDevs.Add("0000:18:00.0", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node": "0",
+		"class":     "0x010700",
+		"vendor":    "0x1000",
+		"device":    "0x0097",
+	}))
+	hbaDev.Add("host0", nil).Add("target0:0:0", nil).Add("0:0:0:0", nil).Add("block", nil).Add("sda", fakesysfs.MakeAttrs(map[string]string{
+		"dev":  "8:0",
+		"size": "16",
+	}))
+	// native multipath NVMe: namespace head nvme1n1 on the controllers of two PCI devices
+	for _, ctrl := range []struct{ addr, name string }{{"0000:af:00.0", "nvme1"}, {"0000:d8:00.0", "nvme2"}} {
+		sysDevs.Add(ctrl.addr, fakesysfs.MakeAttrs(map[string]string{
+			"numa_node": "1",
+			"class":     "0x010802",
+			"vendor":    "0x8086",
+			"device":    "0x0a54",
+		})).Add("nvme", nil).Add(ctrl.name, nil)
+	}
+	virtDevs := fs.AddTree("sys", "devices", "virtual")
+	virtDevs.Add("nvme-subsystem", nil).Add("nvme-subsys1", nil).
+		AddLink("nvme1", "../../../../bus/pci/devices/0000:af:00.0/nvme/nvme1").
+		AddLink("nvme2", "../../../../bus/pci/devices/0000:d8:00.0/nvme/nvme2").
+		Add("nvme1n1", fakesysfs.MakeAttrs(map[string]string{
+			"dev":  "259:2",
+			"size": "4096",
+		}))
+	virtBlock := virtDevs.Add("block", nil)
+	virtBlock.Add("loop0", fakesysfs.MakeAttrs(map[string]string{
+		"dev":  "7:0",
+		"size": "0",
+	}))
+	// LVM volume on a partition of nvme0n1 and on sda, with dm-1 on top of it
+	virtBlock.Add("dm-0", fakesysfs.MakeAttrs(map[string]string{
+		"dev":  "253:0",
+		"size": "512",
+	})).Add("slaves", nil).
+		AddLink("nvme0n1p1", "../../../../../bus/pci/devices/0000:5e:00.0/nvme/nvme0/nvme0n1/nvme0n1p1").
+		AddLink("sda", "../../../../../bus/pci/devices/0000:18:00.0/host0/target0:0:0/0:0:0:0/block/sda")
+	virtBlock.Add("dm-1", fakesysfs.MakeAttrs(map[string]string{
+		"dev":  "253:1",
+		"size": "256",
+	})).Add("slaves", nil).
+		AddLink("dm-0", "../../dm-0")
+	// device-mapper on a device not backed by PCI
+	virtBlock.Add("dm-2", fakesysfs.MakeAttrs(map[string]string{
+		"dev":  "253:2",
+		"size": "0",
+	})).Add("slaves", nil).
+		AddLink("loop0", "../../loop0")
+
+	fs.AddTree("sys", "block").
+		AddLink("nvme0n1", "../bus/pci/devices/0000:5e:00.0/nvme/nvme0/nvme0n1").
+		AddLink("vda", "../bus/pci/devices/0000:00:02.0/virtio1/block/vda").
+		AddLink("sda", "../bus/pci/devices/0000:18:00.0/host0/target0:0:0/0:0:0:0/block/sda").
+		AddLink("loop0", "../devices/virtual/block/loop0").
+		AddLink("nvme1n1", "../devices/virtual/nvme-subsystem/nvme-subsys1/nvme1n1").
+		AddLink("dm-0", "../devices/virtual/block/dm-0").
+		AddLink("dm-1", "../devices/virtual/block/dm-1").
+		AddLink("dm-2", "../devices/virtual/block/dm-2")
+	fs.AddTree("sys", "dev", "block").
+		AddLink("259:0", "../../bus/pci/devices/0000:5e:00.0/nvme/nvme0/nvme0n1").
+		AddLink("259:1", "../../bus/pci/devices/0000:5e:00.0/nvme/nvme0/nvme0n1/nvme0n1p1").
+		AddLink("259:2", "../../devices/virtual/nvme-subsystem/nvme-subsys1/nvme1n1").
+		AddLink("7:0", "../../devices/virtual/block/loop0").
+		AddLink("253:0", "../../devices/virtual/block/dm-0")
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	sysfs := filepath.Join(fs.Base(), "sys")
+	blockDevs, err := NewBlockDevices(sysfs)
+	if err != nil {
+		t.Fatalf("error in NewBlockDevices: %v", err)
+	}
+
+	type expected struct {
+		name      string
+		kind      string
+		address   string
+		numaNode  int
+		sizeBytes int64
+	}
+	exp := []expected{
+		{name: "nvme0n1", kind: KindNVMe, address: "0000:5e:00.0", numaNode: 1, sizeBytes: 2048 * 512},
+		{name: "sda", kind: KindSCSI, address: "0000:18:00.0", numaNode: 0, sizeBytes: 16 * 512},
+		{name: "vda", kind: KindVirtio, address: "0000:00:02.0", numaNode: pcidev.NUMANodeUnknown, sizeBytes: 8 * 512},
+	}
+	if len(blockDevs.Items) != len(exp) {
+		t.Fatalf("found %d devices expected %d: %v", len(blockDevs.Items), len(exp), blockDevs.Items)
+	}
+	for idx, bdi := range blockDevs.Items {
+		got := expected{name: bdi.Name, kind: bdi.Kind, address: bdi.Address, numaNode: bdi.NUMANode, sizeBytes: bdi.SizeBytes}
+		if got != exp[idx] {
+			t.Errorf("device %d: got %+v expected %+v", idx, got, exp[idx])
+		}
+	}
+
+	perNUMA := blockDevs.PerNUMA()
+	if len(perNUMA[1]) != 1 || perNUMA[1][0].Name != "nvme0n1" {
+		t.Errorf("unexpected devices on NUMA node 1: %v", perNUMA[1])
+	}
+
+	for _, minor := range []uint32{0, 1} {
+		bdis, err := blockDevs.FindByDevNumber(sysfs, 259, minor)
+		if err != nil {
+			t.Errorf("error resolving 259:%d: %v", minor, err)
+			continue
+		}
+		if got := names(bdis); got != "nvme0n1@0000:5e:00.0" {
+			t.Errorf("259:%d resolved to %q", minor, got)
+		}
+	}
+	if _, err := blockDevs.FindByDevNumber(sysfs, 7, 0); !errors.Is(err, pcidev.ErrNoPCIDevice) {
+		t.Errorf("unexpected error resolving virtual device: %v", err)
+	}
+
+	stacked := map[string]string{
+		"dm-0":    "nvme0n1@0000:5e:00.0 sda@0000:18:00.0",
+		"dm-1":    "nvme0n1@0000:5e:00.0 sda@0000:18:00.0",
+		"nvme1n1": "nvme1n1@0000:af:00.0 nvme1n1@0000:d8:00.0",
+	}
+	for name, exp := range stacked {
+		bdis, err := blockDevs.FindBacking(sysfs, name)
+		if err != nil {
+			t.Errorf("error resolving %q: %v", name, err)
+			continue
+		}
+		if got := names(bdis); got != exp {
+			t.Errorf("%q resolved to %q expected %q", name, got, exp)
+		}
+	}
+	if bdis, err := blockDevs.FindByDevNumber(sysfs, 259, 2); err != nil || bdis[0].Kind != KindNVMe || bdis[0].SizeBytes != 4096*512 {
+		t.Errorf("unexpected result resolving 259:2: %v %v", bdis, err)
+	}
+	for _, name := range []string{"loop0", "dm-2"} {
+		if _, err := blockDevs.FindBacking(sysfs, name); !errors.Is(err, pcidev.ErrNoPCIDevice) {
+			t.Errorf("unexpected error resolving %q: %v", name, err)
+		}
+	}
+}
+
+func names(bdis []BlockDeviceInfo) string {
+	var ret []string
+	for _, bdi := range bdis {
+		ret = append(ret, bdi.Name+"@"+bdi.Address)
+	}
+	return strings.Join(ret, " ")
+}
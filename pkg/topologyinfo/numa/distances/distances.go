@@ -18,6 +18,7 @@ package distances
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -30,29 +31,31 @@ const (
 )
 
 type nodeDistances struct {
-	values []int
+	// values maps the destination node ID -> distance
+	values map[int]int
 }
 
-func nodeDistancesFromString(numaNodes int, data string) (nodeDistances, error) {
+// nodeDistancesFromString parses the distances to the given nodes, in the same order
+func nodeDistancesFromString(nodeIDs []int, data string) (nodeDistances, error) {
 	ret := nodeDistances{}
 	dists := strings.Split(strings.TrimSpace(data), distanceSeparator)
-	if len(dists) != numaNodes {
-		return ret, fmt.Errorf("found %d distance values, expected %d", len(dists), numaNodes)
+	if len(dists) != len(nodeIDs) {
+		return ret, fmt.Errorf("found %d distance values, expected %d", len(dists), len(nodeIDs))
 	}
-	ret.values = make([]int, numaNodes, numaNodes)
+	ret.values = make(map[int]int, len(nodeIDs))
 	for idx, dist := range dists {
 		val, err := strconv.Atoi(dist)
 		if err != nil {
 			return ret, err
 		}
-		ret.values[idx] = val
+		ret.values[nodeIDs[idx]] = val
 	}
 	return ret, nil
 }
 
 type Distances struct {
 	onlineNodes map[int]bool
-	byNode      []nodeDistances
+	byNode      map[int]nodeDistances
 }
 
 func (d *Distances) BetweenNodes(from, to int) (int, error) {
@@ -65,22 +68,32 @@ func (d *Distances) BetweenNodes(from, to int) (int, error) {
 	return d.byNode[from].values[to], nil
 }
 
-// NewDistancesFromData takes a map in the format "0": "10 21 30\n"
+// NewDistancesFromData takes a map in the format "0": "10 21 30\n".
+// Node IDs may be sparse, like on systems with offline nodes: the distances are in ascending node ID order.
 func NewDistancesFromData(data map[string]string) (*Distances, error) {
 	dist := NewDistancesEmpty()
 
-	numNodes := len(data)
-	for nodeData, distData := range data {
+	// map iteration order is random: the node IDs must be sorted to know the order of the distances
+	var nodeIDs []int
+	for nodeData := range data {
 		nodeID, err := strconv.Atoi(nodeData)
 		if err != nil {
 			return dist, err
 		}
+		if nodeID < 0 {
+			return dist, fmt.Errorf("unexpected NUMA node: %d", nodeID)
+		}
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Ints(nodeIDs)
+
+	for _, nodeID := range nodeIDs {
 		dist.onlineNodes[nodeID] = true
-		nodeDist, err := nodeDistancesFromString(numNodes, distData)
+		nodeDist, err := nodeDistancesFromString(nodeIDs, data[strconv.Itoa(nodeID)])
 		if err != nil {
 			return dist, err
 		}
-		dist.byNode = append(dist.byNode, nodeDist)
+		dist.byNode[nodeID] = nodeDist
 	}
 	return dist, nil
 }
@@ -107,12 +120,13 @@ func NewDistancesFromSysfs(sysfsPath string) (*Distances, error) {
 			return nil, err
 		}
 
-		nodeDist, err := nodeDistancesFromString(len(nodes.Online), distData)
+		// the kernel reports the distances to the online nodes only, in ascending node ID order
+		nodeDist, err := nodeDistancesFromString(nodes.Online, distData)
 		if err != nil {
 			return nil, err
 		}
 
-		dist.byNode = append(dist.byNode, nodeDist)
+		dist.byNode[nodeID] = nodeDist
 	}
 
 	return dist, nil
@@ -121,6 +135,7 @@ func NewDistancesFromSysfs(sysfsPath string) (*Distances, error) {
 func NewDistancesEmpty() *Distances {
 	dist := Distances{
 		onlineNodes: make(map[int]bool),
+		byNode:      make(map[int]nodeDistances),
 	}
 	return &dist
 }
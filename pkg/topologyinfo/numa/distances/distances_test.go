@@ -17,7 +17,12 @@
 package distances
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+
+	fakesysfs "github.com/ffromani/numalign/pkg/topologyinfo/sysfs/fake"
 )
 
 type distTcase struct {
@@ -59,3 +64,99 @@ func TestDistancesBetweenNodes(t *testing.T) {
 	}
 
 }
+
+func TestDistancesFromDataOrdering(t *testing.T) {
+	data := map[string]string{
+		"0": "10 21 31 41\n",
+		"1": "21 10 41 31\n",
+		"2": "31 41 10 21\n",
+		"3": "41 31 21 10\n",
+	}
+	expected := [][]int{
+		{10, 21, 31, 41},
+		{21, 10, 41, 31},
+		{31, 41, 10, 21},
+		{41, 31, 21, 10},
+	}
+	// the data is a map: iterate a few times to make sure the random iteration order doesn't matter
+	for iter := 0; iter < 16; iter++ {
+		dists, err := NewDistancesFromData(data)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for from, row := range expected {
+			for to, exp := range row {
+				val, err := dists.BetweenNodes(from, to)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if val != exp {
+					t.Errorf("unexpected distance %d -> %d: got %v expected %v", from, to, val, exp)
+				}
+			}
+		}
+	}
+
+	sparse, err := NewDistancesFromData(map[string]string{"0": "10 21\n", "2": "21 10\n"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if val, err := sparse.BetweenNodes(2, 0); err != nil || val != 21 {
+		t.Errorf("unexpected distance 2 -> 0: got %v (%v) expected 21", val, err)
+	}
+	if _, err := NewDistancesFromData(map[string]string{"0": "10 21\n", "-1": "21 10\n"}); err == nil {
+		t.Errorf("got distances with a negative node")
+	}
+}
+
+func TestDistancesFromSysfsSparse(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+	t.Logf("sysfs at %q", fs.Base())
+
+	// node 1 is offline, the distances list only the online nodes
+	sysNode := fs.AddTree("sys", "devices", "system").Add("node", fakesysfs.MakeAttrs(map[string]string{
+		"online":            "0,2",
+		"possible":          "0-2",
+		"has_cpu":           "0,2",
+		"has_memory":        "0,2",
+		"has_normal_memory": "0,2",
+	}))
+	sysNode.Add("node0", fakesysfs.MakeAttrs(map[string]string{"distance": "10 32\n"}))
+	sysNode.Add("node2", fakesysfs.MakeAttrs(map[string]string{"distance": "32 10\n"}))
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	dists, err := NewDistancesFromSysfs(filepath.Join(fs.Base(), "sys"))
+	if err != nil {
+		t.Fatalf("error in NewDistancesFromSysfs: %v", err)
+	}
+	for _, tc := range []struct{ from, to, dist int }{{0, 0, 10}, {0, 2, 32}, {2, 0, 32}, {2, 2, 10}} {
+		val, err := dists.BetweenNodes(tc.from, tc.to)
+		if err != nil || val != tc.dist {
+			t.Errorf("unexpected distance %d -> %d: got %v (%v) expected %v", tc.from, tc.to, val, err, tc.dist)
+		}
+	}
+	if _, err := dists.BetweenNodes(0, 1); err == nil {
+		t.Errorf("got distance to an offline node")
+	}
+}
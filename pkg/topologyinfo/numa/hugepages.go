@@ -0,0 +1,115 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numa
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ffromani/numalign/pkg/topologyinfo/sysfs"
+)
+
+/*
+ * keep this handy:
+ * https://www.kernel.org/doc/html/latest/admin-guide/mm/hugetlbpage.html
+ */
+const (
+	hugepagesDir    = "hugepages"
+	hugepagesPrefix = "hugepages-"
+	hugepagesSuffix = "kB"
+)
+
+// HugePages reports the hugepages counters for a given page size on a NUMA node
+type HugePages struct {
+	SizeKB  int
+	Total   int
+	Free    int
+	Surplus int
+}
+
+// NodeHugePages maps the NUMA node ids to their hugepages counters, sorted by page size
+type NodeHugePages map[int][]HugePages
+
+func NewHugePagesFromSysFS(sysfsPath string) (NodeHugePages, error) {
+	sys := sysfs.New(sysfsPath)
+	online, err := sys.Join(sysfs.PathDevsSysNode).ReadList("online")
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(NodeHugePages)
+	for _, nodeID := range online {
+		hpNode := sys.ForNode(nodeID).Join(hugepagesDir)
+		entries, err := hpNode.ReadDir()
+		if err != nil {
+			if os.IsNotExist(err) {
+				// kernel without hugetlbfs support
+				ret[nodeID] = []HugePages{}
+				continue
+			}
+			return nil, err
+		}
+
+		var hps []HugePages
+		for _, entry := range entries {
+			size, err := parseHugePagesSize(entry)
+			if err != nil {
+				return nil, err
+			}
+			hp, err := readHugePages(hpNode.Join(entry), size)
+			if err != nil {
+				return nil, err
+			}
+			hps = append(hps, hp)
+		}
+		sort.Slice(hps, func(i, j int) bool { return hps[i].SizeKB < hps[j].SizeKB })
+		ret[nodeID] = hps
+	}
+	return ret, nil
+}
+
+// parseHugePagesSize extracts the size from directory names like "hugepages-2048kB"
+func parseHugePagesSize(name string) (int, error) {
+	if !strings.HasPrefix(name, hugepagesPrefix) || !strings.HasSuffix(name, hugepagesSuffix) {
+		return 0, fmt.Errorf("unexpected hugepages entry: %q", name)
+	}
+	return strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, hugepagesPrefix), hugepagesSuffix))
+}
+
+func readHugePages(hpSize sysfs.Path, sizeKB int) (HugePages, error) {
+	total, err := hpSize.ReadInt("nr_hugepages")
+	if err != nil {
+		return HugePages{}, err
+	}
+	free, err := hpSize.ReadInt("free_hugepages")
+	if err != nil {
+		return HugePages{}, err
+	}
+	surplus, err := hpSize.ReadInt("surplus_hugepages")
+	if err != nil {
+		return HugePages{}, err
+	}
+	return HugePages{
+		SizeKB:  sizeKB,
+		Total:   total,
+		Free:    free,
+		Surplus: surplus,
+	}, nil
+}
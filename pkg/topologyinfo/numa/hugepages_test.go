@@ -0,0 +1,100 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package numa
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	fakesysfs "github.com/ffromani/numalign/pkg/topologyinfo/sysfs/fake"
+)
+
+func TestReadHugePages(t *testing.T) {
+	expected := NodeHugePages{
+		0: []HugePages{
+			{SizeKB: 2048, Total: 512, Free: 128, Surplus: 0},
+			{SizeKB: 1048576, Total: 4, Free: 4, Surplus: 0},
+		},
+		1: []HugePages{
+			{SizeKB: 2048, Total: 0, Free: 0, Surplus: 2},
+			{SizeKB: 1048576, Total: 0, Free: 0, Surplus: 0},
+		},
+	}
+
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+	t.Logf("sysfs at %q", fs.Base())
+
+	devNode := fs.AddTree("sys", "devices", "system").Add("node", map[string]string{
+		"online": "0-1\n",
+	})
+	hpNode0 := devNode.Add("node0", nil).Add("hugepages", nil)
+	hpNode0.Add("hugepages-2048kB", fakesysfs.MakeAttrs(map[string]string{
+		"nr_hugepages":      "512",
+		"free_hugepages":    "128",
+		"surplus_hugepages": "0",
+	}))
+	hpNode0.Add("hugepages-1048576kB", fakesysfs.MakeAttrs(map[string]string{
+		"nr_hugepages":      "4",
+		"free_hugepages":    "4",
+		"surplus_hugepages": "0",
+	}))
+	hpNode1 := devNode.Add("node1", nil).Add("hugepages", nil)
+	hpNode1.Add("hugepages-2048kB", fakesysfs.MakeAttrs(map[string]string{
+		"nr_hugepages":      "0",
+		"free_hugepages":    "0",
+		"surplus_hugepages": "2",
+	}))
+	hpNode1.Add("hugepages-1048576kB", fakesysfs.MakeAttrs(map[string]string{
+		"nr_hugepages":      "0",
+		"free_hugepages":    "0",
+		"surplus_hugepages": "0",
+	}))
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	hps, err := NewHugePagesFromSysFS(filepath.Join(fs.Base(), "sys"))
+	if err != nil {
+		t.Errorf("error in NewHugePagesFromSysFS: %v", err)
+	}
+
+	if !reflect.DeepEqual(hps, expected) {
+		t.Errorf("data mismatch found %v expected %v", hps, expected)
+	}
+}
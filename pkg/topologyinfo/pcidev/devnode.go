@@ -0,0 +1,78 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package pcidev
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// PathDevChar is the subpath which maps the character device numbers to their devices
+	PathDevChar = "dev/char"
+	// PathDevBlock is the subpath which maps the block device numbers to their devices
+	PathDevBlock = "dev/block"
+)
+
+// FindByDevNode returns the PCI device backing a device node, like /dev/nvme0n1 or /dev/dri/renderD128.
+// Returns ErrNoPCIDevice if the device is not backed by a PCI device, like /dev/null.
+func (pd PCIDevices) FindByDevNode(sysfs, path string) (PCIDeviceInfo, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	var block bool
+	switch st.Mode & unix.S_IFMT {
+	case unix.S_IFBLK:
+		block = true
+	case unix.S_IFCHR:
+		block = false
+	default:
+		return nil, fmt.Errorf("%q is not a device node", path)
+	}
+	return pd.FindByDevNumber(sysfs, block, unix.Major(uint64(st.Rdev)), unix.Minor(uint64(st.Rdev)))
+}
+
+// FindByDevNumber returns the PCI device backing a device, following <sysfs>/dev/{block,char}/<major>:<minor>.
+// Returns ErrNoPCIDevice if the device is not backed by a PCI device.
+func (pd PCIDevices) FindByDevNumber(sysfs string, block bool, major, minor uint32) (PCIDeviceInfo, error) {
+	dir := PathDevChar
+	if block {
+		dir = PathDevBlock
+	}
+	realPath, err := filepath.EvalSymlinks(filepath.Join(sysfs, dir, fmt.Sprintf("%d:%d", major, minor)))
+	if err != nil {
+		return nil, err
+	}
+	return pd.FindByPath(realPath)
+}
+
+// FindByPath walks up a resolved sysfs device path, like .../0000:5e:00.0/nvme/nvme0/nvme0n1, looking for a PCI device.
+// Returns ErrNoPCIDevice if no PCI device is found along the path.
+func (pd PCIDevices) FindByPath(realPath string) (PCIDeviceInfo, error) {
+	items := strings.Split(realPath, string(filepath.Separator))
+	for idx := len(items) - 1; idx >= 0; idx-- {
+		if devInfo, found := pd.FindByAddress(items[idx]); found {
+			return devInfo, nil
+		}
+	}
+	return nil, ErrNoPCIDevice
+}
@@ -0,0 +1,118 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package pcidev
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	fakesysfs "github.com/ffromani/numalign/pkg/topologyinfo/sysfs/fake"
+)
+
+func TestFindByDevNumber(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+	t.Logf("sysfs at %q", fs.Base())
+
+	sysDevs := fs.AddTree("sys", "bus", "pci", "devices")
+	gpuDev := sysDevs.Add("0000:65:00.0", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node": "1",
+		"class":     "0x030200",
+		"vendor":    "0x10de",
+		"device":    "0x20b0",
+	}))
+	gpuDev.Add("drm", nil).Add("renderD128", nil)
+	nvmeDev := sysDevs.Add("0000:5e:00.0", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node": "0",
+		"class":     "0x010802",
+		"vendor":    "0x8086",
+		"device":    "0x0a54",
+	}))
+	nvmeDev.Add("nvme", nil).Add("nvme0", nil).Add("nvme0n1", nil)
+
+	fs.AddTree("sys", "devices", "virtual", "mem", "null")
+	fs.AddTree("sys", "dev", "char").
+		AddLink("226:128", "../../bus/pci/devices/0000:65:00.0/drm/renderD128").
+		AddLink("1:3", "../../devices/virtual/mem/null")
+	fs.AddTree("sys", "dev", "block").
+		AddLink("259:0", "../../bus/pci/devices/0000:5e:00.0/nvme/nvme0/nvme0n1")
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	sysfs := filepath.Join(fs.Base(), "sys")
+	pciDevs, err := NewPCIDevices(sysfs)
+	if err != nil {
+		t.Fatalf("error in NewPCIDevices: %v", err)
+	}
+
+	type tcase struct {
+		block bool
+		major uint32
+		minor uint32
+		addr  string
+	}
+	for _, tc := range []tcase{
+		{block: false, major: 226, minor: 128, addr: "0000:65:00.0"},
+		{block: true, major: 259, minor: 0, addr: "0000:5e:00.0"},
+	} {
+		devInfo, err := pciDevs.FindByDevNumber(sysfs, tc.block, tc.major, tc.minor)
+		if err != nil {
+			t.Errorf("error resolving %d:%d: %v", tc.major, tc.minor, err)
+			continue
+		}
+		if devInfo.Address() != tc.addr {
+			t.Errorf("%d:%d resolved to %q expected %q", tc.major, tc.minor, devInfo.Address(), tc.addr)
+		}
+	}
+
+	nvmePath := filepath.Join(sysfs, "bus", "pci", "devices", "0000:5e:00.0", "nvme", "nvme0", "nvme0n1")
+	if devInfo, err := pciDevs.FindByPath(nvmePath); err != nil || devInfo.Address() != "0000:5e:00.0" {
+		t.Errorf("unexpected device for %q: %v (%v)", nvmePath, devInfo, err)
+	}
+	if _, err := pciDevs.FindByPath(filepath.Join(sysfs, "devices", "virtual", "mem", "null")); !errors.Is(err, ErrNoPCIDevice) {
+		t.Errorf("unexpected error resolving the path of a virtual device: %v", err)
+	}
+
+	if _, err := pciDevs.FindByDevNumber(sysfs, false, 1, 3); !errors.Is(err, ErrNoPCIDevice) {
+		t.Errorf("unexpected error resolving virtual device: %v", err)
+	}
+	if _, err := pciDevs.FindByDevNumber(sysfs, true, 226, 128); err == nil || errors.Is(err, ErrNoPCIDevice) {
+		t.Errorf("unexpected error resolving missing device: %v", err)
+	}
+}
@@ -0,0 +1,378 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package pcidev
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Filter selects PCI devices. Filters are parsed from expressions like
+//
+//	class=0200 && vendor=8086 && numa=1 && driver=vfio-pci && vf
+//
+// An expression combines terms with "&&", "||", "!" and parentheses; "&&" binds tighter than "||".
+// A term is either a comparison "key=value" or "key!=value", with keys:
+//
+//	address   the PCI address, full (0000:05:00.0) or short (05:00.0). Shell-style wildcards allowed: 05:10.*
+//	class     the class in hex, like 0200. Two digits, like 02, match the whole base class
+//	vendor    the vendor identifier in hex, like 8086
+//	device    the device identifier in hex, like 1520
+//	subvendor the subsystem vendor identifier in hex
+//	subdevice the subsystem device identifier in hex
+//	numa      the NUMA node, or "unknown"
+//	driver    the driver bound to the device, or "none"
+//	iommu     the IOMMU group, or "none"
+//	rc        the root complex, like pci0000:00
+//
+// or one of the flags:
+//
+//	pf        the device is a SRIOV Physical Function
+//	vf        the device is a SRIOV Virtual Function
+//	bridge    the device is a bridge or a port
+//	degraded  the PCI-express link trained below the device capabilities
+type Filter struct {
+	expr string
+	root filterNode
+}
+
+// ParseFilter parses a filter expression. The empty expression selects all the devices.
+func ParseFilter(expr string) (Filter, error) {
+	f := Filter{expr: strings.TrimSpace(expr)}
+	if f.expr == "" {
+		return f, nil
+	}
+	tokens, err := tokenizeFilter(f.expr)
+	if err != nil {
+		return f, fmt.Errorf("filter %q: %w", f.expr, err)
+	}
+	p := filterParser{tokens: tokens}
+	f.root, err = p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if err != nil {
+		return f, fmt.Errorf("filter %q: %w", f.expr, err)
+	}
+	return f, nil
+}
+
+// Match returns true if the device is selected by the filter
+func (f Filter) Match(devInfo PCIDeviceInfo) bool {
+	if f.root == nil {
+		return true
+	}
+	return f.root.match(devInfo)
+}
+
+// IsEmpty returns true if the filter selects all the devices
+func (f Filter) IsEmpty() bool {
+	return f.root == nil
+}
+
+func (f Filter) String() string {
+	return f.expr
+}
+
+// Select returns the devices matching the filter, in the same order as Items
+func (pd PCIDevices) Select(f Filter) PCIDeviceInfoList {
+	var ret PCIDeviceInfoList
+	for _, devInfo := range pd.Items {
+		if f.Match(devInfo) {
+			ret = append(ret, devInfo)
+		}
+	}
+	return ret
+}
+
+type filterNode interface {
+	match(devInfo PCIDeviceInfo) bool
+}
+
+type andNode struct {
+	left, right filterNode
+}
+
+func (n andNode) match(devInfo PCIDeviceInfo) bool {
+	return n.left.match(devInfo) && n.right.match(devInfo)
+}
+
+type orNode struct {
+	left, right filterNode
+}
+
+func (n orNode) match(devInfo PCIDeviceInfo) bool {
+	return n.left.match(devInfo) || n.right.match(devInfo)
+}
+
+type notNode struct {
+	node filterNode
+}
+
+func (n notNode) match(devInfo PCIDeviceInfo) bool {
+	return !n.node.match(devInfo)
+}
+
+type matchFunc func(devInfo PCIDeviceInfo) bool
+
+func (fn matchFunc) match(devInfo PCIDeviceInfo) bool {
+	return fn(devInfo)
+}
+
+var filterFlags = map[string]matchFunc{
+	"pf": func(devInfo PCIDeviceInfo) bool {
+		sdi, ok := devInfo.(SRIOVDeviceInfo)
+		return ok && sdi.IsPhysFn
+	},
+	"vf": func(devInfo PCIDeviceInfo) bool {
+		sdi, ok := devInfo.(SRIOVDeviceInfo)
+		return ok && sdi.IsVFn
+	},
+	"bridge": func(devInfo PCIDeviceInfo) bool {
+		return devInfo.PortType().IsBridge()
+	},
+	"degraded": func(devInfo PCIDeviceInfo) bool {
+		link := devInfo.Link()
+		return link.String() != "N/A" && link.Degraded()
+	},
+}
+
+// makeComparison returns the node matching the devices whose key attribute equals value
+func makeComparison(key, value string) (filterNode, error) {
+	switch key {
+	case "address":
+		if _, err := path.Match(value, ""); err != nil {
+			return nil, fmt.Errorf("bad address pattern %q: %w", value, err)
+		}
+		return matchFunc(func(devInfo PCIDeviceInfo) bool {
+			for _, addr := range []string{devInfo.Address(), devInfo.DevAddress()} {
+				if ok, _ := path.Match(value, addr); ok {
+					return true
+				}
+			}
+			return false
+		}), nil
+	case "class":
+		digits := strings.TrimPrefix(value, "0x")
+		devClass, err := parseFilterHex(key, digits)
+		if err != nil {
+			return nil, err
+		}
+		if len(digits) <= 2 {
+			return matchFunc(func(devInfo PCIDeviceInfo) bool { return devInfo.DevClass()>>8 == devClass }), nil
+		}
+		return matchFunc(func(devInfo PCIDeviceInfo) bool { return devInfo.DevClass() == devClass }), nil
+	case "vendor", "device", "subvendor", "subdevice":
+		id, err := parseFilterHex(key, strings.TrimPrefix(value, "0x"))
+		if err != nil {
+			return nil, err
+		}
+		getID := map[string]func(PCIDeviceInfo) int64{
+			"vendor":    PCIDeviceInfo.Vendor,
+			"device":    PCIDeviceInfo.Device,
+			"subvendor": PCIDeviceInfo.SubsystemVendor,
+			"subdevice": PCIDeviceInfo.SubsystemDevice,
+		}[key]
+		return matchFunc(func(devInfo PCIDeviceInfo) bool { return getID(devInfo) == id }), nil
+	case "numa":
+		node := NUMANodeUnknown
+		if value != "unknown" {
+			var err error
+			node, err = strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("bad NUMA node %q", value)
+			}
+		}
+		return matchFunc(func(devInfo PCIDeviceInfo) bool { return devInfo.NUMANode() == node }), nil
+	case "driver":
+		if value == "none" {
+			value = ""
+		}
+		return matchFunc(func(devInfo PCIDeviceInfo) bool { return devInfo.Driver() == value }), nil
+	case "iommu":
+		group := IOMMUGroupNone
+		if value != "none" {
+			var err error
+			group, err = strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("bad IOMMU group %q", value)
+			}
+		}
+		return matchFunc(func(devInfo PCIDeviceInfo) bool { return devInfo.IOMMUGroup() == group }), nil
+	case "rc":
+		return matchFunc(func(devInfo PCIDeviceInfo) bool { return devInfo.RootComplex() == value }), nil
+	}
+	return nil, fmt.Errorf("unknown key %q", key)
+}
+
+func parseFilterHex(key, digits string) (int64, error) {
+	id, err := strconv.ParseInt(digits, 16, 64)
+	if err != nil || digits == "" {
+		return 0, fmt.Errorf("bad %s %q: expected hex digits", key, digits)
+	}
+	return id, nil
+}
+
+// filterParser is a recursive descent parser of:
+//
+//	or   := and ( "||" and )*
+//	and  := not ( "&&" not )*
+//	not  := "!" not | "(" or ")" | term
+//	term := word ( ( "=" | "!=" ) word )?
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *filterParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (filterNode, error) {
+	switch tok := p.next(); tok {
+	case "!":
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node: node}, nil
+	case "(":
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing \")\"")
+		}
+		return node, nil
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	default:
+		if !isFilterWord(tok) {
+			return nil, fmt.Errorf("unexpected %q", tok)
+		}
+		return p.parseTerm(tok)
+	}
+}
+
+func (p *filterParser) parseTerm(key string) (filterNode, error) {
+	op := p.peek()
+	if op != "=" && op != "!=" {
+		flag, ok := filterFlags[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown flag %q", key)
+		}
+		return flag, nil
+	}
+	p.next()
+	value := p.next()
+	if !isFilterWord(value) {
+		return nil, fmt.Errorf("missing value for %q", key)
+	}
+	node, err := makeComparison(key, value)
+	if err != nil {
+		return nil, err
+	}
+	if op == "!=" {
+		return notNode{node: node}, nil
+	}
+	return node, nil
+}
+
+func isFilterWord(tok string) bool {
+	switch tok {
+	case "", "&&", "||", "!", "(", ")", "=", "!=":
+		return false
+	}
+	return true
+}
+
+func tokenizeFilter(expr string) ([]string, error) {
+	var tokens []string
+	for idx := 0; idx < len(expr); {
+		ch := expr[idx]
+		switch {
+		case ch == ' ' || ch == '\t':
+			idx++
+		case strings.HasPrefix(expr[idx:], "&&"), strings.HasPrefix(expr[idx:], "||"), strings.HasPrefix(expr[idx:], "!="):
+			tokens = append(tokens, expr[idx:idx+2])
+			idx += 2
+		case strings.HasPrefix(expr[idx:], "=="):
+			tokens = append(tokens, "=")
+			idx += 2
+		case ch == '!' || ch == '(' || ch == ')' || ch == '=':
+			tokens = append(tokens, string(ch))
+			idx++
+		case isFilterWordChar(ch):
+			start := idx
+			for idx < len(expr) && isFilterWordChar(expr[idx]) {
+				idx++
+			}
+			tokens = append(tokens, expr[start:idx])
+		default:
+			return nil, fmt.Errorf("unexpected character %q", ch)
+		}
+	}
+	return tokens, nil
+}
+
+func isFilterWordChar(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') || strings.IndexByte("_-.:*?[]", ch) >= 0
+}
@@ -0,0 +1,142 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package pcidev
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	fakesysfs "github.com/ffromani/numalign/pkg/topologyinfo/sysfs/fake"
+)
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		"class=",
+		"class=zz",
+		"vendor=8086 &&",
+		"(vf || pf",
+		"vf)",
+		"foo",
+		"foo=bar",
+		"numa=one",
+		"iommu=x",
+		"vf pf",
+		"driver=vfio-pci; rm",
+		"address=[",
+	} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Errorf("expected error parsing %q", expr)
+		}
+	}
+}
+
+func TestFilterSelect(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+	t.Logf("sysfs at %q", fs.Base())
+
+	sysDevs := fs.AddTree("sys", "bus", "pci", "devices")
+	sysDevs.Add("0000:05:00.0", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node":    "1",
+		"class":        "0x020000",
+		"vendor":       "0x8086",
+		"device":       "0x1521",
+		"sriov_numvfs": "1",
+	})).AddLink("driver", "../../../bus/pci/drivers/igb").AddLink("virtfn0", "../0000:05:10.0")
+	sysDevs.Add("0000:05:10.0", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node": "1",
+		"class":     "0x020000",
+		"vendor":    "0x8086",
+		"device":    "0x1520",
+	})).AddLink("driver", "../../../bus/pci/drivers/vfio-pci").AddLink("physfn", "../0000:05:00.0").AddLink("iommu_group", "../../../kernel/iommu_groups/42")
+	sysDevs.Add("0000:00:1f.3", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node": "-1",
+		"class":     "0x040300",
+		"vendor":    "0x8086",
+		"device":    "0xa348",
+	}))
+	sysDevs.Add("0000:3b:00.0", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node": "0",
+		"class":     "0x020000",
+		"vendor":    "0x15b3",
+		"device":    "0x1017",
+	})).AddLink("driver", "../../../bus/pci/drivers/mlx5_core")
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	pciDevs, err := NewPCIDevices(filepath.Join(fs.Base(), "sys"))
+	if err != nil {
+		t.Fatalf("error in NewPCIDevices: %v", err)
+	}
+
+	type testCase struct {
+		expr     string
+		expected []string
+	}
+	for _, tc := range []testCase{
+		{"", []string{"0000:00:1f.3", "0000:05:00.0", "0000:05:10.0", "0000:3b:00.0"}},
+		{"class=0200 && vendor=8086 && numa=1 && driver=vfio-pci && vf", []string{"0000:05:10.0"}},
+		{"class=0x0200 && !vf", []string{"0000:05:00.0", "0000:3b:00.0"}},
+		{"class=04", []string{"0000:00:1f.3"}},
+		{"numa=unknown || vendor==15b3", []string{"0000:00:1f.3", "0000:3b:00.0"}},
+		{"vendor=8086 && (pf || numa=0)", []string{"0000:05:00.0"}},
+		{"vendor=8086 && pf || numa=0", []string{"0000:05:00.0", "0000:3b:00.0"}},
+		{"driver=none", []string{"0000:00:1f.3"}},
+		{"driver!=none && !driver=mlx5_core", []string{"0000:05:00.0", "0000:05:10.0"}},
+		{"iommu=42", []string{"0000:05:10.0"}},
+		{"address=05:*", []string{"0000:05:00.0", "0000:05:10.0"}},
+		{"address=0000:3b:00.0", []string{"0000:3b:00.0"}},
+		{"device=1520 && device=1521", nil},
+	} {
+		t.Run(tc.expr, func(t *testing.T) {
+			f, err := ParseFilter(tc.expr)
+			if err != nil {
+				t.Fatalf("error parsing %q: %v", tc.expr, err)
+			}
+			var got []string
+			for _, devInfo := range pciDevs.Select(f) {
+				got = append(got, devInfo.Address())
+			}
+			if !cmp.Equal(got, tc.expected) {
+				t.Errorf("unexpected devices: %v", cmp.Diff(got, tc.expected))
+			}
+		})
+	}
+}
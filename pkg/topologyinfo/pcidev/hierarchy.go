@@ -0,0 +1,171 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package pcidev
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// PortType is the role of a device in the PCI(-express) hierarchy.
+// The role is inferred from the position of the device in the hierarchy and from its class, because
+// the authoritative information lives in the PCI-express capability, which only root can read from sysfs.
+type PortType int
+
+const (
+	// PortTypeEndpoint is any device which is not a bridge: NICs, NVMe drives, accelerators...
+	PortTypeEndpoint PortType = iota
+	// PortTypeRootPort is a bridge attached directly to the root complex
+	PortTypeRootPort
+	// PortTypeUpstreamPort is the upstream port of a PCIe switch, behind a root port or a downstream port
+	PortTypeUpstreamPort
+	// PortTypeDownstreamPort is a downstream port of a PCIe switch, behind its upstream port
+	PortTypeDownstreamPort
+)
+
+func (pt PortType) String() string {
+	switch pt {
+	case PortTypeEndpoint:
+		return "endpoint"
+	case PortTypeRootPort:
+		return "root-port"
+	case PortTypeUpstreamPort:
+		return "upstream-port"
+	case PortTypeDownstreamPort:
+		return "downstream-port"
+	default:
+		return "unknown"
+	}
+}
+
+// IsBridge returns true if devices can be attached behind this device
+func (pt PortType) IsBridge() bool {
+	return pt != PortTypeEndpoint
+}
+
+// Parent returns the bridge (or port) a device sits behind.
+// Returns false if the device is unknown or is attached to the root complex.
+func (pd PCIDevices) Parent(addr string) (PCIDeviceInfo, bool) {
+	devInfo, ok := pd.FindByAddress(addr)
+	if !ok || devInfo.ParentAddress() == "" {
+		return nil, false
+	}
+	return pd.FindByAddress(devInfo.ParentAddress())
+}
+
+// Children returns the devices sitting directly behind a bridge (or port), in the same order as Items
+func (pd PCIDevices) Children(addr string) PCIDeviceInfoList {
+	var ret PCIDeviceInfoList
+	for _, devInfo := range pd.Items {
+		if devInfo.ParentAddress() == addr {
+			ret = append(ret, devInfo)
+		}
+	}
+	return ret
+}
+
+// Roots returns the devices attached directly to a root complex, like root ports and integrated endpoints,
+// in the same order as Items
+func (pd PCIDevices) Roots() PCIDeviceInfoList {
+	return pd.Children("")
+}
+
+// RootPort returns the root port a device sits behind. A root port is its own root port.
+// Returns false if the device is unknown or is attached directly to the root complex.
+func (pd PCIDevices) RootPort(addr string) (PCIDeviceInfo, bool) {
+	devInfo, ok := pd.FindByAddress(addr)
+	for ok {
+		if devInfo.PortType() == PortTypeRootPort {
+			return devInfo, true
+		}
+		devInfo, ok = pd.Parent(devInfo.Address())
+	}
+	return nil, false
+}
+
+// Switch returns the upstream port of the PCIe switch a device sits behind, if any.
+// Devices which share a switch share the bandwidth of its upstream link.
+func (pd PCIDevices) Switch(addr string) (PCIDeviceInfo, bool) {
+	devInfo, ok := pd.Parent(addr)
+	for ok {
+		if devInfo.PortType() == PortTypeUpstreamPort {
+			return devInfo, true
+		}
+		if devInfo.PortType() == PortTypeRootPort {
+			return nil, false
+		}
+		devInfo, ok = pd.Parent(devInfo.Address())
+	}
+	return nil, false
+}
+
+// setPortTypes infers the role of the bridges, walking the hierarchy from the root complexes down
+func (pd *PCIDevices) setPortTypes() {
+	children := make(map[string][]int)
+	for idx, devInfo := range pd.Items {
+		parent := devInfo.ParentAddress()
+		children[parent] = append(children[parent], idx)
+	}
+
+	var visit func(parentType PortType, idxs []int)
+	visit = func(parentType PortType, idxs []int) {
+		for _, idx := range idxs {
+			sdi, ok := pd.Items[idx].(SRIOVDeviceInfo)
+			if !ok {
+				continue
+			}
+			sdi.portType = inferPortType(sdi.devClass, sdi.parentAddr == "", parentType)
+			pd.Items[idx] = sdi
+			if sdi.portType.IsBridge() {
+				visit(sdi.portType, children[sdi.address])
+			}
+		}
+	}
+	visit(PortTypeEndpoint, children[""])
+}
+
+func inferPortType(devClass int64, onRootComplex bool, parentType PortType) PortType {
+	if devClass != DevClassPCIBridge {
+		return PortTypeEndpoint
+	}
+	if onRootComplex {
+		return PortTypeRootPort
+	}
+	if parentType == PortTypeUpstreamPort {
+		return PortTypeDownstreamPort
+	}
+	return PortTypeUpstreamPort
+}
+
+// findAncestors walks up a resolved sysfs device path, like
+// /sys/devices/pci0000:00/0000:00:03.0/0000:05:00.0, and returns the address of the
+// nearest PCI device above the given one and the root complex.
+func findAncestors(realPath string, knownAddrs map[string]bool) (string, string) {
+	items := strings.Split(filepath.Dir(realPath), string(filepath.Separator))
+	parentAddr := ""
+	rootComplex := ""
+	for idx := len(items) - 1; idx >= 0; idx-- {
+		if parentAddr == "" && knownAddrs[items[idx]] {
+			parentAddr = items[idx]
+		}
+		if strings.HasPrefix(items[idx], "pci") && strings.Contains(items[idx], ":") {
+			rootComplex = items[idx]
+			break
+		}
+	}
+	return parentAddr, rootComplex
+}
@@ -0,0 +1,147 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package pcidev
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	fakesysfs "github.com/ffromani/numalign/pkg/topologyinfo/sysfs/fake"
+)
+
+func makeDevAttrs(class string) map[string]string {
+	return fakesysfs.MakeAttrs(map[string]string{
+		"numa_node": "0",
+		"class":     class,
+		"vendor":    "0x8086",
+		"device":    "0x1234",
+	})
+}
+
+func TestHierarchy(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+	t.Logf("sysfs at %q", fs.Base())
+
+	// root port -> switch (upstream port -> 2 downstream ports) -> 2 NICs
+	// root port -> NVMe drive
+	// integrated endpoint
+	rc := fs.AddTree("sys", "devices", "pci0000:00")
+	usp := rc.Add("0000:00:01.0", makeDevAttrs("0x060400")).Add("0000:01:00.0", makeDevAttrs("0x060400"))
+	usp.Add("0000:02:00.0", makeDevAttrs("0x060400")).Add("0000:03:00.0", makeDevAttrs("0x020000"))
+	usp.Add("0000:02:01.0", makeDevAttrs("0x060400")).Add("0000:04:00.0", makeDevAttrs("0x020000"))
+	rc.Add("0000:00:02.0", makeDevAttrs("0x060400")).Add("0000:05:00.0", makeDevAttrs("0x010802"))
+	rc.Add("0000:00:1f.3", makeDevAttrs("0x040300"))
+
+	links := map[string]string{
+		"0000:00:01.0": "pci0000:00/0000:00:01.0",
+		"0000:01:00.0": "pci0000:00/0000:00:01.0/0000:01:00.0",
+		"0000:02:00.0": "pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:00.0",
+		"0000:02:01.0": "pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:01.0",
+		"0000:03:00.0": "pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:00.0/0000:03:00.0",
+		"0000:04:00.0": "pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:01.0/0000:04:00.0",
+		"0000:00:02.0": "pci0000:00/0000:00:02.0",
+		"0000:05:00.0": "pci0000:00/0000:00:02.0/0000:05:00.0",
+		"0000:00:1f.3": "pci0000:00/0000:00:1f.3",
+	}
+	sysDevs := fs.AddTree("sys", "bus", "pci", "devices")
+	for addr, path := range links {
+		sysDevs.AddLink(addr, filepath.Join("..", "..", "..", "devices", path))
+	}
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	pciDevs, err := NewPCIDevices(filepath.Join(fs.Base(), "sys"))
+	if err != nil {
+		t.Fatalf("error in NewPCIDevices: %v", err)
+	}
+
+	type devRole struct {
+		parent   string
+		portType PortType
+	}
+	expected := map[string]devRole{
+		"0000:00:01.0": {"", PortTypeRootPort},
+		"0000:01:00.0": {"0000:00:01.0", PortTypeUpstreamPort},
+		"0000:02:00.0": {"0000:01:00.0", PortTypeDownstreamPort},
+		"0000:02:01.0": {"0000:01:00.0", PortTypeDownstreamPort},
+		"0000:03:00.0": {"0000:02:00.0", PortTypeEndpoint},
+		"0000:04:00.0": {"0000:02:01.0", PortTypeEndpoint},
+		"0000:00:02.0": {"", PortTypeRootPort},
+		"0000:05:00.0": {"0000:00:02.0", PortTypeEndpoint},
+		"0000:00:1f.3": {"", PortTypeEndpoint},
+	}
+	got := make(map[string]devRole)
+	for _, devInfo := range pciDevs.Items {
+		got[devInfo.Address()] = devRole{devInfo.ParentAddress(), devInfo.PortType()}
+		if devInfo.RootComplex() != "pci0000:00" {
+			t.Errorf("unexpected root complex for %s: %q", devInfo.Address(), devInfo.RootComplex())
+		}
+	}
+	if !cmp.Equal(got, expected, cmp.AllowUnexported(devRole{})) {
+		t.Errorf("hierarchy mismatch: %v", cmp.Diff(got, expected, cmp.AllowUnexported(devRole{})))
+	}
+
+	var addrs []string
+	for _, devInfo := range pciDevs.Children("0000:01:00.0") {
+		addrs = append(addrs, devInfo.Address())
+	}
+	if !cmp.Equal(addrs, []string{"0000:02:00.0", "0000:02:01.0"}) {
+		t.Errorf("unexpected children: %v", addrs)
+	}
+
+	for _, addr := range []string{"0000:03:00.0", "0000:04:00.0"} {
+		if rp, ok := pciDevs.RootPort(addr); !ok || rp.Address() != "0000:00:01.0" {
+			t.Errorf("unexpected root port for %s: %v", addr, rp)
+		}
+		if sw, ok := pciDevs.Switch(addr); !ok || sw.Address() != "0000:01:00.0" {
+			t.Errorf("unexpected switch for %s: %v", addr, sw)
+		}
+	}
+	if _, ok := pciDevs.Switch("0000:05:00.0"); ok {
+		t.Errorf("unexpected switch for a device behind a root port")
+	}
+	if _, ok := pciDevs.RootPort("0000:00:1f.3"); ok {
+		t.Errorf("unexpected root port for an integrated endpoint")
+	}
+	if parent, ok := pciDevs.Parent("0000:05:00.0"); !ok || parent.Address() != "0000:00:02.0" {
+		t.Errorf("unexpected parent: %v", parent)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package pcidev
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// PathClassNet is the subpath which holds the network interfaces
+	PathClassNet = "class/net"
+)
+
+// ErrNoPCIDevice is returned when a network interface is not backed by a PCI device, like veth or macvlan
+var ErrNoPCIDevice = errors.New("no PCI device backing")
+
+// FindByNetdev returns the PCI device backing a network interface, following <sysfs>/class/net/<name>/device.
+// Returns ErrNoPCIDevice if the interface is purely virtual or if its device is not a PCI device.
+func (pd PCIDevices) FindByNetdev(sysfs, name string) (PCIDeviceInfo, error) {
+	netPath := filepath.Join(sysfs, PathClassNet, name)
+	if _, err := os.Stat(netPath); err != nil {
+		return nil, err
+	}
+	devLink := filepath.Join(netPath, "device")
+	dest, err := os.Readlink(devLink)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoPCIDevice
+		}
+		return nil, err
+	}
+	if devInfo, found := pd.FindByAddress(filepath.Base(dest)); found {
+		return devInfo, nil
+	}
+
+	// the device may be a child of the PCI device, like virtio devices: walk up the resolved path
+	realPath, err := filepath.EvalSymlinks(devLink)
+	if err != nil {
+		return nil, err
+	}
+	return pd.FindByPath(filepath.Dir(realPath))
+}
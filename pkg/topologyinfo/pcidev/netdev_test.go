@@ -0,0 +1,101 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package pcidev
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	fakesysfs "github.com/ffromani/numalign/pkg/topologyinfo/sysfs/fake"
+)
+
+func TestFindByNetdev(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+	t.Logf("sysfs at %q", fs.Base())
+
+	sysDevs := fs.AddTree("sys", "bus", "pci", "devices")
+	sysDevs.Add("0000:3b:02.1", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node": "1",
+		"class":     "0x020000",
+		"vendor":    "0x8086",
+		"device":    "0x154c",
+	}))
+	virtioDev := sysDevs.Add("0000:00:03.0", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node": "0",
+		"class":     "0x020000",
+		"vendor":    "0x1af4",
+		"device":    "0x1000",
+	}))
+	virtioDev.Add("virtio0", nil)
+
+	classNet := fs.AddTree("sys", "class", "net")
+	classNet.Add("net1", nil).AddLink("device", "../../../bus/pci/devices/0000:3b:02.1")
+	classNet.Add("eth0", nil).AddLink("device", "../../../bus/pci/devices/0000:00:03.0/virtio0")
+	classNet.Add("veth0", nil)
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	sysfs := filepath.Join(fs.Base(), "sys")
+	pciDevs, err := NewPCIDevices(sysfs)
+	if err != nil {
+		t.Fatalf("error in NewPCIDevices: %v", err)
+	}
+
+	for name, addr := range map[string]string{
+		"net1": "0000:3b:02.1",
+		"eth0": "0000:00:03.0",
+	} {
+		devInfo, err := pciDevs.FindByNetdev(sysfs, name)
+		if err != nil {
+			t.Errorf("error resolving %q: %v", name, err)
+			continue
+		}
+		if devInfo.Address() != addr {
+			t.Errorf("%q resolved to %q expected %q", name, devInfo.Address(), addr)
+		}
+	}
+
+	if _, err := pciDevs.FindByNetdev(sysfs, "veth0"); !errors.Is(err, ErrNoPCIDevice) {
+		t.Errorf("unexpected error resolving virtual interface: %v", err)
+	}
+	if _, err := pciDevs.FindByNetdev(sysfs, "missing0"); err == nil || errors.Is(err, ErrNoPCIDevice) {
+		t.Errorf("unexpected error resolving missing interface: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package pcidev
+
+// NUMASource tells where the NUMA node of a device comes from
+type NUMASource string
+
+const (
+	// NUMASourceNone means the NUMA node could not be found
+	NUMASourceNone NUMASource = ""
+	// NUMASourceFirmware means the NUMA node is the one reported by the kernel, from the firmware tables
+	NUMASourceFirmware NUMASource = "firmware"
+	// NUMASourceLocalCPUs means all the CPUs local to the device are on the same NUMA node
+	NUMASourceLocalCPUs NUMASource = "local_cpulist"
+	// NUMASourceParent means the NUMA node is the one of the nearest bridge or port above the device which reports one
+	NUMASourceParent NUMASource = "parent"
+	// NUMASourceRootComplex means all the other devices of the same root complex which report a NUMA node agree on it
+	NUMASourceRootComplex NUMASource = "root-complex"
+)
+
+// NUMAConfidence tells how much an inferred NUMA node can be trusted
+type NUMAConfidence string
+
+const (
+	NUMAConfidenceHigh   NUMAConfidence = "high"
+	NUMAConfidenceMedium NUMAConfidence = "medium"
+	NUMAConfidenceLow    NUMAConfidence = "low"
+	NUMAConfidenceNone   NUMAConfidence = "none"
+)
+
+// NUMAInference is the likely NUMA node of a device
+type NUMAInference struct {
+	// Reported is the NUMA node reported by the kernel, NUMANodeUnknown (-1) if missing.
+	// This is usually a firmware bug, see: https://access.redhat.com/solutions/435313
+	Reported int
+	// Node is the likely NUMA node of the device, NUMANodeUnknown (-1) if it cannot be inferred
+	Node       int
+	Source     NUMASource
+	Confidence NUMAConfidence
+}
+
+// Inferred returns true if the NUMA node is not the reported one, but was inferred by other sources
+func (ni NUMAInference) Inferred() bool {
+	return ni.Source != NUMASourceFirmware && ni.Source != NUMASourceNone
+}
+
+// InferNUMANode returns the likely NUMA node of a device. If the kernel reports no NUMA node, tries in order:
+// the CPUs local to the device, the nearest bridge or port above the device reporting a NUMA node,
+// the NUMA node of the other devices of the same root complex. cpusPerNode are the CPUs of each NUMA node.
+// Returns false if the device is unknown.
+func (pd PCIDevices) InferNUMANode(addr string, cpusPerNode map[int][]int) (NUMAInference, bool) {
+	devInfo, ok := pd.FindByAddress(addr)
+	if !ok {
+		return NUMAInference{}, false
+	}
+	ni := NUMAInference{
+		Reported:   devInfo.NUMANode(),
+		Node:       devInfo.NUMANode(),
+		Source:     NUMASourceFirmware,
+		Confidence: NUMAConfidenceHigh,
+	}
+	if ni.Reported != NUMANodeUnknown {
+		return ni, true
+	}
+
+	if node, ok := nodeOfCPUs(devInfo.LocalCPUs(), cpusPerNode); ok {
+		ni.Node, ni.Source, ni.Confidence = node, NUMASourceLocalCPUs, NUMAConfidenceHigh
+		return ni, true
+	}
+
+	for parent, ok := pd.Parent(addr); ok; parent, ok = pd.Parent(parent.Address()) {
+		if parent.NUMANode() != NUMANodeUnknown {
+			ni.Node, ni.Source, ni.Confidence = parent.NUMANode(), NUMASourceParent, NUMAConfidenceMedium
+			return ni, true
+		}
+	}
+
+	if node, ok := pd.nodeOfRootComplex(devInfo.RootComplex()); ok {
+		ni.Node, ni.Source, ni.Confidence = node, NUMASourceRootComplex, NUMAConfidenceLow
+		return ni, true
+	}
+
+	ni.Source, ni.Confidence = NUMASourceNone, NUMAConfidenceNone
+	return ni, true
+}
+
+// nodeOfCPUs returns the NUMA node holding all the given CPUs. When the firmware reports no NUMA node
+// for a device, the kernel usually reports all the CPUs as local to it, which is inconclusive.
+func nodeOfCPUs(cpuIDs []int, cpusPerNode map[int][]int) (int, bool) {
+	if len(cpuIDs) == 0 {
+		return NUMANodeUnknown, false
+	}
+	cpuToNode := make(map[int]int)
+	for node, nodeCPUs := range cpusPerNode {
+		for _, cpuID := range nodeCPUs {
+			cpuToNode[cpuID] = node
+		}
+	}
+	found := NUMANodeUnknown
+	for _, cpuID := range cpuIDs {
+		node, ok := cpuToNode[cpuID]
+		if !ok || (found != NUMANodeUnknown && node != found) {
+			return NUMANodeUnknown, false
+		}
+		found = node
+	}
+	return found, true
+}
+
+// nodeOfRootComplex returns the NUMA node all the devices of a root complex reporting one agree on
+func (pd PCIDevices) nodeOfRootComplex(rootComplex string) (int, bool) {
+	if rootComplex == "" {
+		return NUMANodeUnknown, false
+	}
+	found := NUMANodeUnknown
+	for _, devInfo := range pd.Items {
+		if devInfo.RootComplex() != rootComplex || devInfo.NUMANode() == NUMANodeUnknown {
+			continue
+		}
+		if found != NUMANodeUnknown && devInfo.NUMANode() != found {
+			return NUMANodeUnknown, false
+		}
+		found = devInfo.NUMANode()
+	}
+	return found, found != NUMANodeUnknown
+}
@@ -0,0 +1,120 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package pcidev
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	fakesysfs "github.com/ffromani/numalign/pkg/topologyinfo/sysfs/fake"
+)
+
+func TestInferNUMANode(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+	t.Logf("sysfs at %q", fs.Base())
+
+	makeAttrs := func(class, numaNode, localCPUs string) map[string]string {
+		return fakesysfs.MakeAttrs(map[string]string{
+			"numa_node":     numaNode,
+			"class":         class,
+			"vendor":        "0x8086",
+			"device":        "0x1234",
+			"local_cpulist": localCPUs,
+		})
+	}
+
+	// pci0000:00: root port on node 0, NIC behind it not reporting
+	rc0 := fs.AddTree("sys", "devices", "pci0000:00")
+	rc0.Add("0000:00:01.0", makeAttrs("0x060400", "0", "0-3")).Add("0000:01:00.0", makeAttrs("0x020000", "-1", "0-7"))
+	// pci0000:80: one device reporting node 1, one not reporting
+	rc80 := fs.AddTree("sys", "devices", "pci0000:80")
+	rc80.Add("0000:80:01.0", makeAttrs("0x010802", "1", "4-7"))
+	rc80.Add("0000:80:02.0", makeAttrs("0x020000", "-1", "0-7"))
+	// pci0000:c0: no device reporting a node, one with meaningful local CPUs
+	rcC0 := fs.AddTree("sys", "devices", "pci0000:c0")
+	rcC0.Add("0000:c0:01.0", makeAttrs("0x020000", "-1", "4-5"))
+	rcC0.Add("0000:c0:02.0", makeAttrs("0x020000", "-1", "0-7"))
+
+	links := map[string]string{
+		"0000:00:01.0": "pci0000:00/0000:00:01.0",
+		"0000:01:00.0": "pci0000:00/0000:00:01.0/0000:01:00.0",
+		"0000:80:01.0": "pci0000:80/0000:80:01.0",
+		"0000:80:02.0": "pci0000:80/0000:80:02.0",
+		"0000:c0:01.0": "pci0000:c0/0000:c0:01.0",
+		"0000:c0:02.0": "pci0000:c0/0000:c0:02.0",
+	}
+	sysDevs := fs.AddTree("sys", "bus", "pci", "devices")
+	for addr, path := range links {
+		sysDevs.AddLink(addr, filepath.Join("..", "..", "..", "devices", path))
+	}
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	pciDevs, err := NewPCIDevices(filepath.Join(fs.Base(), "sys"))
+	if err != nil {
+		t.Fatalf("error in NewPCIDevices: %v", err)
+	}
+
+	cpusPerNode := map[int][]int{
+		0: {0, 1, 2, 3},
+		1: {4, 5, 6, 7},
+	}
+	for addr, expected := range map[string]NUMAInference{
+		"0000:80:01.0": {Reported: 1, Node: 1, Source: NUMASourceFirmware, Confidence: NUMAConfidenceHigh},
+		"0000:01:00.0": {Reported: -1, Node: 0, Source: NUMASourceParent, Confidence: NUMAConfidenceMedium},
+		"0000:80:02.0": {Reported: -1, Node: 1, Source: NUMASourceRootComplex, Confidence: NUMAConfidenceLow},
+		"0000:c0:01.0": {Reported: -1, Node: 1, Source: NUMASourceLocalCPUs, Confidence: NUMAConfidenceHigh},
+		"0000:c0:02.0": {Reported: -1, Node: -1, Source: NUMASourceNone, Confidence: NUMAConfidenceNone},
+	} {
+		got, ok := pciDevs.InferNUMANode(addr, cpusPerNode)
+		if !ok {
+			t.Errorf("device %s not found", addr)
+			continue
+		}
+		if !cmp.Equal(got, expected) {
+			t.Errorf("inference mismatch for %s: %v", addr, cmp.Diff(got, expected))
+		}
+	}
+
+	if _, ok := pciDevs.InferNUMANode("0000:ff:00.0", cpusPerNode); ok {
+		t.Errorf("missing device found")
+	}
+}
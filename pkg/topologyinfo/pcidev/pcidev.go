@@ -23,16 +23,23 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+
+	"github.com/ffromani/cpuset"
+
+	"github.com/ffromani/numalign/pkg/pciids"
 )
 
 const (
 	// PathBusPCIDevices is the subpath which holds informations about the PCI(-express) devices
 	PathBusPCIDevices = "bus/pci/devices/"
 	NUMANodeUnknown   = -1
+	// IOMMUGroupNone is reported by devices not in any IOMMU group, like when the IOMMU is disabled
+	IOMMUGroupNone = -1
 )
 
 const (
-	DevClassNetwork int64 = 0x0200
+	DevClassNetwork   int64 = 0x0200
+	DevClassPCIBridge int64 = 0x0604
 )
 
 // PCIDeviceInfo represents the information about a single PCI(-express) device
@@ -55,7 +62,79 @@ type PCIDeviceInfo interface {
 	SysfsPath() string
 	// NUMANode returns the NUMA node id on which this device is attached to
 	NUMANode() int
-	// TODO: driver
+	// Driver is the name of the driver bound to this device, like "vfio-pci". Empty if unbound.
+	Driver() string
+	// IOMMUGroup is the IOMMU group of this device, IOMMUGroupNone if not in any group
+	IOMMUGroup() int
+	// LocalCPUs are the CPUs local to this device, as reported by the kernel
+	LocalCPUs() []int
+	// SubsystemVendor is the PCI subsystem vendor identifier, as integer
+	SubsystemVendor() int64
+	// SubsystemDevice is the PCI subsystem device identifier, as integer
+	SubsystemDevice() int64
+	// Link is the PCI-express link status of this device
+	Link() LinkInfo
+	// VendorName is the name of the vendor, empty if unknown or if the names were not set (see SetNames)
+	VendorName() string
+	// DeviceName is the name of the device, empty if unknown or if the names were not set (see SetNames)
+	DeviceName() string
+	// SubsystemName is the name of the subsystem, empty if unknown or if the names were not set (see SetNames)
+	SubsystemName() string
+	// ClassName is the name of the device class, empty if unknown or if the names were not set (see SetNames)
+	ClassName() string
+	// ParentAddress is the FULL PCI address of the bridge (or port) this device sits behind, empty if attached to the root complex
+	ParentAddress() string
+	// RootComplex is the root complex (host bridge) this device belongs to, like "pci0000:00". Empty if unknown.
+	RootComplex() string
+	// PortType is the role of this device in the PCI(-express) hierarchy
+	PortType() PortType
+}
+
+// Describe returns a lspci-like description of the device, like "Ethernet controller: Intel Corporation I350 Gigabit Network Connection".
+// Returns empty string if the device names are unknown.
+func Describe(devInfo PCIDeviceInfo) string {
+	var names []string
+	for _, name := range []string{devInfo.VendorName(), devInfo.DeviceName()} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	desc := strings.Join(names, " ")
+	if className := devInfo.ClassName(); className != "" {
+		if desc == "" {
+			return className
+		}
+		desc = className + ": " + desc
+	}
+	return desc
+}
+
+// LinkInfo represents the PCI-express link status of a device.
+// Conventional PCI devices and most virtual functions report no link: speeds are empty and widths are zero.
+type LinkInfo struct {
+	// CurrentSpeed is the negotiated link speed, like "8.0 GT/s PCIe"
+	CurrentSpeed string
+	// CurrentWidth is the negotiated number of lanes
+	CurrentWidth int
+	// MaxSpeed is the maximum link speed supported by the device
+	MaxSpeed string
+	// MaxWidth is the maximum number of lanes supported by the device
+	MaxWidth int
+}
+
+// Degraded returns true if the link trained below the capabilities of the device
+func (li LinkInfo) Degraded() bool {
+	if li.CurrentWidth < li.MaxWidth {
+		return true
+	}
+	return li.CurrentSpeed != li.MaxSpeed
+}
+
+func (li LinkInfo) String() string {
+	if li.MaxSpeed == "" && li.MaxWidth == 0 {
+		return "N/A"
+	}
+	return fmt.Sprintf("%s x%d (max %s x%d)", li.CurrentSpeed, li.CurrentWidth, li.MaxSpeed, li.MaxWidth)
 }
 
 // PCIDeviceInfoList is a list of PCIDeviceInfo
@@ -75,6 +154,21 @@ func (pd PCIDevices) FindByAddress(addr string) (PCIDeviceInfo, bool) {
 	return SRIOVDeviceInfo{}, false
 }
 
+// SetNames sets the vendor, device, subsystem and class names of all the devices, looking them up in a pci.ids database
+func (pd *PCIDevices) SetNames(db *pciids.DB) {
+	for idx, devInfo := range pd.Items {
+		sdi, ok := devInfo.(SRIOVDeviceInfo)
+		if !ok {
+			continue
+		}
+		sdi.vendorName = db.Vendor(sdi.vendor)
+		sdi.deviceName = db.Device(sdi.vendor, sdi.device)
+		sdi.subsysName = db.Subsystem(sdi.vendor, sdi.device, sdi.subVendor, sdi.subDevice)
+		sdi.className = db.Class(sdi.devClass)
+		pd.Items[idx] = sdi
+	}
+}
+
 func (pd PCIDevices) PerNUMA() map[int]PCIDeviceInfoList {
 	numaNodePCIDevs := make(map[int]PCIDeviceInfoList)
 
@@ -97,16 +191,29 @@ func NewPCIDevices(sysfs string) (*PCIDevices, error) {
 		return nil, err
 	}
 
+	knownAddrs := make(map[string]bool)
+	for _, entry := range entries {
+		knownAddrs[entry.Name()] = true
+	}
+
 	for _, entry := range entries {
 		devPath := filepath.Join(sysfsPath, entry.Name())
+		realPath, err := filepath.EvalSymlinks(devPath)
+		if err != nil {
+			return nil, err
+		}
+		parentAddr, rootComplex := findAncestors(realPath, knownAddrs)
+
 		isPhysFn := false
 		isVFn := false
 		numVfs := 0
+		totalVfs := 0
 		parentFn := ""
 		numvfsPath := filepath.Join(devPath, "sriov_numvfs")
 		if _, err := os.Stat(numvfsPath); err == nil {
 			isPhysFn = true
 			numVfs, _ = readInt(numvfsPath)
+			totalVfs, _ = readInt(filepath.Join(devPath, "sriov_totalvfs"))
 		} else if !os.IsNotExist(err) {
 			// unexpected error. Bail out
 			return nil, err
@@ -125,6 +232,7 @@ func NewPCIDevices(sysfs string) (*PCIDevices, error) {
 		nodeNum, err := readInt(filepath.Join(devPath, "numa_node"))
 		// nodeNum may be -1 (minus-one). This is bad, and likely a firmware bug, see:
 		// https://access.redhat.com/solutions/435313
+		// InferNUMANode can find the likely node from other sources.
 
 		devClass, err := readHexInt64(filepath.Join(devPath, "class"))
 		if err != nil {
@@ -139,24 +247,40 @@ func NewPCIDevices(sysfs string) (*PCIDevices, error) {
 			return nil, err
 		}
 
+		// all the following attributes are optional: they depend on the kernel, the device type and the binding
+		subVendor, _ := readHexInt64(filepath.Join(devPath, "subsystem_vendor"))
+		subDevice, _ := readHexInt64(filepath.Join(devPath, "subsystem_device"))
+		localCPUs, _ := readCPUList(filepath.Join(devPath, "local_cpulist"))
+
 		devInfo := SRIOVDeviceInfo{
-			IsPhysFn:  isPhysFn,
-			NumVFS:    numVfs,
-			IsVFn:     isVFn,
-			ParentFn:  parentFn,
-			address:   entry.Name(),
-			numaNode:  nodeNum,
-			devClass:  (devClass >> 8), // pciutils lib/sysfs.c
-			vendor:    vendor,
-			device:    device,
-			sysfsPath: devPath,
+			IsPhysFn:   isPhysFn,
+			NumVFS:     numVfs,
+			TotalVFS:   totalVfs,
+			IsVFn:      isVFn,
+			ParentFn:   parentFn,
+			address:    entry.Name(),
+			numaNode:   nodeNum,
+			devClass:   (devClass >> 8), // pciutils lib/sysfs.c
+			vendor:     vendor,
+			device:     device,
+			subVendor:  subVendor,
+			subDevice:  subDevice,
+			driver:     readLinkBase(filepath.Join(devPath, "driver")),
+			iommuGroup: readIOMMUGroup(filepath.Join(devPath, "iommu_group")),
+			localCPUs:  localCPUs,
+			link:       readLinkInfo(devPath),
+			parentAddr: parentAddr,
+			rootCplx:   rootComplex,
+			sysfsPath:  devPath,
 		}
 		allPCIDevs = append(allPCIDevs, devInfo)
 	}
 
-	return &PCIDevices{
+	pd := &PCIDevices{
 		Items: allPCIDevs,
-	}, nil
+	}
+	pd.setPortTypes()
+	return pd, nil
 
 }
 
@@ -166,16 +290,31 @@ type SRIOVDeviceInfo struct {
 	IsPhysFn bool
 	// NumVFS is the NUMber of Virtual Functions this device have configured, if IsPhysFn=true. Meaningless otherwise
 	NumVFS int // only PFs
+	// TotalVFS is the maximum number of Virtual Functions this device supports, if IsPhysFn=true. Meaningless otherwise
+	TotalVFS int // only PFs
 	// IsVFn is true if this device is a Virtual FunctioN
 	IsVFn bool
 	// ParentFn is the bus_id:device_id PCI(-express) address of the parent Physical Function, if IsVFn=true. Meaningless otherwise.
-	ParentFn  string // only VFs
-	address   string
-	numaNode  int
-	devClass  int64
-	vendor    int64
-	device    int64
-	sysfsPath string
+	ParentFn   string // only VFs
+	address    string
+	numaNode   int
+	devClass   int64
+	vendor     int64
+	device     int64
+	subVendor  int64
+	subDevice  int64
+	driver     string
+	iommuGroup int
+	localCPUs  []int
+	link       LinkInfo
+	vendorName string
+	deviceName string
+	subsysName string
+	className  string
+	parentAddr string
+	rootCplx   string
+	portType   PortType
+	sysfsPath  string
 }
 
 // SysfsPath returns the path on sysfs of this device
@@ -220,8 +359,77 @@ func (sdi SRIOVDeviceInfo) Device() int64 {
 	return sdi.device
 }
 
+// Driver is the name of the driver bound to this device, like "vfio-pci". Empty if unbound.
+func (sdi SRIOVDeviceInfo) Driver() string {
+	return sdi.driver
+}
+
+// IOMMUGroup is the IOMMU group of this device, IOMMUGroupNone if not in any group
+func (sdi SRIOVDeviceInfo) IOMMUGroup() int {
+	return sdi.iommuGroup
+}
+
+// LocalCPUs are the CPUs local to this device, as reported by the kernel
+func (sdi SRIOVDeviceInfo) LocalCPUs() []int {
+	return sdi.localCPUs
+}
+
+// SubsystemVendor is the PCI subsystem vendor identifier, as integer
+func (sdi SRIOVDeviceInfo) SubsystemVendor() int64 {
+	return sdi.subVendor
+}
+
+// SubsystemDevice is the PCI subsystem device identifier, as integer
+func (sdi SRIOVDeviceInfo) SubsystemDevice() int64 {
+	return sdi.subDevice
+}
+
+// Link is the PCI-express link status of this device
+func (sdi SRIOVDeviceInfo) Link() LinkInfo {
+	return sdi.link
+}
+
+// VendorName is the name of the vendor, empty if unknown or if the names were not set (see SetNames)
+func (sdi SRIOVDeviceInfo) VendorName() string {
+	return sdi.vendorName
+}
+
+// DeviceName is the name of the device, empty if unknown or if the names were not set (see SetNames)
+func (sdi SRIOVDeviceInfo) DeviceName() string {
+	return sdi.deviceName
+}
+
+// SubsystemName is the name of the subsystem, empty if unknown or if the names were not set (see SetNames)
+func (sdi SRIOVDeviceInfo) SubsystemName() string {
+	return sdi.subsysName
+}
+
+// ClassName is the name of the device class, empty if unknown or if the names were not set (see SetNames)
+func (sdi SRIOVDeviceInfo) ClassName() string {
+	return sdi.className
+}
+
+// ParentAddress is the FULL PCI address of the bridge (or port) this device sits behind, empty if attached to the root complex
+func (sdi SRIOVDeviceInfo) ParentAddress() string {
+	return sdi.parentAddr
+}
+
+// RootComplex is the root complex (host bridge) this device belongs to, like "pci0000:00". Empty if unknown.
+func (sdi SRIOVDeviceInfo) RootComplex() string {
+	return sdi.rootCplx
+}
+
+// PortType is the role of this device in the PCI(-express) hierarchy
+func (sdi SRIOVDeviceInfo) PortType() PortType {
+	return sdi.portType
+}
+
 func (sdi SRIOVDeviceInfo) String() string {
-	return fmt.Sprintf("pci@%s %x:%x numa_node=%d physfn=%v vfn=%v", sdi.address, sdi.vendor, sdi.device, sdi.numaNode, sdi.IsPhysFn, sdi.IsVFn)
+	desc := fmt.Sprintf("pci@%s %x:%x numa_node=%d physfn=%v vfn=%v", sdi.address, sdi.vendor, sdi.device, sdi.numaNode, sdi.IsPhysFn, sdi.IsVFn)
+	if names := Describe(sdi); names != "" {
+		desc += " [" + names + "]"
+	}
+	return desc
 }
 
 func readHexInt64(path string) (int64, error) {
@@ -239,3 +447,39 @@ func readInt(path string) (int, error) {
 	}
 	return strconv.Atoi(strings.TrimSpace(string(content)))
 }
+
+func readCPUList(path string) ([]int, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return cpuset.Parse(strings.TrimSpace(string(content)))
+}
+
+// readLinkBase returns the basename of the target of an optional symlink, empty if missing
+func readLinkBase(path string) string {
+	dest, err := os.Readlink(path)
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(dest)
+}
+
+func readIOMMUGroup(path string) int {
+	group, err := strconv.Atoi(readLinkBase(path))
+	if err != nil {
+		return IOMMUGroupNone
+	}
+	return group
+}
+
+func readLinkInfo(devPath string) LinkInfo {
+	curWidth, _ := readInt(filepath.Join(devPath, "current_link_width"))
+	maxWidth, _ := readInt(filepath.Join(devPath, "max_link_width"))
+	return LinkInfo{
+		CurrentSpeed: readString(filepath.Join(devPath, "current_link_speed")),
+		CurrentWidth: curWidth,
+		MaxSpeed:     readString(filepath.Join(devPath, "max_link_speed")),
+		MaxWidth:     maxWidth,
+	}
+}
@@ -4,9 +4,13 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ffromani/numalign/pkg/pciids"
 	fakesysfs "github.com/ffromani/numalign/pkg/topologyinfo/sysfs/fake"
 )
 
@@ -52,6 +56,15 @@ func TestPCIDevsTrivialTree(t *testing.T) {
 		t.Errorf("device misdetected: %v", pciDev.String())
 	}
 
+	db, err := pciids.Parse(strings.NewReader("10ec  Realtek Semiconductor Co., Ltd.\n\t8168  RTL8111/8168/8411 PCI Express Gigabit Ethernet Controller\nC 02  Network controller\n\t00  Ethernet controller\n"))
+	if err != nil {
+		t.Errorf("error parsing PCI IDs: %v", err)
+	}
+	pciDevs.SetNames(db)
+	if pciDevs.Items[0].String() != "pci@0000:07:00.0 10ec:8168 numa_node=0 physfn=false vfn=false [Ethernet controller: Realtek Semiconductor Co., Ltd. RTL8111/8168/8411 PCI Express Gigabit Ethernet Controller]" {
+		t.Errorf("device names not set: %v", pciDevs.Items[0].String())
+	}
+
 	if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
 		t.Logf("found environment variable, keeping fake tree")
 	} else {
@@ -61,3 +74,111 @@ func TestPCIDevsTrivialTree(t *testing.T) {
 		}
 	}
 }
+
+func TestPCIDevsAttributes(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+	t.Logf("sysfs at %q", fs.Base())
+
+	sysDevs := fs.AddTree("sys", "bus", "pci", "devices")
+	sysDevs.Add("0000:3b:00.0", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node":          "0",
+		"class":              "0x020000",
+		"vendor":             "0x8086",
+		"device":             "0x159b",
+		"subsystem_vendor":   "0x8086",
+		"subsystem_device":   "0x0003",
+		"local_cpulist":      "0-3,8-11",
+		"current_link_speed": "8.0 GT/s PCIe",
+		"current_link_width": "8",
+		"max_link_speed":     "16.0 GT/s PCIe",
+		"max_link_width":     "16",
+		"sriov_numvfs":       "2",
+		"sriov_totalvfs":     "64",
+	})).
+		AddLink("driver", "../../../bus/pci/drivers/ice").
+		AddLink("iommu_group", "../../../kernel/iommu_groups/42")
+	sysDevs.Add("0000:3b:01.0", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node": "0",
+		"class":     "0x020000",
+		"vendor":    "0x8086",
+		"device":    "0x1889",
+	})).
+		AddLink("driver", "../../../bus/pci/drivers/vfio-pci").
+		AddLink("physfn", "../0000:3b:00.0")
+	sysDevs.Add("0000:00:1f.3", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node": "-1",
+		"class":     "0x040300",
+		"vendor":    "0x8086",
+		"device":    "0xa348",
+	}))
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	pciDevs, err := NewPCIDevices(filepath.Join(fs.Base(), "sys"))
+	if err != nil {
+		t.Fatalf("error in NewPCIDevices: %v", err)
+	}
+
+	pf, ok := pciDevs.FindByAddress("0000:3b:00.0")
+	if !ok {
+		t.Fatalf("missing PF")
+	}
+	if pf.Driver() != "ice" || pf.IOMMUGroup() != 42 {
+		t.Errorf("unexpected PF driver=%q iommu_group=%d", pf.Driver(), pf.IOMMUGroup())
+	}
+	if pf.SubsystemVendor() != 0x8086 || pf.SubsystemDevice() != 0x0003 {
+		t.Errorf("unexpected PF subsystem %x:%x", pf.SubsystemVendor(), pf.SubsystemDevice())
+	}
+	if !cmp.Equal(pf.LocalCPUs(), []int{0, 1, 2, 3, 8, 9, 10, 11}) {
+		t.Errorf("unexpected PF local CPUs: %v", pf.LocalCPUs())
+	}
+	expectedLink := LinkInfo{CurrentSpeed: "8.0 GT/s PCIe", CurrentWidth: 8, MaxSpeed: "16.0 GT/s PCIe", MaxWidth: 16}
+	if !cmp.Equal(pf.Link(), expectedLink) {
+		t.Errorf("PF link mismatch: %v", cmp.Diff(pf.Link(), expectedLink))
+	}
+	if !pf.Link().Degraded() {
+		t.Errorf("PF link not reported degraded")
+	}
+	if sriovInfo := pf.(SRIOVDeviceInfo); sriovInfo.NumVFS != 2 || sriovInfo.TotalVFS != 64 {
+		t.Errorf("unexpected PF numvfs=%d totalvfs=%d", sriovInfo.NumVFS, sriovInfo.TotalVFS)
+	}
+
+	vf, ok := pciDevs.FindByAddress("0000:3b:01.0")
+	if !ok {
+		t.Fatalf("missing VF")
+	}
+	if vf.Driver() != "vfio-pci" || vf.IOMMUGroup() != IOMMUGroupNone {
+		t.Errorf("unexpected VF driver=%q iommu_group=%d", vf.Driver(), vf.IOMMUGroup())
+	}
+	if vf.Link().Degraded() || vf.Link().String() != "N/A" {
+		t.Errorf("unexpected VF link: %v", vf.Link())
+	}
+
+	dev, ok := pciDevs.FindByAddress("0000:00:1f.3")
+	if !ok {
+		t.Fatalf("missing device")
+	}
+	if dev.Driver() != "" || dev.LocalCPUs() != nil {
+		t.Errorf("unexpected unbound device driver=%q local CPUs=%v", dev.Driver(), dev.LocalCPUs())
+	}
+}
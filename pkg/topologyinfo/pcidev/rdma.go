@@ -0,0 +1,193 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package pcidev
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PathClassInfiniband is the subpath which holds the RDMA (InfiniBand, RoCE, iWARP) devices
+	PathClassInfiniband = "class/infiniband"
+	// PathClassInfinibandVerbs is the subpath which holds the userspace verbs devices, like uverbs0
+	PathClassInfinibandVerbs = "class/infiniband_verbs"
+)
+
+// RDMAPort is a port of a RDMA device
+type RDMAPort struct {
+	Num int
+	// State is the logical state, like "4: ACTIVE"
+	State string
+	// LinkLayer is "InfiniBand" or "Ethernet" (RoCE)
+	LinkLayer string
+	// Rate is the link rate, like "100 Gb/sec (4X EDR)"
+	Rate string
+}
+
+// RDMADeviceInfo is a RDMA device, like mlx5_0, backed by a PCI function
+type RDMADeviceInfo struct {
+	// Name is the RDMA device name, like "mlx5_0"
+	Name string
+	// Address is the FULL PCI address of the function backing the device
+	Address string
+	// NUMANode is the NUMA node of the PCI function
+	NUMANode int
+	// Uverbs is the userspace verbs device, like "uverbs0", exposed as /dev/infiniband/uverbs0
+	Uverbs string
+	// Netdevs are the network interfaces of the PCI function
+	Netdevs []string
+	Ports   []RDMAPort
+}
+
+func (rdi RDMADeviceInfo) String() string {
+	return fmt.Sprintf("rdma %s pci@%s numa_node=%d uverbs=%s netdevs=%s", rdi.Name, rdi.Address, rdi.NUMANode, rdi.Uverbs, strings.Join(rdi.Netdevs, ","))
+}
+
+// RDMADevices returns all the RDMA devices backed by PCI functions, sorted by name.
+// Returns an empty list if the RDMA core is not loaded.
+func (pd PCIDevices) RDMADevices(sysfs string) ([]RDMADeviceInfo, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(sysfs, PathClassInfiniband))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	uverbs, err := readUverbs(sysfs)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []RDMADeviceInfo
+	for _, entry := range entries {
+		rdi, err := pd.newRDMADeviceInfo(sysfs, entry.Name())
+		if errors.Is(err, ErrNoPCIDevice) {
+			continue // software devices, like rxe or siw over virtual interfaces
+		}
+		if err != nil {
+			return nil, err
+		}
+		rdi.Uverbs = uverbs[rdi.Name]
+		ret = append(ret, rdi)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret, nil
+}
+
+// FindByRDMADevice returns the PCI device backing a RDMA device, following <sysfs>/class/infiniband/<name>/device.
+// Returns ErrNoPCIDevice if the device is not backed by a PCI device.
+func (pd PCIDevices) FindByRDMADevice(sysfs, name string) (PCIDeviceInfo, error) {
+	return pd.findByDeviceLink(filepath.Join(sysfs, PathClassInfiniband, name, "device"))
+}
+
+// FindByUverbs returns the PCI device backing a userspace verbs device, like "uverbs3" or "/dev/infiniband/uverbs3",
+// following <sysfs>/class/infiniband_verbs/<name>/device.
+// Returns ErrNoPCIDevice if the device is not backed by a PCI device.
+func (pd PCIDevices) FindByUverbs(sysfs, name string) (PCIDeviceInfo, error) {
+	return pd.findByDeviceLink(filepath.Join(sysfs, PathClassInfinibandVerbs, filepath.Base(name), "device"))
+}
+
+func (pd PCIDevices) findByDeviceLink(devLink string) (PCIDeviceInfo, error) {
+	if _, err := os.Stat(filepath.Dir(devLink)); err != nil {
+		return nil, err
+	}
+	realPath, err := filepath.EvalSymlinks(devLink)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoPCIDevice
+		}
+		return nil, err
+	}
+	return pd.FindByPath(realPath)
+}
+
+func (pd PCIDevices) newRDMADeviceInfo(sysfs, name string) (RDMADeviceInfo, error) {
+	devInfo, err := pd.FindByRDMADevice(sysfs, name)
+	if err != nil {
+		return RDMADeviceInfo{}, err
+	}
+	rdi := RDMADeviceInfo{
+		Name:     name,
+		Address:  devInfo.Address(),
+		NUMANode: devInfo.NUMANode(),
+	}
+
+	netEntries, err := ioutil.ReadDir(filepath.Join(devInfo.SysfsPath(), "net"))
+	if err != nil && !os.IsNotExist(err) {
+		return rdi, err
+	}
+	for _, entry := range netEntries {
+		rdi.Netdevs = append(rdi.Netdevs, entry.Name())
+	}
+
+	portsPath := filepath.Join(sysfs, PathClassInfiniband, name, "ports")
+	portEntries, err := ioutil.ReadDir(portsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return rdi, err
+	}
+	for _, entry := range portEntries {
+		num, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		portPath := filepath.Join(portsPath, entry.Name())
+		rdi.Ports = append(rdi.Ports, RDMAPort{
+			Num:       num,
+			State:     readString(filepath.Join(portPath, "state")),
+			LinkLayer: readString(filepath.Join(portPath, "link_layer")),
+			Rate:      readString(filepath.Join(portPath, "rate")),
+		})
+	}
+	sort.Slice(rdi.Ports, func(i, j int) bool { return rdi.Ports[i].Num < rdi.Ports[j].Num })
+	return rdi, nil
+}
+
+// readUverbs maps the RDMA device names to their userspace verbs devices
+func readUverbs(sysfs string) (map[string]string, error) {
+	ret := make(map[string]string)
+	verbsPath := filepath.Join(sysfs, PathClassInfinibandVerbs)
+	entries, err := ioutil.ReadDir(verbsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ret, nil
+		}
+		return nil, err
+	}
+	for _, entry := range entries {
+		ibdev := readString(filepath.Join(verbsPath, entry.Name(), "ibdev"))
+		if ibdev != "" {
+			ret[ibdev] = entry.Name()
+		}
+	}
+	return ret, nil
+}
+
+// readString returns the trimmed content of an optional attribute, empty if missing
+func readString(path string) string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
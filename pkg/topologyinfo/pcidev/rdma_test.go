@@ -0,0 +1,126 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package pcidev
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	fakesysfs "github.com/ffromani/numalign/pkg/topologyinfo/sysfs/fake"
+)
+
+func TestRDMADevices(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+	t.Logf("sysfs at %q", fs.Base())
+
+	sysDevs := fs.AddTree("sys", "bus", "pci", "devices")
+	mlxDev := sysDevs.Add("0000:3b:00.0", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node": "1",
+		"class":     "0x020700",
+		"vendor":    "0x15b3",
+		"device":    "0x101b",
+	}))
+	mlxDev.Add("net", nil).Add("ens1f0", nil)
+	ibDev := mlxDev.Add("infiniband", nil).Add("mlx5_0", nil)
+	ibDev.AddLink("device", "../../../0000:3b:00.0")
+	ibDev.Add("ports", nil).Add("1", fakesysfs.MakeAttrs(map[string]string{
+		"state":      "4: ACTIVE",
+		"link_layer": "Ethernet",
+		"rate":       "100 Gb/sec (4X EDR)",
+	}))
+	mlxDev.Add("infiniband_verbs", nil).Add("uverbs0", fakesysfs.MakeAttrs(map[string]string{
+		"ibdev": "mlx5_0",
+	})).AddLink("device", "../../../0000:3b:00.0")
+
+	fs.AddTree("sys", "devices", "virtual", "infiniband").Add("rxe0", nil)
+	fs.AddTree("sys", "class", "infiniband").
+		AddLink("mlx5_0", "../../bus/pci/devices/0000:3b:00.0/infiniband/mlx5_0").
+		AddLink("rxe0", "../../devices/virtual/infiniband/rxe0")
+	fs.AddTree("sys", "class", "infiniband_verbs").
+		AddLink("uverbs0", "../../bus/pci/devices/0000:3b:00.0/infiniband_verbs/uverbs0")
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	sysfs := filepath.Join(fs.Base(), "sys")
+	pciDevs, err := NewPCIDevices(sysfs)
+	if err != nil {
+		t.Fatalf("error in NewPCIDevices: %v", err)
+	}
+
+	rdmaDevs, err := pciDevs.RDMADevices(sysfs)
+	if err != nil {
+		t.Fatalf("error in RDMADevices: %v", err)
+	}
+	expected := []RDMADeviceInfo{
+		{
+			Name:     "mlx5_0",
+			Address:  "0000:3b:00.0",
+			NUMANode: 1,
+			Uverbs:   "uverbs0",
+			Netdevs:  []string{"ens1f0"},
+			Ports: []RDMAPort{
+				{Num: 1, State: "4: ACTIVE", LinkLayer: "Ethernet", Rate: "100 Gb/sec (4X EDR)"},
+			},
+		},
+	}
+	if !cmp.Equal(rdmaDevs, expected) {
+		t.Errorf("RDMA devices mismatch: %v", cmp.Diff(rdmaDevs, expected))
+	}
+
+	for _, name := range []string{"uverbs0", "/dev/infiniband/uverbs0"} {
+		devInfo, err := pciDevs.FindByUverbs(sysfs, name)
+		if err != nil {
+			t.Errorf("error resolving %q: %v", name, err)
+			continue
+		}
+		if devInfo.Address() != "0000:3b:00.0" {
+			t.Errorf("%q resolved to %q", name, devInfo.Address())
+		}
+	}
+
+	if _, err := pciDevs.FindByRDMADevice(sysfs, "rxe0"); !errors.Is(err, ErrNoPCIDevice) {
+		t.Errorf("unexpected error resolving software device: %v", err)
+	}
+	if _, err := pciDevs.FindByRDMADevice(sysfs, "mlx5_9"); err == nil || errors.Is(err, ErrNoPCIDevice) {
+		t.Errorf("unexpected error resolving missing device: %v", err)
+	}
+}
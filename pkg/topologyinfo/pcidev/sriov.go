@@ -0,0 +1,161 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package pcidev
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VFInfo is a SRIOV Virtual Function
+type VFInfo struct {
+	// Index is the VF number, as in the virtfnN link of its Physical Function
+	Index  int
+	Device PCIDeviceInfo
+}
+
+// PFInfo is a SRIOV Physical Function and its Virtual Functions
+type PFInfo struct {
+	Device PCIDeviceInfo
+	// NumVFs is the number of Virtual Functions configured
+	NumVFs int
+	// TotalVFs is the maximum number of Virtual Functions supported
+	TotalVFs int
+	// Offset is the routing ID offset of the first Virtual Function from the Physical Function
+	Offset int
+	// Stride is the routing ID distance between consecutive Virtual Functions
+	Stride int
+	// Netdevs are the network interfaces of the Physical Function
+	Netdevs []string
+	// VFs are the Virtual Functions, sorted by Index
+	VFs []VFInfo
+}
+
+// SRIOVView reports all the SRIOV Physical Functions and their Virtual Functions
+type SRIOVView struct {
+	// PFs are sorted by address
+	PFs []PFInfo
+	// pfIdx maps the PF addresses to their indexes in PFs
+	pfIdx map[string]int
+	// vfToPF maps the VF addresses to the indexes of their PFs in PFs
+	vfToPF map[string]int
+}
+
+// SRIOV returns all the SRIOV Physical Functions with their Virtual Functions, following the virtfnN links
+func (pd PCIDevices) SRIOV() (*SRIOVView, error) {
+	sv := &SRIOVView{
+		pfIdx:  make(map[string]int),
+		vfToPF: make(map[string]int),
+	}
+	byAddr := make(map[string]PCIDeviceInfo, len(pd.Items))
+	for _, devInfo := range pd.Items {
+		byAddr[devInfo.Address()] = devInfo
+	}
+	for _, devInfo := range pd.Items {
+		sdi, ok := devInfo.(SRIOVDeviceInfo)
+		if !ok || !sdi.IsPhysFn {
+			continue
+		}
+		pf, err := newPFInfo(sdi, byAddr)
+		if err != nil {
+			return nil, err
+		}
+		sv.PFs = append(sv.PFs, pf)
+	}
+	sort.Slice(sv.PFs, func(i, j int) bool { return sv.PFs[i].Device.Address() < sv.PFs[j].Device.Address() })
+	for idx, pf := range sv.PFs {
+		sv.pfIdx[pf.Device.Address()] = idx
+		for _, vf := range pf.VFs {
+			sv.vfToPF[vf.Device.Address()] = idx
+		}
+	}
+	return sv, nil
+}
+
+// FindPF returns the Physical Function with the given FULL PCI address
+func (sv SRIOVView) FindPF(pfAddr string) (PFInfo, bool) {
+	idx, ok := sv.pfIdx[pfAddr]
+	if !ok {
+		return PFInfo{}, false
+	}
+	return sv.PFs[idx], true
+}
+
+// VFsOf returns the Virtual Functions of a Physical Function, sorted by index. Empty if pfAddr is not a Physical Function.
+func (sv SRIOVView) VFsOf(pfAddr string) []VFInfo {
+	pf, _ := sv.FindPF(pfAddr)
+	return pf.VFs
+}
+
+// PFOf returns the Physical Function of a Virtual Function
+func (sv SRIOVView) PFOf(vfAddr string) (PFInfo, bool) {
+	idx, ok := sv.vfToPF[vfAddr]
+	if !ok {
+		return PFInfo{}, false
+	}
+	return sv.PFs[idx], true
+}
+
+// newPFInfo reads the Physical Function sdi, looking up its Virtual Functions in byAddr, keyed by FULL PCI address
+func newPFInfo(sdi SRIOVDeviceInfo, byAddr map[string]PCIDeviceInfo) (PFInfo, error) {
+	offset, _ := readInt(filepath.Join(sdi.sysfsPath, "sriov_offset"))
+	stride, _ := readInt(filepath.Join(sdi.sysfsPath, "sriov_stride"))
+	pf := PFInfo{
+		Device:   sdi,
+		NumVFs:   sdi.NumVFS,
+		TotalVFs: sdi.TotalVFS,
+		Offset:   offset,
+		Stride:   stride,
+	}
+
+	netEntries, err := ioutil.ReadDir(filepath.Join(sdi.sysfsPath, "net"))
+	if err != nil && !os.IsNotExist(err) {
+		return pf, err
+	}
+	for _, entry := range netEntries {
+		pf.Netdevs = append(pf.Netdevs, entry.Name())
+	}
+
+	entries, err := ioutil.ReadDir(sdi.sysfsPath)
+	if err != nil {
+		return pf, err
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "virtfn") {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(entry.Name(), "virtfn"))
+		if err != nil {
+			continue
+		}
+		vfAddr := readLinkBase(filepath.Join(sdi.sysfsPath, entry.Name()))
+		vfInfo, ok := byAddr[vfAddr]
+		if !ok {
+			continue
+		}
+		pf.VFs = append(pf.VFs, VFInfo{
+			Index:  index,
+			Device: vfInfo,
+		})
+	}
+	sort.Slice(pf.VFs, func(i, j int) bool { return pf.VFs[i].Index < pf.VFs[j].Index })
+	return pf, nil
+}
@@ -0,0 +1,141 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2021 Red Hat, Inc.
+ */
+
+package pcidev
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	fakesysfs "github.com/ffromani/numalign/pkg/topologyinfo/sysfs/fake"
+)
+
+func TestSRIOV(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := fakesysfs.NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+	t.Logf("sysfs at %q", fs.Base())
+
+	sysDevs := fs.AddTree("sys", "bus", "pci", "devices")
+	pfDev := sysDevs.Add("0000:05:00.0", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node":      "0",
+		"class":          "0x020000",
+		"vendor":         "0x8086",
+		"device":         "0x1521",
+		"sriov_numvfs":   "2",
+		"sriov_totalvfs": "7",
+		"sriov_offset":   "128",
+		"sriov_stride":   "4",
+	}))
+	pfDev.Add("net", nil).Add("eno1", nil)
+	// VFs are interleaved with the ones of other PFs: index order differs from address order
+	pfDev.AddLink("virtfn0", "../0000:05:10.4").AddLink("virtfn1", "../0000:05:10.0")
+	sysDevs.Add("0000:05:00.1", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node":    "0",
+		"class":        "0x020000",
+		"vendor":       "0x8086",
+		"device":       "0x1521",
+		"sriov_numvfs": "0",
+	}))
+	for _, vfAddr := range []string{"0000:05:10.0", "0000:05:10.4"} {
+		sysDevs.Add(vfAddr, fakesysfs.MakeAttrs(map[string]string{
+			"numa_node": "0",
+			"class":     "0x020000",
+			"vendor":    "0x8086",
+			"device":    "0x1520",
+		})).AddLink("physfn", "../0000:05:00.0")
+	}
+	sysDevs.Add("0000:00:1f.3", fakesysfs.MakeAttrs(map[string]string{
+		"numa_node": "0",
+		"class":     "0x040300",
+		"vendor":    "0x8086",
+		"device":    "0xa348",
+	}))
+
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+	defer func() {
+		if _, ok := os.LookupEnv("TOPOLOGYINFO_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	pciDevs, err := NewPCIDevices(filepath.Join(fs.Base(), "sys"))
+	if err != nil {
+		t.Fatalf("error in NewPCIDevices: %v", err)
+	}
+	sv, err := pciDevs.SRIOV()
+	if err != nil {
+		t.Fatalf("error in SRIOV: %v", err)
+	}
+
+	var pfAddrs []string
+	for _, pf := range sv.PFs {
+		pfAddrs = append(pfAddrs, pf.Device.Address())
+	}
+	if !cmp.Equal(pfAddrs, []string{"0000:05:00.0", "0000:05:00.1"}) {
+		t.Errorf("unexpected PFs: %v", pfAddrs)
+	}
+
+	pf, ok := sv.FindPF("0000:05:00.0")
+	if !ok {
+		t.Fatalf("PF not found")
+	}
+	if pf.NumVFs != 2 || pf.TotalVFs != 7 || pf.Offset != 128 || pf.Stride != 4 {
+		t.Errorf("unexpected PF attributes: %+v", pf)
+	}
+	if !cmp.Equal(pf.Netdevs, []string{"eno1"}) {
+		t.Errorf("unexpected PF netdevs: %v", pf.Netdevs)
+	}
+
+	type vfDesc struct {
+		Index   int
+		Address string
+	}
+	var vfs []vfDesc
+	for _, vf := range sv.VFsOf("0000:05:00.0") {
+		vfs = append(vfs, vfDesc{vf.Index, vf.Device.Address()})
+	}
+	if !cmp.Equal(vfs, []vfDesc{{0, "0000:05:10.4"}, {1, "0000:05:10.0"}}) {
+		t.Errorf("unexpected VFs: %v", vfs)
+	}
+	if len(sv.VFsOf("0000:05:00.1")) != 0 || len(sv.VFsOf("0000:00:1f.3")) != 0 {
+		t.Errorf("unexpected VFs of PF without VFs or not a PF")
+	}
+
+	if pf, ok := sv.PFOf("0000:05:10.0"); !ok || pf.Device.Address() != "0000:05:00.0" {
+		t.Errorf("unexpected PF of VF: %v", pf.Device)
+	}
+	if _, ok := sv.PFOf("0000:00:1f.3"); ok {
+		t.Errorf("unexpected PF of not a VF")
+	}
+}
@@ -16,6 +16,8 @@ var DebugLog DebugLogger = NullDebugLogger
 
 type Tree interface {
 	Add(name string, attrs map[string]string) Tree
+	// AddLink adds a symlink called name pointing to target, which is usually relative
+	AddLink(name, target string) Tree
 	Name() string
 	Items() []Tree
 	SetAttrs() error
@@ -39,6 +41,7 @@ type tree struct {
 	name  string
 	attrs map[string]string
 	items []Tree
+	links map[string]string
 }
 
 func (t *tree) Add(name string, attrs map[string]string) Tree {
@@ -47,6 +50,14 @@ func (t *tree) Add(name string, attrs map[string]string) Tree {
 	return n
 }
 
+func (t *tree) AddLink(name, target string) Tree {
+	if t.links == nil {
+		t.links = make(map[string]string)
+	}
+	t.links[name] = target
+	return t
+}
+
 func (t *tree) Items() []Tree {
 	return t.items
 }
@@ -56,7 +67,10 @@ func (t *tree) Name() string {
 }
 
 func (t *tree) Create() error {
-	return newCreator().Create(t)
+	if err := newCreator().Create(t); err != nil {
+		return err
+	}
+	return t.setLinks()
 }
 
 func (t *tree) Attrs() map[string]string {
@@ -83,6 +97,16 @@ func (t *tree) SetAttrs() error {
 	return err
 }
 
+func (t *tree) setLinks() error {
+	for name, target := range t.links {
+		DebugLog("%q link %q -> %q", t.name, name, target)
+		if err := os.Symlink(target, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func MakeAttrs(attrs map[string]string) map[string]string {
 	resAttrs := make(map[string]string)
 	for key, value := range attrs {
@@ -145,11 +169,21 @@ func (fs *FakeSysfs) AddTree(entries ...string) Tree {
 	DebugLog("%q adding: %v", fs.base, entries)
 	pos := fs.root
 	for _, entry := range entries {
-		pos = pos.Add(entry, nil)
+		pos = findOrAdd(pos, entry)
 	}
 	return pos
 }
 
+// findOrAdd allows to call AddTree many times with shared prefixes
+func findOrAdd(t Tree, name string) Tree {
+	for _, item := range t.Items() {
+		if item.Name() == name {
+			return item
+		}
+	}
+	return t.Add(name, nil)
+}
+
 func (fs *FakeSysfs) Base() string {
 	return fs.base
 }
@@ -104,3 +104,43 @@ func readNodeAttrs(leafPath string) (map[string]string, error) {
 	}
 	return attrs, nil
 }
+
+func TestLinks(t *testing.T) {
+	base, err := ioutil.TempDir("/tmp", "fakesysfs")
+	if err != nil {
+		t.Errorf("error creating temp base dir: %v", err)
+	}
+	fs, err := NewFakeSysfs(base)
+	if err != nil {
+		t.Errorf("error creating fakesysfs: %v", err)
+	}
+	t.Logf("sysfs at %q", fs.Base())
+
+	fs.AddTree("sys", "bus", "pci", "devices").Add("0000:3b:02.1", map[string]string{
+		"numa_node": "1\n",
+	})
+	fs.AddTree("sys", "class", "net").Add("net1", nil).AddLink("device", "../../../bus/pci/devices/0000:3b:02.1")
+	err = fs.Setup()
+	if err != nil {
+		t.Errorf("error setting up fakesysfs: %v", err)
+	}
+
+	defer func() {
+		if _, ok := os.LookupEnv("FAKESYS_TEST_KEEP_TREE"); ok {
+			t.Logf("found environment variable, keeping fake tree")
+		} else {
+			err = fs.Teardown()
+			if err != nil {
+				t.Errorf("error tearing down fakesysfs: %v", err)
+			}
+		}
+	}()
+
+	data, err := ioutil.ReadFile(filepath.Join(fs.Base(), "sys", "class", "net", "net1", "device", "numa_node"))
+	if err != nil {
+		t.Fatalf("error reading through the link: %v", err)
+	}
+	if string(data) != "1\n" {
+		t.Errorf("unexpected content read through the link: %q", string(data))
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/ffromani/cpuset"
@@ -58,6 +59,27 @@ func (p Path) ReadFile(name string) (string, error) {
 	return strings.TrimSpace(string(data)), nil
 }
 
+func (p Path) ReadInt(name string) (int, error) {
+	data, err := p.ReadFile(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(data)
+}
+
+// ReadDir returns the names of the entries in this path, sorted
+func (p Path) ReadDir() ([]string, error) {
+	entries, err := ioutil.ReadDir(p.path)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
 func (p Path) ReadList(name string) ([]int, error) {
 	data, err := p.ReadFile(name)
 	if err != nil {